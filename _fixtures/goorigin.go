@@ -0,0 +1,15 @@
+package main
+
+import "fmt"
+
+func foo(ch chan int) {
+	ch <- 1
+}
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		foo(ch)
+	}()
+	fmt.Println(<-ch)
+}
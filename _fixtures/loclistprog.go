@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+//go:noinline
+func sumsquares(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i * i
+	}
+	return s
+}
+
+func main() {
+	fmt.Println(sumsquares(10))
+}
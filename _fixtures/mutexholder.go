@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var mu sync.Mutex
+
+func waiter(done chan struct{}) {
+	mu.Lock()
+	mu.Unlock()
+	close(done)
+}
+
+//go:noinline
+func holding() {
+	fmt.Println("holding")
+}
+
+func main() {
+	done := make(chan struct{})
+	mu.Lock()
+	go waiter(done)
+	time.Sleep(100 * time.Millisecond)
+	holding()
+	mu.Unlock()
+	<-done
+	fmt.Println("done")
+}
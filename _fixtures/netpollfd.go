@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+)
+
+var listenerFD uintptr
+
+func accepter(l net.Listener) {
+	l.Accept()
+}
+
+func main() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	rc, err := l.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		panic(err)
+	}
+	rc.Control(func(fd uintptr) {
+		listenerFD = fd
+	})
+	go accepter(l)
+	time.Sleep(100 * time.Millisecond) // wait for accepter to block
+	runtime.Breakpoint()
+	fmt.Println(listenerFD)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+func selecter(a, b, c chan int, done chan struct{}) {
+	select {
+	case <-a:
+	case <-b:
+	case c <- 1:
+	}
+	close(done)
+}
+
+func main() {
+	a, b, c := make(chan int), make(chan int), make(chan int)
+	done := make(chan struct{})
+	go selecter(a, b, c, done)
+	time.Sleep(100 * time.Millisecond)
+	runtime.Breakpoint()
+	a <- 1
+	<-done
+}
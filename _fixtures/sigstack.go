@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+func main() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	runtime.LockOSThread()
+	// The signal is sent to this thread, so that the signal handler runs
+	// while main.main is the current goroutine.
+	syscall.Tgkill(os.Getpid(), syscall.Gettid(), syscall.SIGUSR1)
+	fmt.Println(<-c)
+}
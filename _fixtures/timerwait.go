@@ -0,0 +1,16 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+func sleeper() {
+	time.Sleep(time.Hour)
+}
+
+func main() {
+	go sleeper()
+	time.Sleep(100 * time.Millisecond)
+	runtime.Breakpoint()
+}
@@ -226,6 +226,8 @@ substitute-path:
 # show-location-expr: true
 
 # List of directories to use when searching for separate debug info files.
+# If the debug info of the executable can not be found and $DEBUGINFOD_URLS
+# is set it will be downloaded from the debuginfod servers listed there.
 debug-info-directories: ["/usr/lib/debug/.build-id"]
 `)
 	return err
@@ -0,0 +1,56 @@
+package godwarf
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// DebugAddrSection represents the debug_addr section of DWARFv5.
+// See DWARFv5 section 7.27 page 241 and following.
+type DebugAddrSection struct {
+	byteOrder binary.ByteOrder
+	ptrSz     int
+	data      []byte
+}
+
+// ParseAddr parses the header of a debug_addr section.
+func ParseAddr(data []byte, ptrSz int, byteOrder binary.ByteOrder) *DebugAddrSection {
+	if data == nil {
+		return nil
+	}
+	return &DebugAddrSection{byteOrder: byteOrder, ptrSz: ptrSz, data: data}
+}
+
+// GetSubsection returns the subsection of debug_addr starting at addrBase,
+// i.e. the value of the DW_AT_addr_base attribute of a compile unit.
+func (addr *DebugAddrSection) GetSubsection(addrBase uint64) *DebugAddr {
+	if addr == nil {
+		return nil
+	}
+	return &DebugAddr{DebugAddrSection: addr, addrBase: addrBase}
+}
+
+// DebugAddr represents a subsection of the debug_addr section with a specific base address
+type DebugAddr struct {
+	*DebugAddrSection
+	addrBase uint64
+}
+
+// Get returns the address at index idx starting from addrBase.
+func (addr *DebugAddr) Get(idx uint64) (uint64, error) {
+	if addr == nil || addr.DebugAddrSection == nil {
+		return 0, errors.New("debug_addr section not present")
+	}
+	off := idx*uint64(addr.ptrSz) + addr.addrBase
+	if off+uint64(addr.ptrSz) > uint64(len(addr.data)) || off < addr.addrBase {
+		return 0, errors.New("debug_addr index out of bounds")
+	}
+	switch addr.ptrSz {
+	case 4:
+		return uint64(addr.byteOrder.Uint32(addr.data[off:])), nil
+	case 8:
+		return addr.byteOrder.Uint64(addr.data[off:]), nil
+	default:
+		return 0, errors.New("unsupported address size")
+	}
+}
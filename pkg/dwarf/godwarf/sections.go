@@ -16,22 +16,51 @@ import (
 // For example GetDebugSectionElf("line") will return the contents of
 // .debug_line, if .debug_line doesn't exist it will try to return the
 // decompressed contents of .zdebug_line.
+// Both SHF_COMPRESSED sections (see elfSectionData) and legacy .zdebug_
+// sections starting with the "ZLIB" magic are supported, this applies
+// equally to executables and to their separate debug files.
 func GetDebugSectionElf(f *elf.File, name string) ([]byte, error) {
 	sec := f.Section(".debug_" + name)
 	if sec != nil {
-		return sec.Data()
+		return elfSectionData(sec)
 	}
 	sec = f.Section(".zdebug_" + name)
 	if sec == nil {
 		return nil, fmt.Errorf("could not find .debug_%s section", name)
 	}
-	b, err := sec.Data()
+	b, err := elfSectionData(sec)
 	if err != nil {
 		return nil, err
 	}
 	return decompressMaybe(b)
 }
 
+// GetDebugSectionReaderElf is like GetDebugSectionElf but returns a
+// reader for the contents of the section, and their size, so that they can
+// be read on demand instead of being loaded in memory. Compressed sections
+// are still decompressed in memory.
+func GetDebugSectionReaderElf(f *elf.File, name string) (io.ReaderAt, int, error) {
+	sec := f.Section(".debug_" + name)
+	if sec != nil && sec.Flags&elf.SHF_COMPRESSED == 0 && sec.Type != elf.SHT_NOBITS {
+		return sec, int(sec.Size), nil
+	}
+	return sectionReader(GetDebugSectionElf(f, name))
+}
+
+// elfSectionData returns the contents of sec.
+// Sections with the SHF_COMPRESSED flag set start with a compression
+// header and are decompressed by debug/elf itself. ELFCOMPRESS_ZLIB is
+// always supported, ELFCOMPRESS_ZSTD only if the version of Go used to
+// build Delve supports it (Go 1.21 and later), we do not carry a zstd
+// decoder of our own.
+func elfSectionData(sec *elf.Section) ([]byte, error) {
+	b, err := sec.Data()
+	if err != nil && sec.Flags&elf.SHF_COMPRESSED != 0 {
+		return nil, fmt.Errorf("could not decompress section %s: %v", sec.Name, err)
+	}
+	return b, err
+}
+
 // GetDebugSectionPE returns the data contents of the specified debug
 // section, decompressing it if it is compressed.
 // For example GetDebugSectionPE("line") will return the contents of
@@ -53,6 +82,21 @@ func GetDebugSectionPE(f *pe.File, name string) ([]byte, error) {
 	return decompressMaybe(b)
 }
 
+// GetDebugSectionReaderPE is like GetDebugSectionPE but returns a reader
+// for the contents of the section, and their size, see
+// GetDebugSectionReaderElf.
+func GetDebugSectionReaderPE(f *pe.File, name string) (io.ReaderAt, int, error) {
+	sec := f.Section(".debug_" + name)
+	if sec != nil {
+		size := sec.Size
+		if 0 < sec.VirtualSize && sec.VirtualSize < sec.Size {
+			size = sec.VirtualSize
+		}
+		return sec, int(size), nil
+	}
+	return sectionReader(GetDebugSectionPE(f, name))
+}
+
 func peSectionData(sec *pe.Section) ([]byte, error) {
 	b, err := sec.Data()
 	if err != nil {
@@ -85,6 +129,26 @@ func GetDebugSectionMacho(f *macho.File, name string) ([]byte, error) {
 	return decompressMaybe(b)
 }
 
+// GetDebugSectionReaderMacho is like GetDebugSectionMacho but returns a
+// reader for the contents of the section, and their size, see
+// GetDebugSectionReaderElf.
+func GetDebugSectionReaderMacho(f *macho.File, name string) (io.ReaderAt, int, error) {
+	sec := f.Section("__debug_" + name)
+	if sec != nil {
+		return sec, int(sec.Size), nil
+	}
+	return sectionReader(GetDebugSectionMacho(f, name))
+}
+
+// sectionReader returns a reader for the contents of a section that has
+// already been loaded in memory.
+func sectionReader(b []byte, err error) (io.ReaderAt, int, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(b), len(b), nil
+}
+
 func decompressMaybe(b []byte) ([]byte, error) {
 	if len(b) < 12 || string(b[:4]) != "ZLIB" {
 		// not compressed
@@ -0,0 +1,193 @@
+package godwarf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type elfTestSection struct {
+	name  string
+	flags elf.SectionFlag
+	data  []byte
+}
+
+// buildELF returns a little endian ELF64 relocatable file containing sections.
+func buildELF(t *testing.T, sections []elfTestSection) *elf.File {
+	var shstrtab bytes.Buffer
+	shstrtab.WriteByte(0)
+	nameOff := func(name string) uint32 {
+		off := uint32(shstrtab.Len())
+		shstrtab.WriteString(name)
+		shstrtab.WriteByte(0)
+		return off
+	}
+
+	var hdrs []elf.Section64
+	var data bytes.Buffer
+	data.Write(make([]byte, binary.Size(elf.Header64{})))
+	hdrs = append(hdrs, elf.Section64{})
+	for _, sec := range sections {
+		hdrs = append(hdrs, elf.Section64{
+			Name:      nameOff(sec.name),
+			Type:      uint32(elf.SHT_PROGBITS),
+			Flags:     uint64(sec.flags),
+			Off:       uint64(data.Len()),
+			Size:      uint64(len(sec.data)),
+			Addralign: 1,
+		})
+		data.Write(sec.data)
+	}
+	shstrndx := len(hdrs)
+	hdrs = append(hdrs, elf.Section64{
+		Name:      nameOff(".shstrtab"),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       uint64(data.Len()),
+		Addralign: 1,
+	})
+	hdrs[shstrndx].Size = uint64(shstrtab.Len())
+	data.Write(shstrtab.Bytes())
+
+	shoff := data.Len()
+	for _, hdr := range hdrs {
+		binary.Write(&data, binary.LittleEndian, hdr)
+	}
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(shoff),
+		Ehsize:    uint16(binary.Size(elf.Header64{})),
+		Shentsize: uint16(binary.Size(elf.Section64{})),
+		Shnum:     uint16(len(hdrs)),
+		Shstrndx:  uint16(shstrndx),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var hdrbuf bytes.Buffer
+	binary.Write(&hdrbuf, binary.LittleEndian, hdr)
+
+	buf := data.Bytes()
+	copy(buf, hdrbuf.Bytes())
+	f, err := elf.NewFile(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func zlibCompress(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// compressedSection returns the contents of a SHF_COMPRESSED section
+// holding b compressed with zlib, typ is written in the compression header.
+func compressedSection(typ elf.CompressionType, b []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, elf.Chdr64{Type: uint32(typ), Size: uint64(len(b)), Addralign: 1})
+	buf.Write(zlibCompress(b))
+	return buf.Bytes()
+}
+
+// zdebugSection returns the contents of a legacy .zdebug_ section holding b.
+func zdebugSection(b []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("ZLIB")
+	binary.Write(&buf, binary.BigEndian, uint64(len(b)))
+	buf.Write(zlibCompress(b))
+	return buf.Bytes()
+}
+
+func TestGetDebugSectionElf(t *testing.T) {
+	line := []byte("contents of debug_line")
+	info := []byte("contents of debug_info")
+	frame := []byte("contents of debug_frame")
+	f := buildELF(t, []elfTestSection{
+		{".debug_line", elf.SHF_COMPRESSED, compressedSection(elf.COMPRESS_ZLIB, line)},
+		{".zdebug_info", 0, zdebugSection(info)},
+		{".zdebug_frame", 0, frame},
+		{".debug_loc", elf.SHF_COMPRESSED, compressedSection(elf.COMPRESS_LOOS, line)},
+	})
+
+	for _, tc := range []struct {
+		name string
+		tgt  []byte
+	}{
+		{"line", line},
+		{"info", info},
+		{"frame", frame}, // .zdebug_ section without the ZLIB magic
+	} {
+		b, err := GetDebugSectionElf(f, tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(b, tc.tgt) {
+			t.Errorf("%s: expected %q got %q", tc.name, tc.tgt, b)
+		}
+	}
+
+	if _, err := GetDebugSectionElf(f, "loc"); err == nil || !strings.Contains(err.Error(), ".debug_loc") {
+		t.Errorf("expected error decompressing .debug_loc, got %v", err)
+	}
+	if _, err := GetDebugSectionElf(f, "ranges"); err == nil {
+		t.Errorf("expected error for missing section")
+	}
+
+	// Truncated legacy section.
+	f = buildELF(t, []elfTestSection{{".zdebug_info", 0, zdebugSection(info)[:20]}})
+	if _, err := GetDebugSectionElf(f, "info"); err == nil {
+		t.Errorf("expected error for truncated .zdebug_info")
+	}
+}
+
+func TestGetDebugSectionReaderElf(t *testing.T) {
+	loc := []byte("contents of debug_loc")
+	line := []byte("contents of debug_line")
+	info := []byte("contents of debug_info")
+	f := buildELF(t, []elfTestSection{
+		{".debug_loc", 0, loc},
+		{".debug_line", elf.SHF_COMPRESSED, compressedSection(elf.COMPRESS_ZLIB, line)},
+		{".zdebug_info", 0, zdebugSection(info)},
+	})
+
+	for _, tc := range []struct {
+		name string
+		tgt  []byte
+	}{
+		{"loc", loc},
+		{"line", line},
+		{"info", info},
+	} {
+		ra, size, err := GetDebugSectionReaderElf(f, tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if size != len(tc.tgt) {
+			t.Errorf("%s: expected size %d got %d", tc.name, len(tc.tgt), size)
+			continue
+		}
+		b := make([]byte, size)
+		if _, err := ra.ReadAt(b, 0); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(b, tc.tgt) {
+			t.Errorf("%s: expected %q got %q", tc.name, tc.tgt, b)
+		}
+	}
+
+	if ra, _, err := GetDebugSectionReaderElf(f, "loclists"); err == nil || ra != nil {
+		t.Errorf("expected error for missing section")
+	}
+}
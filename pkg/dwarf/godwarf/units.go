@@ -0,0 +1,71 @@
+package godwarf
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+)
+
+// DWARF 5 unit types, see DWARFv5 section 7.5.1.
+const (
+	_DW_UT_compile       = 0x01
+	_DW_UT_type          = 0x02
+	_DW_UT_partial       = 0x03
+	_DW_UT_skeleton      = 0x04
+	_DW_UT_split_compile = 0x05
+	_DW_UT_split_type    = 0x06
+)
+
+// ReadUnitVersions reads the headers of the units contained in the
+// .debug_info section data and returns a map from the offset of the first
+// entry of each unit (i.e. the offset of the compile unit entry as
+// reported by debug/dwarf) to the DWARF version of the unit.
+// Parsing stops at the first malformed header.
+func ReadUnitVersions(data []byte, order binary.ByteOrder) map[dwarf.Offset]uint8 {
+	r := make(map[dwarf.Offset]uint8)
+	off := 0
+	for off+4 <= len(data) {
+		unitLength := uint64(order.Uint32(data[off:]))
+		dwarf64 := false
+		hdrsz := 4
+		if unitLength == 0xffffffff {
+			if off+12 > len(data) {
+				break
+			}
+			dwarf64 = true
+			unitLength = order.Uint64(data[off+4:])
+			hdrsz = 12
+		}
+		end := uint64(off) + uint64(hdrsz) + unitLength
+		if end > uint64(len(data)) || off+hdrsz+2 > len(data) {
+			break
+		}
+		version := order.Uint16(data[off+hdrsz:])
+
+		offsz := 4
+		if dwarf64 {
+			offsz = 8
+		}
+
+		var n int // size of the rest of the header after the version field
+		if version >= 5 {
+			if off+hdrsz+3 > len(data) {
+				break
+			}
+			// unit_type, address_size, debug_abbrev_offset
+			n = 2 + offsz
+			switch data[off+hdrsz+2] {
+			case _DW_UT_skeleton, _DW_UT_split_compile:
+				n += 8 // dwo_id
+			case _DW_UT_type, _DW_UT_split_type:
+				n += 8 + offsz // type_signature, type_offset
+			}
+		} else {
+			// debug_abbrev_offset, address_size
+			n = offsz + 1
+		}
+
+		r[dwarf.Offset(off+hdrsz+2+n)] = uint8(version)
+		off = int(end)
+	}
+	return r
+}
@@ -1,37 +1,230 @@
 package loclist
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/op"
 )
 
+// ErrTruncated is returned by Err when an entry extends past the end of
+// the loclist section.
+var ErrTruncated = errors.New("truncated loclist entry")
+
+// ErrAddrIndex is returned by Err when a DWARF 5 entry refers to an
+// address through an index into .debug_addr and no address resolver was
+// set with SetAddrResolver.
+var ErrAddrIndex = errors.New("loclist entry uses an address index but no .debug_addr section is available")
+
+// AddrResolver returns the address stored at index of the .debug_addr
+// section, relative to the DW_AT_addr_base of the current compile unit.
+type AddrResolver func(index uint64) (uint64, error)
+
+// Location list entry kinds of DWARF 5 .debug_loclists, see DWARFv5
+// section 7.7.3.
+const (
+	_DW_LLE_end_of_list      uint8 = 0x00
+	_DW_LLE_base_addressx    uint8 = 0x01
+	_DW_LLE_startx_endx      uint8 = 0x02
+	_DW_LLE_startx_length    uint8 = 0x03
+	_DW_LLE_offset_pair      uint8 = 0x04
+	_DW_LLE_default_location uint8 = 0x05
+	_DW_LLE_base_address     uint8 = 0x06
+	_DW_LLE_start_end        uint8 = 0x07
+	_DW_LLE_start_length     uint8 = 0x08
+)
+
+// readerAtWindowSize is the minimum number of bytes read at a time by
+// readers created with NewReaderAt.
+const readerAtWindowSize = 4096
+
 // Reader parses and presents DWARF loclist information.
+//
+// A Reader keeps the current position in the section and is not safe for
+// concurrent use. The data of the section is never modified, to decode
+// location lists concurrently each goroutine should use its own Clone of
+// a Reader.
 type Reader struct {
-	data  []byte
-	cur   int
-	ptrSz int
+	data      []byte
+	cur       int
+	ptrSz     int
+	byteOrder binary.ByteOrder
+	version   int
+	err       error
+
+	// base is the base address set by the last base address selection
+	// entry read since the last call to Seek, if hasBase is true.
+	base    uint64
+	hasBase bool
+
+	// ra, if not nil, is used to read the section on demand instead of data,
+	// window contains the bytes of the section starting at windowOff.
+	ra        io.ReaderAt
+	size      int
+	window    []byte
+	windowOff int
+
+	resolveAddr AddrResolver
+
+	// staticBase and cuBase are the addresses set by SetBase, used by
+	// FindEntry.
+	staticBase, cuBase uint64
+
+	// countOnly is set by CountEntries: location expressions are skipped
+	// and address indices are not resolved.
+	countOnly bool
 }
 
-// New returns an initialized loclist Reader.
-func New(data []byte, ptrSz int) *Reader {
-	return &Reader{data: data, ptrSz: ptrSz}
+// New returns an initialized loclist Reader for the contents of a
+// .debug_loc section (DWARF 2 to 4). An error is returned if ptrSz is not
+// a supported pointer size (4 or 8).
+func New(data []byte, ptrSz int, byteOrder binary.ByteOrder) (*Reader, error) {
+	if err := checkPtrSize(ptrSz); err != nil {
+		return nil, err
+	}
+	return &Reader{data: data, ptrSz: ptrSz, byteOrder: byteOrder, version: 2}, nil
+}
+
+// NewDwarf5 returns an initialized loclist Reader for the contents of a
+// .debug_loclists section (DWARF 5). An error is returned if ptrSz is not
+// a supported pointer size (4 or 8).
+func NewDwarf5(data []byte, ptrSz int, byteOrder binary.ByteOrder) (*Reader, error) {
+	if err := checkPtrSize(ptrSz); err != nil {
+		return nil, err
+	}
+	return &Reader{data: data, ptrSz: ptrSz, byteOrder: byteOrder, version: 5}, nil
+}
+
+// NewReaderAt returns a loclist Reader for a .debug_loc section (DWARF 2
+// to 4) of the specified size, which is read from ra on demand instead of
+// being loaded in memory.
+func NewReaderAt(ra io.ReaderAt, size, ptrSz int, byteOrder binary.ByteOrder) (*Reader, error) {
+	rdr, err := New(nil, ptrSz, byteOrder)
+	if err != nil {
+		return nil, err
+	}
+	rdr.ra, rdr.size = ra, size
+	return rdr, nil
+}
+
+// NewDwarf5ReaderAt is like NewReaderAt but for .debug_loclists sections
+// (DWARF 5).
+func NewDwarf5ReaderAt(ra io.ReaderAt, size, ptrSz int, byteOrder binary.ByteOrder) (*Reader, error) {
+	rdr, err := NewDwarf5(nil, ptrSz, byteOrder)
+	if err != nil {
+		return nil, err
+	}
+	rdr.ra, rdr.size = ra, size
+	return rdr, nil
+}
+
+func checkPtrSize(ptrSz int) error {
+	if ptrSz != 4 && ptrSz != 8 {
+		return fmt.Errorf("unsupported pointer size %d", ptrSz)
+	}
+	return nil
 }
 
 // Empty returns true if this reader has no data.
 func (rdr *Reader) Empty() bool {
-	return rdr.data == nil
+	return rdr == nil || (rdr.data == nil && rdr.ra == nil)
+}
+
+// SetAddrResolver sets the function used to resolve the address indices
+// of DWARF 5 entries (DW_LLE_base_addressx, DW_LLE_startx_endx and
+// DW_LLE_startx_length). Since indices are relative to the compile unit
+// the resolver should be changed every time the reader is used for the
+// location lists of a different compile unit.
+func (rdr *Reader) SetAddrResolver(resolveAddr AddrResolver) {
+	rdr.resolveAddr = resolveAddr
+}
+
+// SetBase sets the addresses used by FindEntry to compute absolute address
+// ranges: base is the initial base address of location lists (usually the
+// low PC of the compile unit) and staticBase is added to all addresses.
+// Like the address resolver it should be changed every time the reader is
+// used for the location lists of a different compile unit.
+func (rdr *Reader) SetBase(staticBase, base uint64) {
+	rdr.staticBase, rdr.cuBase = staticBase, base
+}
+
+// Clone returns a new reader over the same data, positioned at the same
+// offset, which can be moved independently of rdr. Clones can be used
+// concurrently with each other and with rdr, as long as the address
+// resolver they share (see SetAddrResolver) is safe for concurrent use.
+func (rdr *Reader) Clone() *Reader {
+	r := *rdr
+	return &r
+}
+
+// Tell returns the current offset of the data pointer.
+func (rdr *Reader) Tell() int {
+	return rdr.cur
 }
 
 // Seek moves the data pointer to the specified offset.
 func (rdr *Reader) Seek(off int) {
 	rdr.cur = off
+	rdr.err = nil
+	rdr.base = 0
+	rdr.hasBase = false
 }
 
 // Next advances the reader to the next loclist entry, returning
 // the entry and true if successful, or nil, false if not.
+// When Next returns false callers should check Err to distinguish
+// between the end of the list and malformed data.
 func (rdr *Reader) Next(e *Entry) bool {
+	var ok bool
+	if rdr.version >= 5 {
+		ok = rdr.next5(e)
+	} else {
+		ok = rdr.next2(e)
+	}
+	e.ptrSz = rdr.ptrSz
+	if ok && e.BaseAddressSelection() {
+		rdr.base = e.HighPC
+		rdr.hasBase = true
+	}
+	return ok
+}
+
+// AbsoluteRange returns the address range of entry e, which must be the
+// last entry returned by Next, resolving entries relative to the base
+// address: the base address is the one set by the last base address
+// selection entry of the location list or, if there wasn't any, base
+// (which should be the low PC of the compile unit).
+func (rdr *Reader) AbsoluteRange(base uint64, e *Entry) (lo, hi uint64) {
+	if e.Absolute {
+		return e.LowPC, e.HighPC
+	}
+	if rdr.hasBase {
+		base = rdr.base
+	}
+	return e.LowPC + base, e.HighPC + base
+}
+
+func (rdr *Reader) next2(e *Entry) bool {
+	e.Absolute = false
+	e.defaultLocation = false
 	e.LowPC = rdr.oneAddr()
 	e.HighPC = rdr.oneAddr()
 
+	if rdr.err != nil {
+		return false
+	}
+
+	if rdr.ptrSz == 4 && e.LowPC == uint64(^uint32(0)) {
+		// On 32bit targets base address selection entries have a low address
+		// of 0xffffffff, the high address is the new base address and is
+		// used as is.
+		e.LowPC = ^uint64(0)
+	}
+
 	if e.LowPC == 0 && e.HighPC == 0 {
 		return false
 	}
@@ -41,37 +234,322 @@ func (rdr *Reader) Next(e *Entry) bool {
 		return true
 	}
 
-	instrlen := binary.LittleEndian.Uint16(rdr.read(2))
-	e.Instr = rdr.read(int(instrlen))
-	return true
+	buf := rdr.read(2)
+	if buf == nil {
+		return false
+	}
+	instrlen := rdr.byteOrder.Uint16(buf)
+	e.Instr = rdr.instr(int(instrlen))
+	return rdr.err == nil
+}
+
+func (rdr *Reader) next5(e *Entry) bool {
+	buf := rdr.read(1)
+	if buf == nil {
+		return false
+	}
+	e.Absolute = false
+	e.defaultLocation = false
+	e.Instr = nil
+
+	switch kind := buf[0]; kind {
+	case _DW_LLE_end_of_list:
+		// Unlike DWARF 2, an entry with both addresses set to zero does not
+		// terminate the list, only DW_LLE_end_of_list does.
+		return false
+
+	case _DW_LLE_base_address:
+		e.LowPC = ^uint64(0)
+		e.HighPC = rdr.oneAddr()
+		return rdr.err == nil
+
+	case _DW_LLE_offset_pair:
+		e.LowPC = rdr.uleb128()
+		e.HighPC = rdr.uleb128()
+
+	case _DW_LLE_start_end:
+		e.Absolute = true
+		e.LowPC = rdr.oneAddr()
+		e.HighPC = rdr.oneAddr()
+
+	case _DW_LLE_start_length:
+		e.Absolute = true
+		e.LowPC = rdr.oneAddr()
+		e.HighPC = e.LowPC + rdr.uleb128()
+
+	case _DW_LLE_default_location:
+		e.Absolute = true
+		e.defaultLocation = true
+		e.LowPC = 0
+		e.HighPC = ^uint64(0)
+
+	case _DW_LLE_base_addressx:
+		e.LowPC = ^uint64(0)
+		e.HighPC = rdr.addrx()
+		return rdr.err == nil
+
+	case _DW_LLE_startx_endx:
+		e.Absolute = true
+		e.LowPC = rdr.addrx()
+		e.HighPC = rdr.addrx()
+
+	case _DW_LLE_startx_length:
+		e.Absolute = true
+		e.LowPC = rdr.addrx()
+		e.HighPC = e.LowPC + rdr.uleb128()
+
+	default:
+		rdr.err = fmt.Errorf("unknown loclist entry kind %#x at offset %#x", kind, rdr.cur-1)
+		return false
+	}
+
+	instrlen := rdr.uleb128()
+	if rdr.err != nil {
+		return false
+	}
+	e.Instr = rdr.instr(int(instrlen))
+	return rdr.err == nil
+}
+
+func (rdr *Reader) uleb128() uint64 {
+	var r uint64
+	var shift uint
+	for {
+		buf := rdr.read(1)
+		if buf == nil {
+			return 0
+		}
+		r |= uint64(buf[0]&0x7f) << shift
+		if buf[0]&0x80 == 0 {
+			return r
+		}
+		shift += 7
+	}
+}
+
+// FindEntry seeks to off and returns the entry of the location list that
+// covers pc. The address ranges of the returned entry are absolute, see
+// SetBase.
+// If no entry covers pc false is returned, callers should check Err to
+// determine whether the location list was malformed.
+func (rdr *Reader) FindEntry(off int, pc uint64) (*Entry, bool) {
+	rdr.Seek(off)
+	var e Entry
+	var defaultEntry *Entry
+	for rdr.Next(&e) {
+		if e.BaseAddressSelection() {
+			continue
+		}
+		if e.defaultLocation {
+			// The default location applies to all addresses not covered by
+			// other entries of the list.
+			defaultEntry = &Entry{}
+			*defaultEntry = e
+			continue
+		}
+		e.LowPC, e.HighPC = rdr.AbsoluteRange(rdr.cuBase, &e)
+		e.LowPC += rdr.staticBase
+		e.HighPC += rdr.staticBase
+		e.Absolute = true
+		if e.Contains(pc) {
+			return &e, true
+		}
+	}
+	if defaultEntry != nil && rdr.err == nil {
+		return defaultEntry, true
+	}
+	return nil, false
+}
+
+// Ranges seeks to off and returns all the entries of the location list,
+// except base address selection entries, with absolute address ranges.
+// Base is the initial base address of the location list.
+// The location expressions of the returned entries are copies, they do not
+// reference the data of the reader.
+// If the location list is malformed the entries read before the error are
+// returned, callers should check Err.
+func (rdr *Reader) Ranges(off int, base uint64) []Entry {
+	rdr.Seek(off)
+	r := []Entry{}
+	var e Entry
+	for rdr.Next(&e) {
+		if e.BaseAddressSelection() {
+			continue
+		}
+		if !e.defaultLocation {
+			e.LowPC, e.HighPC = rdr.AbsoluteRange(base, &e)
+			e.Absolute = true
+		}
+		e.Instr = append([]byte(nil), e.Instr...)
+		r = append(r, e)
+	}
+	return r
+}
+
+// CountEntries returns the number of entries of the location list at
+// off, including base address selection entries. The location expressions
+// are skipped rather than read and, for DWARF 5, address indices are not
+// resolved. The position of the reader is restored before returning.
+// If the location list is malformed an error is returned.
+func (rdr *Reader) CountEntries(off int) (int, error) {
+	cur, err, base, hasBase := rdr.cur, rdr.err, rdr.base, rdr.hasBase
+	rdr.Seek(off)
+	rdr.countOnly = true
+	n := 0
+	var e Entry
+	for rdr.Next(&e) {
+		n++
+	}
+	countErr := rdr.err
+	rdr.cur, rdr.err, rdr.base, rdr.hasBase = cur, err, base, hasBase
+	rdr.countOnly = false
+	return n, countErr
+}
+
+// ListOffset returns the offset of the location list at index idx of the
+// offsets table starting at loclistsBase (the value of the
+// DW_AT_loclists_base attribute of the compile unit), which is how
+// attributes with form DW_FORM_loclistx are resolved. Only the 32bit
+// DWARF format is supported. The current position of the reader is not
+// changed.
+func (rdr *Reader) ListOffset(loclistsBase, idx uint64) (int, error) {
+	if rdr.version < 5 {
+		return 0, errors.New("location list indices can only be used with .debug_loclists")
+	}
+	off := loclistsBase + idx*4
+	if idx > uint64(^uint32(0)) || off < loclistsBase || off > uint64(^uint32(0)) {
+		return 0, ErrTruncated
+	}
+	cur, err := rdr.cur, rdr.err
+	rdr.cur, rdr.err = int(off), nil
+	buf := rdr.read(4)
+	rerr := rdr.err
+	rdr.cur, rdr.err = cur, err
+	if buf == nil {
+		return 0, rerr
+	}
+	return int(loclistsBase) + int(rdr.byteOrder.Uint32(buf)), nil
+}
+
+// Err returns the error, if any, that caused the last call to Next to
+// return false.
+func (rdr *Reader) Err() error {
+	return rdr.err
 }
 
 func (rdr *Reader) read(sz int) []byte {
+	if !rdr.checkRead(sz) {
+		return nil
+	}
+	if rdr.ra != nil {
+		return rdr.readAt(sz)
+	}
 	r := rdr.data[rdr.cur : rdr.cur+sz]
 	rdr.cur += sz
 	return r
 }
 
-func (rdr *Reader) oneAddr() uint64 {
-	switch rdr.ptrSz {
-	case 4:
-		addr := binary.LittleEndian.Uint32(rdr.read(rdr.ptrSz))
-		if addr == ^uint32(0) {
-			return ^uint64(0)
+// instr reads a location expression of sz bytes, or skips it if the
+// reader is only counting entries.
+func (rdr *Reader) instr(sz int) []byte {
+	if rdr.countOnly {
+		if rdr.checkRead(sz) {
+			rdr.cur += sz
 		}
-		return uint64(addr)
-	case 8:
-		addr := uint64(binary.LittleEndian.Uint64(rdr.read(rdr.ptrSz)))
-		return addr
-	default:
-		panic("bad address size")
+		return nil
 	}
+	return rdr.read(sz)
+}
+
+// checkRead returns true if sz bytes can be read at the current position,
+// otherwise it sets the error of the reader and returns false.
+func (rdr *Reader) checkRead(sz int) bool {
+	if rdr.err != nil {
+		return false
+	}
+	size := len(rdr.data)
+	if rdr.ra != nil {
+		size = rdr.size
+	}
+	// sz can come from a length field of the section, check it without
+	// overflowing.
+	if rdr.cur < 0 || sz < 0 || sz > size-rdr.cur {
+		rdr.err = ErrTruncated
+		return false
+	}
+	return true
+}
+
+// readAt is the implementation of read for readers created with
+// NewReaderAt, the bounds must have been checked by checkRead.
+func (rdr *Reader) readAt(sz int) []byte {
+	if rdr.cur < rdr.windowOff || rdr.cur+sz > rdr.windowOff+len(rdr.window) {
+		// A new buffer is allocated every time, slices returned by previous
+		// calls (for example Entry.Instr) must remain valid.
+		n := readerAtWindowSize
+		if sz > n {
+			n = sz
+		}
+		if rdr.cur+n > rdr.size {
+			n = rdr.size - rdr.cur
+		}
+		window := make([]byte, n)
+		if _, err := rdr.ra.ReadAt(window, int64(rdr.cur)); err != nil && err != io.EOF {
+			rdr.err = err
+			return nil
+		}
+		rdr.window, rdr.windowOff = window, rdr.cur
+	}
+	start := rdr.cur - rdr.windowOff
+	rdr.cur += sz
+	return rdr.window[start : start+sz : start+sz]
+}
+
+// addrx reads an index into .debug_addr and resolves it.
+func (rdr *Reader) addrx() uint64 {
+	idx := rdr.uleb128()
+	if rdr.err != nil || rdr.countOnly {
+		return 0
+	}
+	if rdr.resolveAddr == nil {
+		rdr.err = ErrAddrIndex
+		return 0
+	}
+	addr, err := rdr.resolveAddr(idx)
+	if err != nil {
+		rdr.err = fmt.Errorf("could not resolve address index %d: %v", idx, err)
+		return 0
+	}
+	return addr
+}
+
+func (rdr *Reader) oneAddr() uint64 {
+	if err := checkPtrSize(rdr.ptrSz); err != nil {
+		// only possible for readers not created by New or NewDwarf5
+		rdr.err = err
+		return 0
+	}
+	buf := rdr.read(rdr.ptrSz)
+	if buf == nil {
+		return 0
+	}
+	if rdr.ptrSz == 4 {
+		return uint64(rdr.byteOrder.Uint32(buf))
+	}
+	return rdr.byteOrder.Uint64(buf)
 }
 
 // Entry represents a single entry in the loclist section.
 type Entry struct {
 	LowPC, HighPC uint64
 	Instr         []byte
+
+	// Absolute is true if LowPC and HighPC are addresses rather than offsets
+	// from the current base address, this only happens for DWARF 5 entries.
+	Absolute bool
+
+	defaultLocation bool // DWARF 5 default location entry
+	ptrSz           int  // pointer size of the location list the entry was read from
 }
 
 // BaseAddressSelection returns true if entry.highpc should
@@ -79,3 +557,34 @@ type Entry struct {
 func (e *Entry) BaseAddressSelection() bool {
 	return e.LowPC == ^uint64(0)
 }
+
+// String returns a human readable description of the entry, with its
+// location expression disassembled.
+func (e *Entry) String() string {
+	if e.BaseAddressSelection() {
+		return fmt.Sprintf("base = %#x", e.HighPC)
+	}
+	var buf bytes.Buffer
+	switch {
+	case e.defaultLocation:
+		buf.WriteString("default")
+	case e.Absolute:
+		fmt.Fprintf(&buf, "[%#x, %#x)", e.LowPC, e.HighPC)
+	default:
+		fmt.Fprintf(&buf, "[base+%#x, base+%#x)", e.LowPC, e.HighPC)
+	}
+	buf.WriteString(": ")
+	ptrSz := e.ptrSz
+	if ptrSz == 0 {
+		// not read by a Reader
+		ptrSz = 8
+	}
+	op.PrettyPrint(&buf, e.Instr, ptrSz)
+	return strings.TrimSpace(buf.String())
+}
+
+// Contains returns true if pc is in the half-open range [LowPC, HighPC).
+// Base address selection entries do not contain any address.
+func (e *Entry) Contains(pc uint64) bool {
+	return !e.BaseAddressSelection() && pc >= e.LowPC && pc < e.HighPC
+}
@@ -0,0 +1,908 @@
+package loclist
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/dwarf/op"
+)
+
+func writeEntry(buf *bytes.Buffer, lowpc, highpc uint64, instr []byte) {
+	writeEntryOrder(buf, binary.LittleEndian, lowpc, highpc, instr)
+}
+
+func writeEntryOrder(buf *bytes.Buffer, order binary.ByteOrder, lowpc, highpc uint64, instr []byte) {
+	binary.Write(buf, order, lowpc)
+	binary.Write(buf, order, highpc)
+	if (lowpc == 0 && highpc == 0) || lowpc == ^uint64(0) {
+		return
+	}
+	binary.Write(buf, order, uint16(len(instr)))
+	buf.Write(instr)
+}
+
+func TestLoclistTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51, 0x52})
+
+	data := buf.Bytes()
+
+	for n := 0; n < len(data); n++ {
+		rdr := mustNew(t, data[:n], 8, binary.LittleEndian)
+		var e Entry
+		for rdr.Next(&e) {
+		}
+		if rdr.Err() != ErrTruncated {
+			t.Errorf("length %d: expected truncation error, got %v", n, rdr.Err())
+		}
+	}
+
+	writeEntry(&buf, 0, 0, nil)
+	rdr := mustNew(t, buf.Bytes(), 8, binary.LittleEndian)
+	var e Entry
+	cnt := 0
+	for rdr.Next(&e) {
+		cnt++
+	}
+	if rdr.Err() != nil {
+		t.Fatalf("unexpected error: %v", rdr.Err())
+	}
+	if cnt != 2 {
+		t.Fatalf("wrong number of entries %d", cnt)
+	}
+}
+
+func TestLoclistBadPtrSize(t *testing.T) {
+	// A reader not created by New has no valid pointer size, reading from
+	// it must fail instead of panicking.
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	rdr := &Reader{data: buf.Bytes(), byteOrder: binary.LittleEndian, version: 2}
+	var e Entry
+	if rdr.Next(&e) || rdr.Err() == nil {
+		t.Errorf("expected error, got %v", rdr.Err())
+	}
+}
+
+func TestLoclistHugeLength(t *testing.T) {
+	// An instruction length that doesn't fit in an int must not cause a panic.
+	data := []byte{_DW_LLE_offset_pair, 0x00, 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x50}
+	rdrAt, err := NewDwarf5ReaderAt(bytes.NewReader(data), len(data), 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rdr := range []*Reader{mustNewDwarf5(t, data, 8, binary.LittleEndian), rdrAt} {
+		var e Entry
+		if rdr.Next(&e) || rdr.Err() != ErrTruncated {
+			t.Errorf("expected truncation error, got %v", rdr.Err())
+		}
+	}
+}
+
+func TestLoclistRandom(t *testing.T) {
+	// Next must never panic, no matter what the contents of the section are.
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 10000; i++ {
+		data := make([]byte, rng.Intn(64))
+		rng.Read(data)
+		for _, ptrSz := range []int{4, 8} {
+			rdr2 := mustNew(t, data, ptrSz, binary.LittleEndian)
+			rdr5 := mustNewDwarf5(t, data, ptrSz, binary.LittleEndian)
+			rdr5.SetAddrResolver(func(idx uint64) (uint64, error) { return idx, nil })
+			for _, rdr := range []*Reader{rdr2, rdr5} {
+				var e Entry
+				for n := 0; rdr.Next(&e); n++ {
+					if n > len(data) {
+						t.Fatalf("too many entries reading %x", data)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLoclistDwarf5(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(_DW_LLE_base_address)
+	binary.Write(&buf, binary.LittleEndian, uint64(0x1000))
+	buf.WriteByte(_DW_LLE_offset_pair)
+	buf.Write([]byte{0x10, 0x20})
+	buf.Write([]byte{0x01, 0x50})
+	buf.WriteByte(_DW_LLE_start_length)
+	binary.Write(&buf, binary.LittleEndian, uint64(0x2000))
+	buf.Write([]byte{0x80, 0x01})
+	buf.Write([]byte{0x02, 0x51, 0x52})
+	buf.WriteByte(_DW_LLE_start_end)
+	binary.Write(&buf, binary.LittleEndian, uint64(0x3000))
+	binary.Write(&buf, binary.LittleEndian, uint64(0x3010))
+	buf.Write([]byte{0x01, 0x53})
+	buf.WriteByte(_DW_LLE_end_of_list)
+
+	tgt := []Entry{
+		{LowPC: ^uint64(0), HighPC: 0x1000},
+		{LowPC: 0x10, HighPC: 0x20, Instr: []byte{0x50}},
+		{LowPC: 0x2000, HighPC: 0x2080, Instr: []byte{0x51, 0x52}, Absolute: true},
+		{LowPC: 0x3000, HighPC: 0x3010, Instr: []byte{0x53}, Absolute: true},
+	}
+
+	rdr := mustNewDwarf5(t, buf.Bytes(), 8, binary.LittleEndian)
+	var e Entry
+	i := 0
+	for rdr.Next(&e) {
+		if i >= len(tgt) {
+			t.Fatalf("too many entries")
+		}
+		if e.LowPC != tgt[i].LowPC || e.HighPC != tgt[i].HighPC || !bytes.Equal(e.Instr, tgt[i].Instr) || e.Absolute != tgt[i].Absolute {
+			t.Errorf("entry %d: expected %#v got %#v", i, tgt[i], e)
+		}
+		i++
+	}
+	if rdr.Err() != nil {
+		t.Fatalf("unexpected error: %v", rdr.Err())
+	}
+	if i != len(tgt) {
+		t.Fatalf("wrong number of entries %d", i)
+	}
+
+	rdr = mustNewDwarf5(t, []byte{_DW_LLE_startx_length, 0x01, 0x10, 0x01, 0x50}, 8, binary.LittleEndian)
+	if rdr.Next(&e) || rdr.Err() != ErrAddrIndex {
+		t.Fatalf("expected address index error, got %v", rdr.Err())
+	}
+}
+
+func TestLoclistDwarf5ZeroAddress(t *testing.T) {
+	// Entries covering [0, N) must not be mistaken for the end of the list.
+	var buf bytes.Buffer
+	buf.WriteByte(_DW_LLE_offset_pair)
+	buf.Write([]byte{0x00, 0x10})
+	buf.Write([]byte{0x01, 0x50})
+	buf.WriteByte(_DW_LLE_start_end)
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	buf.Write([]byte{0x01, 0x51})
+	buf.WriteByte(_DW_LLE_start_length)
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	buf.Write([]byte{0x20})
+	buf.Write([]byte{0x01, 0x52})
+	buf.WriteByte(_DW_LLE_end_of_list)
+
+	rdr := mustNewDwarf5(t, buf.Bytes(), 8, binary.LittleEndian)
+	var e Entry
+	n := 0
+	for rdr.Next(&e) {
+		n++
+	}
+	if rdr.Err() != nil {
+		t.Fatalf("unexpected error: %v", rdr.Err())
+	}
+	if n != 3 {
+		t.Fatalf("wrong number of entries %d", n)
+	}
+
+	pe, ok := rdr.FindEntry(0, 0x8)
+	if !ok || pe.LowPC != 0 || pe.HighPC != 0x10 || !bytes.Equal(pe.Instr, []byte{0x50}) {
+		t.Fatalf("wrong entry for 0x8: %#v %v (%v)", pe, ok, rdr.Err())
+	}
+	pe, ok = rdr.FindEntry(0, 0x18)
+	if !ok || pe.LowPC != 0 || pe.HighPC != 0x20 || !bytes.Equal(pe.Instr, []byte{0x52}) {
+		t.Fatalf("wrong entry for 0x18: %#v %v (%v)", pe, ok, rdr.Err())
+	}
+}
+
+func TestLoclistAddrIndex(t *testing.T) {
+	addrs := []uint64{0x1000, 0x2000, 0x2010, 0x3000}
+	resolve := func(idx uint64) (uint64, error) {
+		if idx >= uint64(len(addrs)) {
+			return 0, fmt.Errorf("index %d out of bounds", idx)
+		}
+		return addrs[idx], nil
+	}
+
+	data := []byte{
+		_DW_LLE_base_addressx, 0x00,
+		_DW_LLE_offset_pair, 0x10, 0x20, 0x01, 0x50,
+		_DW_LLE_startx_endx, 0x01, 0x02, 0x01, 0x51,
+		_DW_LLE_startx_length, 0x03, 0x08, 0x01, 0x52,
+		_DW_LLE_end_of_list,
+	}
+	rdr := mustNewDwarf5(t, data, 8, binary.LittleEndian)
+	rdr.SetAddrResolver(resolve)
+
+	for _, tc := range []struct {
+		pc          uint64
+		instr       byte
+		lowpc, high uint64
+	}{
+		{0x1015, 0x50, 0x1010, 0x1020},
+		{0x2008, 0x51, 0x2000, 0x2010},
+		{0x3004, 0x52, 0x3000, 0x3008},
+	} {
+		e, ok := rdr.FindEntry(0, tc.pc)
+		if !ok {
+			t.Fatalf("no entry for %#x: %v", tc.pc, rdr.Err())
+		}
+		if e.LowPC != tc.lowpc || e.HighPC != tc.high || !bytes.Equal(e.Instr, []byte{tc.instr}) {
+			t.Errorf("wrong entry for %#x: %#v", tc.pc, e)
+		}
+	}
+
+	rdr = mustNewDwarf5(t, []byte{_DW_LLE_startx_endx, 0x07, 0x01, 0x01, 0x50}, 8, binary.LittleEndian)
+	rdr.SetAddrResolver(resolve)
+	var e Entry
+	if rdr.Next(&e) || rdr.Err() == nil {
+		t.Fatalf("expected error for out of bounds address index")
+	}
+}
+
+func TestLoclistFindEntry(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, ^uint64(0), 0x1000, nil)
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51})
+	writeEntry(&buf, 0, 0, nil)
+
+	rdr := mustNew(t, buf.Bytes(), 8, binary.LittleEndian)
+	rdr.SetBase(0x100, 0x300)
+
+	for _, tc := range []struct {
+		pc    uint64
+		instr []byte
+	}{
+		{0x410, []byte{0x50}},
+		{0x41f, []byte{0x50}},
+		{0x420, nil},
+		{0x1120, []byte{0x51}},
+		{0x112f, []byte{0x51}},
+		{0x1130, nil},
+	} {
+		e, ok := rdr.FindEntry(0, tc.pc)
+		if rdr.Err() != nil {
+			t.Fatalf("unexpected error: %v", rdr.Err())
+		}
+		if ok != (tc.instr != nil) {
+			t.Errorf("%#x: expected found=%v got %v", tc.pc, tc.instr != nil, ok)
+			continue
+		}
+		if ok && (!bytes.Equal(e.Instr, tc.instr) || !e.Contains(tc.pc)) {
+			t.Errorf("%#x: wrong entry %#v", tc.pc, e)
+		}
+	}
+
+	e := Entry{LowPC: ^uint64(0), HighPC: 0x1000}
+	if e.Contains(^uint64(0)) || e.Contains(0x1000) {
+		t.Errorf("base address selection entry contains address")
+	}
+}
+
+func TestLoclistByteOrder(t *testing.T) {
+	readAll := func(order binary.ByteOrder) []Entry {
+		var buf bytes.Buffer
+		writeEntryOrder(&buf, order, 0x10, 0x20, []byte{0x50})
+		writeEntryOrder(&buf, order, ^uint64(0), 0x1000, nil)
+		writeEntryOrder(&buf, order, 0x20, 0x30, []byte{0x51, 0x52})
+		writeEntryOrder(&buf, order, 0, 0, nil)
+
+		rdr := mustNew(t, buf.Bytes(), 8, order)
+		r := []Entry{}
+		var e Entry
+		for rdr.Next(&e) {
+			r = append(r, e)
+		}
+		if rdr.Err() != nil {
+			t.Fatalf("%v: unexpected error: %v", order, rdr.Err())
+		}
+		return r
+	}
+
+	le := readAll(binary.LittleEndian)
+	be := readAll(binary.BigEndian)
+
+	if len(le) != 3 || len(be) != len(le) {
+		t.Fatalf("wrong number of entries: %d %d", len(le), len(be))
+	}
+	for i := range le {
+		if le[i].LowPC != be[i].LowPC || le[i].HighPC != be[i].HighPC || !bytes.Equal(le[i].Instr, be[i].Instr) {
+			t.Errorf("entry %d mismatch: %#v %#v", i, le[i], be[i])
+		}
+	}
+	if le[2].LowPC != 0x20 || le[2].HighPC != 0x30 || !bytes.Equal(le[2].Instr, []byte{0x51, 0x52}) {
+		t.Errorf("wrong entry: %#v", le[2])
+	}
+}
+
+func TestLoclistClone(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51})
+	writeEntry(&buf, 0, 0, nil)
+
+	rdr := mustNew(t, buf.Bytes(), 8, binary.LittleEndian)
+	var e Entry
+	if !rdr.Next(&e) {
+		t.Fatalf("could not read first entry: %v", rdr.Err())
+	}
+	off := rdr.Tell()
+	if off != 8+8+2+1 {
+		t.Fatalf("wrong offset after first entry %d", off)
+	}
+
+	clone := rdr.Clone()
+	if clone.Tell() != off {
+		t.Fatalf("wrong clone offset %d", clone.Tell())
+	}
+	clone.Seek(0)
+	if !clone.Next(&e) || e.LowPC != 0x10 {
+		t.Fatalf("wrong first entry from clone %#v", e)
+	}
+	if rdr.Tell() != off {
+		t.Fatalf("moving clone changed offset of original reader to %d", rdr.Tell())
+	}
+	if !rdr.Next(&e) || e.LowPC != 0x20 {
+		t.Fatalf("wrong second entry %#v", e)
+	}
+}
+
+func TestLoclistConcurrentClones(t *testing.T) {
+	// Build one location list per goroutine, list i has i+1 entries.
+	const n = 8
+	var buf bytes.Buffer
+	offs := make([]int, n)
+	for i := range offs {
+		offs[i] = buf.Len()
+		for j := 0; j <= i; j++ {
+			lo := uint64(i*0x1000 + j*0x10)
+			writeEntry(&buf, lo, lo+0x10, []byte{byte(0x50 + i), byte(j)})
+		}
+		writeEntry(&buf, 0, 0, nil)
+	}
+
+	for _, rdr := range []*Reader{
+		mustNew(t, buf.Bytes(), 8, binary.LittleEndian),
+		mustNewReaderAt(t, buf.Bytes()),
+	} {
+		done := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func(i int, rdr *Reader) {
+				for k := 0; k < 100; k++ {
+					rdr.Seek(offs[i])
+					cnt := 0
+					var e Entry
+					for rdr.Next(&e) {
+						lo := uint64(i*0x1000 + cnt*0x10)
+						if e.LowPC != lo || e.HighPC != lo+0x10 || !bytes.Equal(e.Instr, []byte{byte(0x50 + i), byte(cnt)}) {
+							done <- fmt.Errorf("list %d: wrong entry %d %s", i, cnt, e.String())
+							return
+						}
+						cnt++
+					}
+					if rdr.Err() != nil || cnt != i+1 {
+						done <- fmt.Errorf("list %d: read %d entries: %v", i, cnt, rdr.Err())
+						return
+					}
+				}
+				done <- nil
+			}(i, rdr.Clone())
+		}
+		for i := 0; i < n; i++ {
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestLoclistCountEntries(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, 0, 0, nil)
+	second := buf.Len()
+	writeEntry(&buf, ^uint64(0), 0x1000, nil)
+	writeEntry(&buf, 0x10, 0x20, []byte{0x51, 0x52})
+	writeEntry(&buf, 0x20, 0x30, []byte{0x53})
+	writeEntry(&buf, 0, 0, nil)
+	data := buf.Bytes()
+
+	for _, rdr := range []*Reader{mustNew(t, data, 8, binary.LittleEndian), mustNewReaderAt(t, data)} {
+		var e Entry
+		if !rdr.Next(&e) || !bytes.Equal(e.Instr, []byte{0x50}) {
+			t.Fatalf("could not read first entry: %v", rdr.Err())
+		}
+		pos := rdr.Tell()
+
+		for _, tc := range []struct {
+			off, tgt int
+		}{
+			{0, 1},
+			{second, 3},
+			{len(data) - 16, 0},
+		} {
+			n, err := rdr.CountEntries(tc.off)
+			if err != nil || n != tc.tgt {
+				t.Errorf("CountEntries(%#x) = %d, %v, expected %d", tc.off, n, err, tc.tgt)
+			}
+		}
+
+		// not the start of a list, reads garbage until the end of the section
+		if _, err := rdr.CountEntries(len(data) - 20); err != ErrTruncated {
+			t.Errorf("expected truncation error, got %v", err)
+		}
+
+		if rdr.Tell() != pos || rdr.Err() != nil {
+			t.Errorf("position not restored: %#x %v", rdr.Tell(), rdr.Err())
+		}
+		if rdr.Next(&e) {
+			t.Errorf("unexpected entry after the end of the list %v", e)
+		}
+	}
+
+	// address indices are not resolved
+	data5 := []byte{
+		_DW_LLE_base_addressx, 0x00,
+		_DW_LLE_offset_pair, 0x10, 0x20, 0x01, 0x50,
+		_DW_LLE_startx_length, 0x03, 0x08, 0x02, 0x51, 0x52,
+		_DW_LLE_end_of_list,
+	}
+	rdr := mustNewDwarf5(t, data5, 8, binary.LittleEndian)
+	if n, err := rdr.CountEntries(0); err != nil || n != 3 {
+		t.Errorf("CountEntries(0) = %d, %v, expected 3", n, err)
+	}
+	if _, err := rdr.CountEntries(len(data5) - 4); err != ErrTruncated {
+		t.Errorf("expected truncation error, got %v", err)
+	}
+	var e Entry
+	if rdr.Next(&e) || rdr.Err() != ErrAddrIndex {
+		t.Errorf("address indices must be resolved after CountEntries returns: %v", rdr.Err())
+	}
+}
+
+func mustNewReaderAt(t *testing.T, data []byte) *Reader {
+	t.Helper()
+	rdr, err := NewReaderAt(bytes.NewReader(data), len(data), 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func mustNew(t *testing.T, data []byte, ptrSz int, byteOrder binary.ByteOrder) *Reader {
+	t.Helper()
+	rdr, err := New(data, ptrSz, byteOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func mustNewDwarf5(t *testing.T, data []byte, ptrSz int, byteOrder binary.ByteOrder) *Reader {
+	t.Helper()
+	rdr, err := NewDwarf5(data, ptrSz, byteOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func TestLoclistPtrSize(t *testing.T) {
+	for _, ptrSz := range []int{0, 2, 16} {
+		if _, err := New(nil, ptrSz, binary.LittleEndian); err == nil {
+			t.Errorf("no error for pointer size %d", ptrSz)
+		}
+		if _, err := NewDwarf5(nil, ptrSz, binary.LittleEndian); err == nil {
+			t.Errorf("no error for pointer size %d (DWARF 5)", ptrSz)
+		}
+	}
+}
+
+func TestLoclistAbsoluteRange(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, ^uint64(0), 0x1000, nil)
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51})
+	writeEntry(&buf, 0, 0, nil)
+
+	rdr := mustNew(t, buf.Bytes(), 8, binary.LittleEndian)
+	var e Entry
+	var r [][2]uint64
+	for rdr.Next(&e) {
+		if e.BaseAddressSelection() {
+			continue
+		}
+		lo, hi := rdr.AbsoluteRange(0x400000, &e)
+		r = append(r, [2]uint64{lo, hi})
+	}
+	if rdr.Err() != nil {
+		t.Fatal(rdr.Err())
+	}
+	if fmt.Sprintf("%#x", r) != "[[0x400010 0x400020] [0x1020 0x1030]]" {
+		t.Fatalf("wrong ranges %#x", r)
+	}
+
+	// Seek resets the base address
+	rdr.Seek(0)
+	if !rdr.Next(&e) {
+		t.Fatal(rdr.Err())
+	}
+	if lo, hi := rdr.AbsoluteRange(0x400000, &e); lo != 0x400010 || hi != 0x400020 {
+		t.Fatalf("wrong range after Seek %#x %#x", lo, hi)
+	}
+}
+
+func TestLoclist32BitBaseAddress(t *testing.T) {
+	var buf bytes.Buffer
+	entry := func(lowpc, highpc uint32, instr []byte) {
+		binary.Write(&buf, binary.LittleEndian, lowpc)
+		binary.Write(&buf, binary.LittleEndian, highpc)
+		if lowpc == ^uint32(0) {
+			return
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(len(instr)))
+		buf.Write(instr)
+	}
+	entry(^uint32(0), 0x1000, nil)
+	entry(0x10, 0x20, []byte{0x50})
+	entry(^uint32(0), ^uint32(0)-0xf, nil)
+	entry(0x0, 0x8, []byte{0x51})
+	entry(^uint32(0), 0, nil)
+	entry(0x20, ^uint32(0), []byte{0x52})
+	entry(0, 0, nil)
+
+	rdr := mustNew(t, buf.Bytes(), 4, binary.LittleEndian)
+	var e Entry
+	var r [][2]uint64
+	for rdr.Next(&e) {
+		if e.BaseAddressSelection() {
+			continue
+		}
+		lo, hi := rdr.AbsoluteRange(0x400000, &e)
+		r = append(r, [2]uint64{lo, hi})
+	}
+	if rdr.Err() != nil {
+		t.Fatal(rdr.Err())
+	}
+	if fmt.Sprintf("%#x", r) != "[[0x1010 0x1020] [0xfffffff0 0xfffffff8] [0x20 0xffffffff]]" {
+		t.Fatalf("wrong ranges %#x", r)
+	}
+}
+
+func TestLoclistRanges(t *testing.T) {
+	var buf bytes.Buffer
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, ^uint64(0), 0x1000, nil)
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51, 0x52})
+	writeEntry(&buf, 0, 0, nil)
+	data := buf.Bytes()
+
+	rdr := mustNew(t, data, 8, binary.LittleEndian)
+	r := rdr.Ranges(0, 0x400000)
+	if rdr.Err() != nil {
+		t.Fatal(rdr.Err())
+	}
+	tgt := []Entry{
+		{LowPC: 0x400010, HighPC: 0x400020, Instr: []byte{0x50}, Absolute: true, ptrSz: 8},
+		{LowPC: 0x1020, HighPC: 0x1030, Instr: []byte{0x51, 0x52}, Absolute: true, ptrSz: 8},
+	}
+	if !reflect.DeepEqual(r, tgt) {
+		t.Fatalf("expected %#v got %#v", tgt, r)
+	}
+
+	// The returned entries must not alias the section data.
+	for i := range data {
+		data[i] = 0
+	}
+	if !reflect.DeepEqual(r, tgt) {
+		t.Fatalf("entries changed after modifying section data: %#v", r)
+	}
+
+	buf.Reset()
+	writeEntry(&buf, 0x10, 0x20, []byte{0x50})
+	writeEntry(&buf, 0x20, 0x30, []byte{0x51, 0x52})
+	rdr = mustNew(t, buf.Bytes()[:buf.Len()-1], 8, binary.LittleEndian)
+	if r := rdr.Ranges(0, 0); len(r) != 1 || rdr.Err() != ErrTruncated {
+		t.Fatalf("expected one entry and truncation error, got %#v %v", r, rdr.Err())
+	}
+}
+
+func TestLoclistReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	instr := make([]byte, readerAtWindowSize+10)
+	for i := 0; i < 300; i++ {
+		writeEntry(&buf, uint64(i*0x10), uint64(i*0x10+0x10), []byte{byte(0x50 + i%0x20)})
+	}
+	writeEntry(&buf, 0x5000, 0x5010, instr)
+	writeEntry(&buf, 0, 0, nil)
+	data := buf.Bytes()
+
+	rdr, err := NewReaderAt(bytes.NewReader(data), len(data), 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdr.Empty() {
+		t.Fatal("reader is empty")
+	}
+	ref := mustNew(t, data, 8, binary.LittleEndian)
+	var e, refe Entry
+	var entries []Entry
+	for ref.Next(&refe) {
+		if !rdr.Next(&e) {
+			t.Fatalf("missing entry %#x %#x: %v", refe.LowPC, refe.HighPC, rdr.Err())
+		}
+		if e.LowPC != refe.LowPC || e.HighPC != refe.HighPC || !bytes.Equal(e.Instr, refe.Instr) {
+			t.Fatalf("mismatched entry %#x %#x, expected %#x %#x", e.LowPC, e.HighPC, refe.LowPC, refe.HighPC)
+		}
+		entries = append(entries, e)
+	}
+	if rdr.Next(&e) || rdr.Err() != nil {
+		t.Fatalf("unexpected entry or error: %v", rdr.Err())
+	}
+	// Instructions of previously returned entries must not be overwritten when
+	// a new window is read.
+	for i := 0; i < 300; i++ {
+		if entries[i].Instr[0] != byte(0x50+i%0x20) {
+			t.Fatalf("instructions of entry %d overwritten", i)
+		}
+	}
+
+	rdr, err = NewReaderAt(bytes.NewReader(data), len(data)-1, 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rdr.Next(&e) {
+	}
+	if rdr.Err() != ErrTruncated {
+		t.Errorf("expected truncation error, got %v", rdr.Err())
+	}
+}
+
+func TestLoclistListOffset(t *testing.T) {
+	// .debug_loclists: a 12 byte header, an offsets table of two entries and
+	// the two location lists.
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 12))
+	const base = 12
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	binary.Write(&buf, binary.LittleEndian, uint32(8+6))
+	for _, instr := range []byte{0x50, 0x51} {
+		buf.WriteByte(_DW_LLE_offset_pair)
+		buf.Write([]byte{0x10, 0x20, 0x01, instr})
+		buf.WriteByte(_DW_LLE_end_of_list)
+	}
+	data := buf.Bytes()
+
+	readerAt, err := NewDwarf5ReaderAt(bytes.NewReader(data), len(data), 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rdr := range []*Reader{mustNewDwarf5(t, data, 8, binary.LittleEndian), readerAt} {
+		rdr.Seek(5)
+		for idx, instr := range []byte{0x50, 0x51} {
+			off, err := rdr.ListOffset(base, uint64(idx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tgt := base + 8 + idx*6; off != tgt {
+				t.Errorf("index %d: got offset %#x expected %#x", idx, off, tgt)
+			}
+			e, ok := rdr.Clone().FindEntry(off, 0x10)
+			if !ok || !bytes.Equal(e.Instr, []byte{instr}) {
+				t.Errorf("index %d: wrong entry %v", idx, e)
+			}
+		}
+		if rdr.Tell() != 5 {
+			t.Errorf("ListOffset moved the reader to %d", rdr.Tell())
+		}
+		if _, err := rdr.ListOffset(base, uint64(len(data))); err != ErrTruncated {
+			t.Errorf("expected truncation error, got %v", err)
+		}
+	}
+
+	if _, err := mustNew(t, data, 8, binary.LittleEndian).ListOffset(base, 0); err == nil {
+		t.Errorf("no error using a location list index with .debug_loc")
+	}
+}
+
+func TestLoclistEntryString(t *testing.T) {
+	for _, tc := range []struct {
+		e   Entry
+		tgt string
+	}{
+		{Entry{LowPC: ^uint64(0), HighPC: 0x1000}, "base = 0x1000"},
+		{Entry{LowPC: 0x10, HighPC: 0x20, Instr: []byte{0x9c, 0x11, 0x78, 0x22}}, "[base+0x10, base+0x20): DW_OP_call_frame_cfa DW_OP_consts -0x8 DW_OP_plus"},
+		{Entry{LowPC: 0x1010, HighPC: 0x1020, Instr: []byte{0x50}, Absolute: true}, "[0x1010, 0x1020): DW_OP_reg0"},
+		{Entry{Instr: []byte{0x50}, Absolute: true, defaultLocation: true}, "default: DW_OP_reg0"},
+	} {
+		if out := tc.e.String(); out != tc.tgt {
+			t.Errorf("got %q expected %q", out, tc.tgt)
+		}
+	}
+
+	// DW_OP_addr operands have the pointer size of the location list.
+	var buf bytes.Buffer
+	for _, v := range []uint32{0x10, 0x20} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint16(5))
+	buf.Write([]byte{0x03, 0x00, 0x20, 0x00, 0x00}) // DW_OP_addr 0x2000
+	buf.Write(make([]byte, 8))
+	rdr := mustNew(t, buf.Bytes(), 4, binary.LittleEndian)
+	var e Entry
+	if !rdr.Next(&e) {
+		t.Fatalf("could not read entry: %v", rdr.Err())
+	}
+	if out, tgt := e.String(), "[base+0x10, base+0x20): DW_OP_addr 0x2000"; out != tgt {
+		t.Errorf("got %q expected %q", out, tgt)
+	}
+}
+
+// relRange is an address range relative to the entry point of a function.
+type relRange struct {
+	lo, hi uint64
+}
+
+// buildLoclistFixture compiles _fixtures/loclistprog.go, with optimizations
+// enabled so that its variables have location lists, setting GOEXPERIMENT
+// to experiment. The test is skipped if the toolchain can not build it.
+func buildLoclistFixture(t *testing.T, experiment string) string {
+	dir, err := ioutil.TempDir("", "loclist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := filepath.Abs("../../../_fixtures/loclistprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "loclistprog")
+	cmd := exec.Command("go", "build", "-o", exe, src)
+	cmd.Env = append(os.Environ(), "GOEXPERIMENT="+experiment)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("could not build fixture with GOEXPERIMENT=%s: %v\n%s", experiment, err, out)
+	}
+	return exe
+}
+
+// fixtureLoclist returns the location list ranges of the variable
+// varname of function fnname in exe, relative to the entry point of the
+// function, decoded with a Reader for section. The test is skipped if exe
+// does not have section.
+func fixtureLoclist(t *testing.T, exe, section, fnname, varname string) []relRange {
+	ef, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	data, err := godwarf.GetDebugSectionElf(ef, section)
+	if err != nil {
+		t.Skipf("no .debug_%s section: %v", section, err)
+	}
+	dw, err := ef.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptrSz := 8
+	if ef.Class == elf.ELFCLASS32 {
+		ptrSz = 4
+	}
+
+	var rdr *Reader
+	if section == "loclists" {
+		rdr, err = NewDwarf5(data, ptrSz, ef.ByteOrder)
+	} else {
+		rdr, err = New(data, ptrSz, ef.ByteOrder)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cu *dwarf.Entry
+	var fnlow, fnhigh uint64
+	r := dw.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			cu = e
+		case dwarf.TagSubprogram:
+			if e.Val(dwarf.AttrName) == fnname {
+				fnlow, _ = e.Val(dwarf.AttrLowpc).(uint64)
+				switch hi := e.Val(dwarf.AttrHighpc).(type) {
+				case uint64:
+					fnhigh = hi
+				case int64:
+					fnhigh = fnlow + uint64(hi)
+				}
+				continue
+			}
+			if fnlow != 0 {
+				t.Fatalf("variable %s not found in %s", varname, fnname)
+			}
+			r.SkipChildren()
+		case dwarf.TagVariable, dwarf.TagFormalParameter:
+			if fnlow == 0 || e.Val(dwarf.AttrName) != varname {
+				continue
+			}
+			off, ok := e.Val(dwarf.AttrLocation).(int64)
+			if !ok {
+				t.Fatalf("variable %s of %s does not have a location list: %#v", varname, fnname, e.Val(dwarf.AttrLocation))
+			}
+			// DW_AT_addr_base
+			if addrBase, ok := cu.Val(dwarf.Attr(0x73)).(int64); ok {
+				addrData, err := godwarf.GetDebugSectionElf(ef, "addr")
+				if err != nil {
+					t.Fatal(err)
+				}
+				rdr.SetAddrResolver(godwarf.ParseAddr(addrData, ptrSz, ef.ByteOrder).GetSubsection(uint64(addrBase)).Get)
+			}
+			base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+			entries := rdr.Ranges(int(off), base)
+			if err := rdr.Err(); err != nil {
+				t.Fatalf("reading location list at %#x: %v", off, err)
+			}
+			var ranges []relRange
+			for _, e := range entries {
+				if e.LowPC < fnlow || e.HighPC > fnhigh || e.LowPC >= e.HighPC {
+					t.Errorf("entry %s outside of %s [%#x, %#x)", e.String(), fnname, fnlow, fnhigh)
+				}
+				if len(ranges) > 0 && e.LowPC < fnlow+ranges[len(ranges)-1].hi {
+					t.Errorf("entry %s overlaps the previous entry", e.String())
+				}
+				if _, err := op.DecodeLocExpr(e.Instr, ptrSz); err != nil {
+					t.Errorf("entry %s: %v", e.String(), err)
+				}
+				ranges = append(ranges, relRange{e.LowPC - fnlow, e.HighPC - fnlow})
+			}
+			return ranges
+		}
+	}
+	t.Fatalf("function %s not found", fnname)
+	return nil
+}
+
+func TestLoclistFixture(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test only supported on linux")
+	}
+	const fnname, varname = "main.sumsquares", "s"
+	var golden []relRange
+	for _, tc := range []struct {
+		experiment, section string
+	}{
+		{"nodwarf5", "loc"},
+		{"dwarf5", "loclists"},
+	} {
+		t.Run(tc.section, func(t *testing.T) {
+			exe := buildLoclistFixture(t, tc.experiment)
+			defer os.RemoveAll(filepath.Dir(exe))
+			ranges := fixtureLoclist(t, exe, tc.section, fnname, varname)
+			t.Logf("%s: %x", tc.section, ranges)
+			if len(ranges) == 0 {
+				t.Fatalf("empty location list for %s", varname)
+			}
+			// The code generated by the compiler does not depend on the DWARF
+			// version, the location lists must describe the same ranges.
+			if golden == nil {
+				golden = ranges
+			} else if !reflect.DeepEqual(ranges, golden) {
+				t.Errorf("location list mismatch\n%x\n%x", ranges, golden)
+			}
+		})
+	}
+}
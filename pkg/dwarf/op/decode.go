@@ -0,0 +1,153 @@
+package op
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/util"
+)
+
+// Op is a single decoded operation of a DWARF location expression.
+type Op struct {
+	Offset int    // offset of the opcode in the expression
+	Opcode Opcode // opcode of the operation
+	// Operands contains the integer operands of the operation, signed
+	// operands are stored in two's complement.
+	Operands []uint64
+	Block    []byte // block operand, for operations that have one
+}
+
+func (o *Op) String() string {
+	var buf bytes.Buffer
+	if name, ok := opcodeName[o.Opcode]; ok {
+		buf.WriteString(name)
+	} else {
+		fmt.Fprintf(&buf, "%#x", byte(o.Opcode))
+	}
+	args := opcodeArgs[o.Opcode]
+	for i, n := range o.Operands {
+		if i < len(args) && args[i] == 's' {
+			fmt.Fprintf(&buf, " %d", int64(n))
+		} else {
+			fmt.Fprintf(&buf, " %#x", n)
+		}
+	}
+	if o.Block != nil {
+		fmt.Fprintf(&buf, " [%x]", o.Block)
+	}
+	return buf.String()
+}
+
+// DecodeLocExpr decodes the DWARF location expression instr into a list
+// of operations. PtrSz is the size of the operand of DW_OP_addr.
+// An error is returned if instr contains an unknown opcode or if the
+// operands of an opcode are truncated.
+func DecodeLocExpr(instr []byte, ptrSz int) ([]Op, error) {
+	in := bytes.NewBuffer(instr)
+	var ops []Op
+	for in.Len() > 0 {
+		off := len(instr) - in.Len()
+		opcode, _ := in.ReadByte()
+		args, ok := opcodeArgs[Opcode(opcode)]
+		if !ok {
+			return ops, &LocExprError{Offset: off, Opcode: Opcode(opcode), Msg: "unknown opcode"}
+		}
+		o := Op{Offset: off, Opcode: Opcode(opcode)}
+		for _, arg := range args {
+			truncated := func() error {
+				return &LocExprError{Offset: off, Opcode: o.Opcode, Msg: "truncated operand"}
+			}
+			switch arg {
+			case 's':
+				if !lebComplete(in.Bytes()) {
+					return ops, truncated()
+				}
+				n, _ := util.DecodeSLEB128(in)
+				o.Operands = append(o.Operands, uint64(n))
+			case 'u', 'B':
+				if !lebComplete(in.Bytes()) {
+					return ops, truncated()
+				}
+				n, _ := util.DecodeULEB128(in)
+				if arg == 'u' {
+					o.Operands = append(o.Operands, n)
+					continue
+				}
+				if uint64(in.Len()) < n {
+					return ops, truncated()
+				}
+				o.Block = in.Next(int(n))
+			default:
+				sz := int(arg - '0')
+				if o.Opcode == DW_OP_addr {
+					sz = ptrSz
+				}
+				if in.Len() < sz {
+					return ops, truncated()
+				}
+				o.Operands = append(o.Operands, readUint(in.Next(sz)))
+			}
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+// LocExprError is returned by DecodeLocExpr and ValidateLocExpr when a
+// location expression is malformed.
+type LocExprError struct {
+	Offset int    // offset of the offending opcode
+	Opcode Opcode // offending opcode
+	Msg    string
+}
+
+func (err *LocExprError) Error() string {
+	if name, ok := opcodeName[err.Opcode]; ok {
+		return fmt.Sprintf("%s for %s at offset %d", err.Msg, name, err.Offset)
+	}
+	return fmt.Sprintf("%s %#x at offset %d", err.Msg, byte(err.Opcode), err.Offset)
+}
+
+// ValidateLocExpr checks that instr is a well formed location expression:
+// every opcode must be known and its operands must not be truncated.
+// PtrSz is the size of the operand of DW_OP_addr.
+// The returned error is a *LocExprError.
+func ValidateLocExpr(instr []byte, ptrSz int) error {
+	_, err := DecodeLocExpr(instr, ptrSz)
+	return err
+}
+
+// lebComplete returns true if buf starts with a complete LEB128 number.
+func lebComplete(buf []byte) bool {
+	for _, b := range buf {
+		if b&0x80 == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// readUint reads a little endian unsigned integer of 1, 2, 4 or 8 bytes.
+func readUint(buf []byte) uint64 {
+	switch len(buf) {
+	case 1:
+		return uint64(buf[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(buf))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(buf))
+	default:
+		return binary.LittleEndian.Uint64(buf)
+	}
+}
+
+// FormatOps returns a textual representation of ops.
+func FormatOps(ops []Op) string {
+	s := make([]string, len(ops))
+	for i := range ops {
+		s[i] = ops[i].String()
+	}
+	return strings.Join(s, "; ")
+}
@@ -1,23 +1,18 @@
 package op
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/go-delve/delve/pkg/dwarf/util"
 )
 
 type Opcode byte
 
 //go:generate go run ../../../scripts/gen-opcodes.go opcodes.table opcodes.go
 
-type stackfn func(Opcode, *context) error
+type stackfn func(Op, *context) error
 
 type context struct {
-	buf    *bytes.Buffer
 	stack  []int64
 	pieces []Piece
 	reg    bool
@@ -36,32 +31,33 @@ type Piece struct {
 // ExecuteStackProgram executes a DWARF location expression and returns
 // either an address (int64), or a slice of Pieces for location expressions
 // that don't evaluate to an address (such as register and composite expressions).
-func ExecuteStackProgram(regs DwarfRegisters, instructions []byte) (int64, []Piece, error) {
+// PtrSize is the size of the operand of DW_OP_addr. Malformed expressions
+// result in a *LocExprError.
+func ExecuteStackProgram(regs DwarfRegisters, instructions []byte, ptrSize int) (int64, []Piece, error) {
 	ctxt := &context{
-		buf:            bytes.NewBuffer(instructions),
 		stack:          make([]int64, 0, 3),
 		DwarfRegisters: regs,
 	}
 
-	for {
-		opcodeByte, err := ctxt.buf.ReadByte()
-		if err != nil {
-			break
-		}
-		opcode := Opcode(opcodeByte)
-		if ctxt.reg && opcode != DW_OP_piece {
+	ops, err := DecodeLocExpr(instructions, ptrSize)
+	for _, o := range ops {
+		if ctxt.reg && o.Opcode != DW_OP_piece {
 			break
 		}
-		fn, ok := oplut[opcode]
+		fn, ok := oplut[o.Opcode]
 		if !ok {
-			return 0, nil, fmt.Errorf("invalid instruction %#v", opcode)
+			return 0, nil, fmt.Errorf("invalid instruction %#v", o.Opcode)
 		}
 
-		err = fn(opcode, ctxt)
-		if err != nil {
+		if err := fn(o, ctxt); err != nil {
 			return 0, nil, err
 		}
 	}
+	// the instructions following a register are ignored, it doesn't matter
+	// if they can not be decoded.
+	if err != nil && !ctxt.reg {
+		return 0, nil, err
+	}
 
 	if ctxt.pieces != nil {
 		return 0, ctxt.pieces, nil
@@ -74,57 +70,34 @@ func ExecuteStackProgram(regs DwarfRegisters, instructions []byte) (int64, []Pie
 	return ctxt.stack[len(ctxt.stack)-1], nil, nil
 }
 
-// PrettyPrint prints instructions to out.
-func PrettyPrint(out io.Writer, instructions []byte) {
-	in := bytes.NewBuffer(instructions)
-
-	for {
-		opcode, err := in.ReadByte()
-		if err != nil {
-			break
-		}
-		if name, hasname := opcodeName[Opcode(opcode)]; hasname {
+// PrettyPrint prints instructions to out, ptrSz is the size of the
+// operand of DW_OP_addr.
+func PrettyPrint(out io.Writer, instructions []byte, ptrSz int) {
+	ops, err := DecodeLocExpr(instructions, ptrSz)
+	for _, o := range ops {
+		if name, hasname := opcodeName[o.Opcode]; hasname {
 			io.WriteString(out, name)
 			out.Write([]byte{' '})
 		} else {
-			fmt.Fprintf(out, "%#x ", opcode)
+			fmt.Fprintf(out, "%#x ", byte(o.Opcode))
 		}
-		for _, arg := range opcodeArgs[Opcode(opcode)] {
-			switch arg {
-			case 's':
-				n, _ := util.DecodeSLEB128(in)
-				fmt.Fprintf(out, "%#x ", n)
-			case 'u':
-				n, _ := util.DecodeULEB128(in)
+		for i, n := range o.Operands {
+			if opcodeArgs[o.Opcode][i] == 's' {
+				fmt.Fprintf(out, "%#x ", int64(n))
+			} else {
 				fmt.Fprintf(out, "%#x ", n)
-			case '1':
-				var x uint8
-				binary.Read(in, binary.LittleEndian, &x)
-				fmt.Fprintf(out, "%#x ", x)
-			case '2':
-				var x uint16
-				binary.Read(in, binary.LittleEndian, &x)
-				fmt.Fprintf(out, "%#x ", x)
-			case '4':
-				var x uint32
-				binary.Read(in, binary.LittleEndian, &x)
-				fmt.Fprintf(out, "%#x ", x)
-			case '8':
-				var x uint64
-				binary.Read(in, binary.LittleEndian, &x)
-				fmt.Fprintf(out, "%#x ", x)
-			case 'B':
-				sz, _ := util.DecodeULEB128(in)
-				data := make([]byte, sz)
-				sz2, _ := in.Read(data)
-				data = data[:sz2]
-				fmt.Fprintf(out, "%d [%x] ", sz, data)
 			}
 		}
+		if o.Block != nil {
+			fmt.Fprintf(out, "%d [%x] ", len(o.Block), o.Block)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "(%v) ", err)
 	}
 }
 
-func callframecfa(opcode Opcode, ctxt *context) error {
+func callframecfa(o Op, ctxt *context) error {
 	if ctxt.CFA == 0 {
 		return fmt.Errorf("Could not retrieve CFA for current PC")
 	}
@@ -132,12 +105,12 @@ func callframecfa(opcode Opcode, ctxt *context) error {
 	return nil
 }
 
-func addr(opcode Opcode, ctxt *context) error {
-	ctxt.stack = append(ctxt.stack, int64(binary.LittleEndian.Uint64(ctxt.buf.Next(8))+ctxt.StaticBase))
+func addr(o Op, ctxt *context) error {
+	ctxt.stack = append(ctxt.stack, int64(o.Operands[0]+ctxt.StaticBase))
 	return nil
 }
 
-func plus(opcode Opcode, ctxt *context) error {
+func plus(o Op, ctxt *context) error {
 	var (
 		slen   = len(ctxt.stack)
 		digits = ctxt.stack[slen-2 : slen]
@@ -148,38 +121,34 @@ func plus(opcode Opcode, ctxt *context) error {
 	return nil
 }
 
-func plusuconsts(opcode Opcode, ctxt *context) error {
+func plusuconsts(o Op, ctxt *context) error {
 	slen := len(ctxt.stack)
-	num, _ := util.DecodeULEB128(ctxt.buf)
-	ctxt.stack[slen-1] = ctxt.stack[slen-1] + int64(num)
+	ctxt.stack[slen-1] = ctxt.stack[slen-1] + int64(o.Operands[0])
 	return nil
 }
 
-func consts(opcode Opcode, ctxt *context) error {
-	num, _ := util.DecodeSLEB128(ctxt.buf)
-	ctxt.stack = append(ctxt.stack, num)
+func consts(o Op, ctxt *context) error {
+	ctxt.stack = append(ctxt.stack, int64(o.Operands[0]))
 	return nil
 }
 
-func framebase(opcode Opcode, ctxt *context) error {
-	num, _ := util.DecodeSLEB128(ctxt.buf)
-	ctxt.stack = append(ctxt.stack, ctxt.FrameBase+num)
+func framebase(o Op, ctxt *context) error {
+	ctxt.stack = append(ctxt.stack, ctxt.FrameBase+int64(o.Operands[0]))
 	return nil
 }
 
-func register(opcode Opcode, ctxt *context) error {
+func register(o Op, ctxt *context) error {
 	ctxt.reg = true
-	if opcode == DW_OP_regx {
-		n, _ := util.DecodeSLEB128(ctxt.buf)
-		ctxt.pieces = append(ctxt.pieces, Piece{IsRegister: true, RegNum: uint64(n)})
+	if o.Opcode == DW_OP_regx {
+		ctxt.pieces = append(ctxt.pieces, Piece{IsRegister: true, RegNum: o.Operands[0]})
 	} else {
-		ctxt.pieces = append(ctxt.pieces, Piece{IsRegister: true, RegNum: uint64(opcode - DW_OP_reg0)})
+		ctxt.pieces = append(ctxt.pieces, Piece{IsRegister: true, RegNum: uint64(o.Opcode - DW_OP_reg0)})
 	}
 	return nil
 }
 
-func piece(opcode Opcode, ctxt *context) error {
-	sz, _ := util.DecodeULEB128(ctxt.buf)
+func piece(o Op, ctxt *context) error {
+	sz := o.Operands[0]
 	if ctxt.reg {
 		ctxt.reg = false
 		ctxt.pieces[len(ctxt.pieces)-1].Size = int(sz)
@@ -1,13 +1,16 @@
 package op
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestExecuteStackProgram(t *testing.T) {
 	var (
 		instructions = []byte{byte(DW_OP_consts), 0x1c, byte(DW_OP_consts), 0x1c, byte(DW_OP_plus)}
 		expected     = int64(56)
 	)
-	actual, _, err := ExecuteStackProgram(DwarfRegisters{}, instructions)
+	actual, _, err := ExecuteStackProgram(DwarfRegisters{}, instructions, 8)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -16,3 +19,188 @@ func TestExecuteStackProgram(t *testing.T) {
 		t.Fatalf("actual %d != expected %d", actual, expected)
 	}
 }
+
+func TestDecodeLocExpr(t *testing.T) {
+	instr := []byte{
+		byte(DW_OP_fbreg), 0x70, // -16
+		byte(DW_OP_addr), 0x10, 0x20, 0x30, 0x40,
+		byte(DW_OP_reg0),
+		byte(DW_OP_piece), 0x08,
+		byte(DW_OP_implicit_value), 0x02, 0xaa, 0xbb,
+	}
+	ops, err := DecodeLocExpr(instr, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt := "DW_OP_fbreg -16; DW_OP_addr 0x40302010; DW_OP_reg0; DW_OP_piece 0x8; DW_OP_implicit_value [aabb]"
+	if out := FormatOps(ops); out != tgt {
+		t.Errorf("got %q expected %q", out, tgt)
+	}
+	if ops[3].Offset != 8 {
+		t.Errorf("wrong offset for DW_OP_piece: %d", ops[3].Offset)
+	}
+
+	for _, bad := range [][]byte{
+		{byte(DW_OP_fbreg), 0x80},
+		{byte(DW_OP_addr), 0x10, 0x20},
+		{byte(DW_OP_implicit_value), 0x04, 0xaa},
+		{byte(DW_OP_reg0), 0xff},
+	} {
+		if _, err := DecodeLocExpr(bad, 4); err == nil {
+			t.Errorf("no error decoding %x", bad)
+		} else {
+			t.Logf("decoding %x: %v", bad, err)
+		}
+	}
+}
+
+func TestValidateLocExpr(t *testing.T) {
+	good := []byte{byte(DW_OP_call_frame_cfa), byte(DW_OP_consts), 0x10, byte(DW_OP_plus), byte(DW_OP_stack_value)}
+	if err := ValidateLocExpr(good, 8); err != nil {
+		t.Errorf("error validating %x: %v", good, err)
+	}
+
+	for _, tc := range []struct {
+		instr  []byte
+		off    int
+		opcode Opcode
+		err    string
+	}{
+		{[]byte{byte(DW_OP_call_frame_cfa), byte(DW_OP_fbreg), 0x80}, 1, DW_OP_fbreg, "truncated operand for DW_OP_fbreg at offset 1"},
+		{[]byte{byte(DW_OP_addr), 1, 2, 3, 4}, 0, DW_OP_addr, "truncated operand for DW_OP_addr at offset 0"},
+		{[]byte{byte(DW_OP_reg0), byte(DW_OP_piece), 0x08, 0xff}, 3, Opcode(0xff), "unknown opcode 0xff at offset 3"},
+	} {
+		err := ValidateLocExpr(tc.instr, 8)
+		lerr, ok := err.(*LocExprError)
+		if !ok {
+			t.Errorf("%x: wrong error %v", tc.instr, err)
+			continue
+		}
+		if lerr.Offset != tc.off || lerr.Opcode != tc.opcode || lerr.Error() != tc.err {
+			t.Errorf("%x: got %q (offset %d opcode %#x) expected %q", tc.instr, lerr.Error(), lerr.Offset, byte(lerr.Opcode), tc.err)
+		}
+	}
+}
+
+func TestDecodeLocExprOperands(t *testing.T) {
+	for _, tc := range []struct {
+		instr []byte
+		tgt   string
+	}{
+		// SLEB128
+		{[]byte{byte(DW_OP_consts), 0x7f}, "DW_OP_consts -1"},
+		{[]byte{byte(DW_OP_consts), 0x3f}, "DW_OP_consts 63"},
+		{[]byte{byte(DW_OP_consts), 0x40}, "DW_OP_consts -64"},
+		{[]byte{byte(DW_OP_consts), 0xc0, 0x00}, "DW_OP_consts 64"},
+		{[]byte{byte(DW_OP_fbreg), 0x80, 0x7f}, "DW_OP_fbreg -128"},
+		{[]byte{byte(DW_OP_breg7), 0xe5, 0x8e, 0x26}, "DW_OP_breg7 624485"},
+		{[]byte{byte(DW_OP_bregx), 0x81, 0x01, 0x78}, "DW_OP_bregx 0x81 -8"},
+		// ULEB128
+		{[]byte{byte(DW_OP_plus_uconst), 0xe5, 0x8e, 0x26}, "DW_OP_plus_uconst 0x98765"},
+		// block operands
+		{[]byte{byte(DW_OP_implicit_value), 0x00, byte(DW_OP_stack_value)}, "DW_OP_implicit_value []; DW_OP_stack_value"},
+		{[]byte{byte(DW_OP_entry_value), 0x01, byte(DW_OP_reg5), byte(DW_OP_stack_value)}, "DW_OP_entry_value [55]; DW_OP_stack_value"},
+		{[]byte{byte(DW_OP_entry_value), 0x02, byte(DW_OP_breg5), 0x00, byte(DW_OP_stack_value)}, "DW_OP_entry_value [7500]; DW_OP_stack_value"},
+		// DWARF 5
+		{[]byte{byte(DW_OP_addrx), 0x83, 0x01}, "DW_OP_addrx 0x83"},
+		{[]byte{byte(DW_OP_implicit_pointer), 0x10, 0x00, 0x00, 0x00, 0x78}, "DW_OP_implicit_pointer 0x10 -8"},
+		{[]byte{byte(DW_OP_deref_type), 0x08, 0x2a}, "DW_OP_deref_type 0x8 0x2a"},
+	} {
+		ops, err := DecodeLocExpr(tc.instr, 8)
+		if err != nil {
+			t.Errorf("%x: %v", tc.instr, err)
+			continue
+		}
+		if out := FormatOps(ops); out != tc.tgt {
+			t.Errorf("%x: got %q expected %q", tc.instr, out, tc.tgt)
+		}
+	}
+
+	// the block of DW_OP_entry_value is itself a location expression
+	ops, err := DecodeLocExpr([]byte{byte(DW_OP_entry_value), 0x03, byte(DW_OP_fbreg), 0x80, 0x7f}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := DecodeLocExpr(ops[0].Block, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := FormatOps(inner); out != "DW_OP_fbreg -128" {
+		t.Errorf("wrong inner expression %q", out)
+	}
+
+	for _, bad := range [][]byte{
+		{byte(DW_OP_consts), 0xc0},
+		{byte(DW_OP_bregx), 0x81, 0x01},
+		{byte(DW_OP_entry_value), 0x03, byte(DW_OP_fbreg), 0x80},
+		{byte(DW_OP_entry_value), 0x80},
+		{byte(DW_OP_implicit_pointer), 0x10, 0x00, 0x00},
+	} {
+		if _, err := DecodeLocExpr(bad, 8); err == nil {
+			t.Errorf("no error decoding %x", bad)
+		}
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	for _, tc := range []struct {
+		instr []byte
+		ptrSz int
+		tgt   string
+	}{
+		{[]byte{byte(DW_OP_fbreg), 0x70}, 8, "DW_OP_fbreg -0x10 "},
+		{[]byte{byte(DW_OP_reg0), byte(DW_OP_piece), 0x08, byte(DW_OP_implicit_value), 0x02, 0xaa, 0xbb}, 8, "DW_OP_reg0 DW_OP_piece 0x8 DW_OP_implicit_value 2 [aabb] "},
+		{[]byte{byte(DW_OP_call_frame_cfa), byte(DW_OP_fbreg), 0x80}, 8, "DW_OP_call_frame_cfa (truncated operand for DW_OP_fbreg at offset 1) "},
+		{[]byte{byte(DW_OP_addr), 0x00, 0x10, 0, 0, byte(DW_OP_deref)}, 4, "DW_OP_addr 0x1000 DW_OP_deref "},
+	} {
+		var buf bytes.Buffer
+		PrettyPrint(&buf, tc.instr, tc.ptrSz)
+		if out := buf.String(); out != tc.tgt {
+			t.Errorf("%x: got %q expected %q", tc.instr, out, tc.tgt)
+		}
+	}
+}
+
+func TestExecuteStackProgramOperands(t *testing.T) {
+	regs := DwarfRegisters{FrameBase: 0x1000, StaticBase: 0x10}
+	for _, tc := range []struct {
+		instr []byte
+		tgt   int64
+	}{
+		{[]byte{byte(DW_OP_fbreg), 0x80, 0x7f}, 0x1000 - 128},
+		{[]byte{byte(DW_OP_consts), 0x40, byte(DW_OP_plus_uconst), 0xe5, 0x8e, 0x26}, 624485 - 64},
+		{[]byte{byte(DW_OP_addr), 0x00, 0x20, 0, 0, 0, 0, 0, 0}, 0x2010},
+	} {
+		addr, _, err := ExecuteStackProgram(regs, tc.instr, 8)
+		if err != nil {
+			t.Errorf("%x: %v", tc.instr, err)
+			continue
+		}
+		if addr != tc.tgt {
+			t.Errorf("%x: got %#x expected %#x", tc.instr, addr, tc.tgt)
+		}
+	}
+
+	// instructions following a register are not executed
+	_, pieces, err := ExecuteStackProgram(regs, []byte{byte(DW_OP_regx), 0x11, byte(DW_OP_fbreg)}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 1 || !pieces[0].IsRegister || pieces[0].RegNum != 0x11 {
+		t.Errorf("wrong pieces %#v", pieces)
+	}
+
+	// the operand of DW_OP_addr is as large as a pointer
+	addr, _, err := ExecuteStackProgram(regs, []byte{byte(DW_OP_addr), 0x00, 0x20, 0, 0, byte(DW_OP_plus_uconst), 0x1}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != 0x2011 {
+		t.Errorf("got %#x expected %#x", addr, 0x2011)
+	}
+
+	_, _, err = ExecuteStackProgram(regs, []byte{byte(DW_OP_consts), 0x80}, 8)
+	if lerr, ok := err.(*LocExprError); !ok || lerr.Offset != 0 || lerr.Opcode != DW_OP_consts {
+		t.Errorf("wrong error executing truncated expression: %v", err)
+	}
+}
@@ -157,6 +157,15 @@ const (
 	DW_OP_bit_piece           Opcode = 0x9d
 	DW_OP_implicit_value      Opcode = 0x9e
 	DW_OP_stack_value         Opcode = 0x9f
+	DW_OP_implicit_pointer    Opcode = 0xa0
+	DW_OP_addrx               Opcode = 0xa1
+	DW_OP_constx              Opcode = 0xa2
+	DW_OP_entry_value         Opcode = 0xa3
+	DW_OP_regval_type         Opcode = 0xa5
+	DW_OP_deref_type          Opcode = 0xa6
+	DW_OP_xderef_type         Opcode = 0xa7
+	DW_OP_convert             Opcode = 0xa8
+	DW_OP_reinterpret         Opcode = 0xa9
 )
 
 var opcodeName = map[Opcode]string{
@@ -314,6 +323,15 @@ var opcodeName = map[Opcode]string{
 	DW_OP_bit_piece:           "DW_OP_bit_piece",
 	DW_OP_implicit_value:      "DW_OP_implicit_value",
 	DW_OP_stack_value:         "DW_OP_stack_value",
+	DW_OP_implicit_pointer:    "DW_OP_implicit_pointer",
+	DW_OP_addrx:               "DW_OP_addrx",
+	DW_OP_constx:              "DW_OP_constx",
+	DW_OP_entry_value:         "DW_OP_entry_value",
+	DW_OP_regval_type:         "DW_OP_regval_type",
+	DW_OP_deref_type:          "DW_OP_deref_type",
+	DW_OP_xderef_type:         "DW_OP_xderef_type",
+	DW_OP_convert:             "DW_OP_convert",
+	DW_OP_reinterpret:         "DW_OP_reinterpret",
 }
 var opcodeArgs = map[Opcode]string{
 	DW_OP_addr:                "8",
@@ -470,6 +488,15 @@ var opcodeArgs = map[Opcode]string{
 	DW_OP_bit_piece:           "uu",
 	DW_OP_implicit_value:      "B",
 	DW_OP_stack_value:         "",
+	DW_OP_implicit_pointer:    "4s",
+	DW_OP_addrx:               "u",
+	DW_OP_constx:              "u",
+	DW_OP_entry_value:         "B",
+	DW_OP_regval_type:         "uu",
+	DW_OP_deref_type:          "1u",
+	DW_OP_xderef_type:         "1u",
+	DW_OP_convert:             "u",
+	DW_OP_reinterpret:         "u",
 }
 var oplut = map[Opcode]stackfn{
 	DW_OP_addr:           addr,
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -29,6 +30,7 @@ import (
 	"github.com/go-delve/delve/pkg/dwarf/reader"
 	"github.com/go-delve/delve/pkg/goversion"
 	"github.com/go-delve/delve/pkg/logflags"
+	"github.com/go-delve/delve/pkg/proc/debuginfod"
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,6 +43,8 @@ type BinaryInfo struct {
 	// GOOS operating system this binary is executing on.
 	GOOS string
 
+	goarch string // GOARCH of this binary, as passed to NewBinaryInfo
+
 	debugInfoDirectories []string
 
 	// Functions is a list of all DW_TAG_subprogram entries in debug_info, sorted by entry point
@@ -79,7 +83,12 @@ type BinaryInfo struct {
 	types       map[string]dwarfRef
 	packageVars []packageVar // packageVars is a list of all global/package variables in debug_info, sorted by address
 
-	gStructOffset uint64
+	gStructOffset     uint64
+	gStructOffsetTLSG bool // gStructOffset was computed from runtime.tlsg (external linking) or runtime.tls_g (arm64)
+
+	// runtimeGType caches the type of runtime.g, it is reset every time
+	// debug_info is loaded.
+	runtimeGType godwarf.Type
 
 	// nameOfRuntimeType maps an address of a runtime._type struct to its
 	// decoded name. Used with versions of Go <= 1.10 to figure out the DIE of
@@ -116,6 +125,11 @@ var ErrNoDebugInfoFound = errors.New("could not open debug info")
 
 const dwarfGoLanguage = 22 // DW_LANG_Go (from DWARF v5, section 7.12, page 231)
 
+const (
+	dwarfAttrAddrBase     = dwarf.Attr(0x73) // DW_AT_addr_base (from DWARF v5, section 7.5.4, page 211)
+	dwarfAttrLoclistsBase = dwarf.Attr(0x8c) // DW_AT_loclists_base
+)
+
 type compileUnit struct {
 	name   string // univocal name for non-go compile units
 	lowPC  uint64
@@ -123,10 +137,17 @@ type compileUnit struct {
 
 	entry     *dwarf.Entry        // debug_info entry describing this compile unit
 	isgo      bool                // true if this is the go compile unit
+	language  int                 // value of the DW_AT_language attribute
 	lineInfo  *line.DebugLineInfo // debug_line segment associated with this compile unit
 	optimized bool                // this compile unit is optimized
 	producer  string              // producer attribute
 
+	// Bases of the DWARF 5 sections used by the indirect forms we resolve
+	// ourselves (address indices in .debug_loclists and DW_FORM_loclistx),
+	// debug/dwarf resolves DW_FORM_strx and DW_FORM_rnglistx.
+	addrBase     uint64 // DW_AT_addr_base, offset into .debug_addr
+	loclistsBase uint64 // DW_AT_loclists_base, offset into .debug_loclists
+
 	offset dwarf.Offset // offset of the entry describing the compile unit
 
 	image *Image // parent image of this compilation unit.
@@ -256,7 +277,7 @@ type ElfDynamicSection struct {
 
 // NewBinaryInfo returns an initialized but unloaded BinaryInfo struct.
 func NewBinaryInfo(goos, goarch string) *BinaryInfo {
-	r := &BinaryInfo{GOOS: goos, nameOfRuntimeType: make(map[uintptr]nameOfRuntimeTypeEntry), logger: logflags.DebuggerLogger()}
+	r := &BinaryInfo{GOOS: goos, goarch: goarch, nameOfRuntimeType: make(map[uintptr]nameOfRuntimeTypeEntry), logger: logflags.DebuggerLogger()}
 
 	// TODO: find better way to determine proc arch (perhaps use executable file info).
 	switch goarch {
@@ -474,9 +495,26 @@ type Image struct {
 	closer         io.Closer
 	sepDebugCloser io.Closer
 
+	// sepDebugPath is the path of the file containing the separate debug
+	// info for this image, sepDebugFromDebuginfod is true if it was
+	// downloaded from a debuginfod server.
+	sepDebugPath           string
+	sepDebugFromDebuginfod bool
+
 	dwarf       *dwarf.Data
 	dwarfReader *dwarf.Reader
-	loclist     *loclist.Reader
+	loclist2    *loclist.Reader // contents of .debug_loc
+	loclist5    *loclist.Reader // contents of .debug_loclists
+	debugAddr   *godwarf.DebugAddrSection
+
+	// unitVersions maps the offset of compile units to their DWARF version,
+	// it is only loaded when the image has both .debug_loc and
+	// .debug_loclists.
+	unitVersions map[dwarf.Offset]uint8
+
+	// compileUnits are the compile units of this image sorted by increasing
+	// DWARF offset, used to find the unit containing a debug_info entry.
+	compileUnits []*compileUnit
 
 	typeCache map[dwarf.Offset]godwarf.Type
 
@@ -525,6 +563,34 @@ func (bi *BinaryInfo) AddImage(path string, addr uint64) error {
 	return err
 }
 
+// ObjectFile describes an object file (the executable or a shared
+// library) loaded by BinaryInfo.
+type ObjectFile struct {
+	Path         string // path of the object file
+	HasDebugInfo bool   // true if debug info was found for the object file
+	// DebugInfoPath is the path of the separate debug info file, it is empty
+	// if the debug info was read from the object file itself.
+	DebugInfoPath string
+	Debuginfod    bool  // true if the separate debug info file was downloaded from a debuginfod server
+	LoadError     error // error encountered while loading the object file, if any
+}
+
+// LoadedObjectFiles returns the list of object files loaded so far, the
+// first one is always the executable file.
+func (bi *BinaryInfo) LoadedObjectFiles() []ObjectFile {
+	r := make([]ObjectFile, 0, len(bi.Images))
+	for _, image := range bi.Images {
+		r = append(r, ObjectFile{
+			Path:          image.Path,
+			HasDebugInfo:  image.dwarf != nil,
+			DebugInfoPath: image.sepDebugPath,
+			Debuginfod:    image.sepDebugFromDebuginfod,
+			LoadError:     image.LoadError(),
+		})
+	}
+	return r
+}
+
 // moduleDataToImage finds the image corresponding to the given module data object.
 func (bi *BinaryInfo) moduleDataToImage(md *moduleData) *Image {
 	return bi.funcToImage(bi.PCToFunc(uint64(md.text)))
@@ -600,6 +666,67 @@ func (image *Image) LoadError() error {
 	return image.loadErr
 }
 
+// loadLoclists creates the loclist readers for image and loads the
+// .debug_addr section, needed to resolve the address indices of DWARF 5
+// location lists. GetDebugSection is used to read
+// the contents of debug sections and byteOrder is the byte order of the
+// executable file. Location lists are read on demand through the readers
+// returned by getDebugSectionReader, since most of them are never used.
+func (image *Image) loadLoclists(bi *BinaryInfo, byteOrder binary.ByteOrder, getDebugSection func(name string) ([]byte, error), getDebugSectionReader func(name string) (io.ReaderAt, int, error)) {
+	debugLoc, debugLocSize, _ := getDebugSectionReader("loc")
+	debugLoclists, debugLoclistsSize, _ := getDebugSectionReader("loclists")
+	var err error
+	image.loclist2, err = loclist.NewReaderAt(debugLoc, debugLocSize, bi.Arch.PtrSize(), byteOrder)
+	if err != nil {
+		image.setLoadError("could not read location lists: %v", err)
+		return
+	}
+	image.loclist5, err = loclist.NewDwarf5ReaderAt(debugLoclists, debugLoclistsSize, bi.Arch.PtrSize(), byteOrder)
+	if err != nil {
+		image.setLoadError("could not read location lists: %v", err)
+		return
+	}
+	debugAddrBytes, _ := getDebugSection("addr")
+	image.debugAddr = godwarf.ParseAddr(debugAddrBytes, bi.Arch.PtrSize(), byteOrder)
+	if !image.loclist2.Empty() && !image.loclist5.Empty() {
+		// Compile units with different DWARF versions are mixed in the same
+		// image (this can happen with cgo), we need to know the version of each
+		// compile unit to pick the right section.
+		if debugInfoBytes, err := getDebugSection("info"); err == nil {
+			image.unitVersions = godwarf.ReadUnitVersions(debugInfoBytes, byteOrder)
+		}
+	}
+}
+
+// loclistReader returns the loclist reader to use for location lists of
+// compile unit cu. The returned reader is a clone of the reader of the
+// image, so that location lists can be read concurrently.
+func (image *Image) loclistReader(cu *compileUnit) *loclist.Reader {
+	if image.loclist5.Empty() {
+		if image.loclist2.Empty() {
+			return image.loclist2
+		}
+		return image.loclist2.Clone()
+	}
+	if !image.loclist2.Empty() && (cu == nil || image.unitVersions[cu.offset] < 5) {
+		return image.loclist2.Clone()
+	}
+	// Address indices in .debug_loclists are relative to the DW_AT_addr_base
+	// of the compile unit.
+	rdr := image.loclist5.Clone()
+	rdr.SetAddrResolver(nil)
+	if cu != nil && image.debugAddr != nil {
+		rdr.SetAddrResolver(cu.addrx)
+	}
+	return rdr
+}
+
+// addrx returns the address at index idx of the .debug_addr subsection of
+// cu.
+func (cu *compileUnit) addrx(idx uint64) (uint64, error) {
+	return cu.image.debugAddr.GetSubsection(cu.addrBase).Get(idx)
+}
+
 type nilCloser struct{}
 
 func (c *nilCloser) Close() error { return nil }
@@ -617,7 +744,17 @@ func (bi *BinaryInfo) LoadImageFromData(dwdata *dwarf.Data, debugFrameBytes, deb
 		bi.frameEntries = frame.Parse(debugFrameBytes, frame.DwarfEndian(debugFrameBytes), 0)
 	}
 
-	image.loclist = loclist.New(debugLocBytes, bi.Arch.PtrSize())
+	image.loadLoclists(bi, binary.LittleEndian, func(name string) ([]byte, error) {
+		if name == "loc" {
+			return debugLocBytes, nil
+		}
+		return nil, nil
+	}, func(name string) (io.ReaderAt, int, error) {
+		if name == "loc" && debugLocBytes != nil {
+			return bytes.NewReader(debugLocBytes), len(debugLocBytes), nil
+		}
+		return nil, 0, nil
+	})
 
 	bi.loadDebugInfoMaps(image, debugLineBytes, nil, nil)
 
@@ -632,25 +769,78 @@ func (bi *BinaryInfo) locationExpr(entry reader.Entry, attr dwarf.Attr, pc uint6
 	if instr, ok := a.([]byte); ok {
 		var descr bytes.Buffer
 		fmt.Fprintf(&descr, "[block] ")
-		op.PrettyPrint(&descr, instr)
+		op.PrettyPrint(&descr, instr, bi.Arch.PtrSize())
 		return instr, descr.String(), nil
 	}
-	off, ok := a.(int64)
-	if !ok {
-		return nil, "", fmt.Errorf("could not interpret location attribute %s", attr)
+	off, err := bi.loclistOffset(a, attr, pc)
+	if err != nil {
+		return nil, "", err
 	}
-	instr := bi.loclistEntry(off, pc)
-	if instr == nil {
+	e, err := bi.loclistEntry(off, pc)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read loclist at %#x for address %#x: %v", off, pc, err)
+	}
+	if e == nil || e.Instr == nil {
 		return nil, "", fmt.Errorf("could not find loclist entry at %#x for address %#x", off, pc)
 	}
 	var descr bytes.Buffer
 	fmt.Fprintf(&descr, "[%#x:%#x] ", off, pc)
-	op.PrettyPrint(&descr, instr)
-	return instr, descr.String(), nil
+	op.PrettyPrint(&descr, e.Instr, bi.Arch.PtrSize())
+	return e.Instr, descr.String(), nil
+}
+
+// VariableLocation describes where a variable is stored at a given PC.
+type VariableLocation struct {
+	// Loclist is the loclist entry covering the PC, it is nil if the
+	// location of the variable is described by a single location
+	// expression or if no entry of its loclist covers the PC.
+	Loclist *loclist.Entry
+	// Ops is the decoded location expression.
+	Ops []op.Op
+}
+
+// OptimizedOut returns true if the variable has no location at the PC.
+func (loc *VariableLocation) OptimizedOut() bool {
+	return len(loc.Ops) == 0
+}
+
+// LocationOps returns the location expression described by attribute attr
+// of entry at address pc, decoded into a list of operations.
+// If attr is a loclist that does not cover pc an empty VariableLocation is
+// returned, meaning that the variable is optimized out at pc.
+func (bi *BinaryInfo) LocationOps(entry reader.Entry, attr dwarf.Attr, pc uint64) (*VariableLocation, error) {
+	a := entry.Val(attr)
+	if a == nil {
+		return nil, fmt.Errorf("no location attribute %s", attr)
+	}
+	loc := &VariableLocation{}
+	instr, ok := a.([]byte)
+	if !ok {
+		off, err := bi.loclistOffset(a, attr, pc)
+		if err != nil {
+			return nil, err
+		}
+		e, err := bi.loclistEntry(off, pc)
+		if err != nil {
+			return nil, fmt.Errorf("could not read loclist at %#x for address %#x: %v", off, pc, err)
+		}
+		if e == nil {
+			return loc, nil
+		}
+		loc.Loclist = e
+		instr = e.Instr
+	}
+	ops, err := op.DecodeLocExpr(instr, bi.Arch.PtrSize())
+	if err != nil {
+		return nil, err
+	}
+	loc.Ops = ops
+	return loc, nil
 }
 
 // LocationCovers returns the list of PC addresses that is covered by the
-// location attribute 'attr' of entry 'entry'.
+// location attribute 'attr' of entry 'entry'. Entry must belong to the
+// executable file.
 func (bi *BinaryInfo) LocationCovers(entry *dwarf.Entry, attr dwarf.Attr) ([][2]uint64, error) {
 	a := entry.Val(attr)
 	if a == nil {
@@ -664,26 +854,33 @@ func (bi *BinaryInfo) LocationCovers(entry *dwarf.Entry, attr dwarf.Attr) ([][2]
 	if !ok {
 		return nil, fmt.Errorf("attribute %s of unsupported type %T", attr, a)
 	}
-	cu := bi.findCompileUnitForOffset(entry.Offset)
+	cu := bi.findCompileUnitForOffset(bi.Images[0], entry.Offset)
 	if cu == nil {
 		return nil, errors.New("could not find compile unit")
 	}
 
 	image := cu.image
 	base := cu.lowPC
-	if image == nil || image.loclist.Empty() {
+	if image == nil {
+		return nil, errors.New("malformed executable")
+	}
+	rdr := image.loclistReader(cu)
+	if rdr.Empty() {
 		return nil, errors.New("malformed executable")
 	}
 
 	r := [][2]uint64{}
 	var e loclist.Entry
-	image.loclist.Seek(int(off))
-	for image.loclist.Next(&e) {
+	rdr.Seek(int(off))
+	for rdr.Next(&e) {
 		if e.BaseAddressSelection() {
-			base = e.HighPC
 			continue
 		}
-		r = append(r, [2]uint64{e.LowPC + base, e.HighPC + base})
+		lo, hi := rdr.AbsoluteRange(base, &e)
+		r = append(r, [2]uint64{lo, hi})
+	}
+	if err := rdr.Err(); err != nil {
+		return nil, err
 	}
 	return r, nil
 }
@@ -697,36 +894,65 @@ func (bi *BinaryInfo) Location(entry reader.Entry, attr dwarf.Attr, pc uint64, r
 	if err != nil {
 		return 0, nil, "", err
 	}
-	addr, pieces, err := op.ExecuteStackProgram(regs, instr)
+	addr, pieces, err := op.ExecuteStackProgram(regs, instr, bi.Arch.PtrSize())
 	return addr, pieces, descr, err
 }
 
+// loclistOffset returns the offset of the location list referenced by the
+// value a of location attribute attr, which is either a section offset or,
+// for DW_FORM_loclistx, an index into the loclists of the compile unit
+// containing pc.
+func (bi *BinaryInfo) loclistOffset(a interface{}, attr dwarf.Attr, pc uint64) (int64, error) {
+	switch a := a.(type) {
+	case int64:
+		return a, nil
+	case uint64:
+		off, err := bi.loclistxOffset(a, pc)
+		if err != nil {
+			return 0, fmt.Errorf("could not resolve loclist index %d for address %#x: %v", a, pc, err)
+		}
+		return off, nil
+	default:
+		return 0, fmt.Errorf("could not interpret location attribute %s", attr)
+	}
+}
+
+// loclistxOffset returns the offset in .debug_loclists of the location
+// list at index idx (the value of an attribute with form DW_FORM_loclistx)
+// of the compile unit containing pc.
+func (bi *BinaryInfo) loclistxOffset(idx, pc uint64) (int64, error) {
+	cu := bi.findCompileUnit(pc)
+	if cu == nil || cu.image == nil || cu.image.loclist5.Empty() {
+		return 0, errors.New("no .debug_loclists section")
+	}
+	off, err := cu.image.loclist5.ListOffset(cu.loclistsBase, idx)
+	return int64(off), err
+}
+
 // loclistEntry returns the loclist entry in the loclist starting at off,
 // for address pc.
-func (bi *BinaryInfo) loclistEntry(off int64, pc uint64) []byte {
+func (bi *BinaryInfo) loclistEntry(off int64, pc uint64) (*loclist.Entry, error) {
 	var base uint64
 	image := bi.Images[0]
-	if cu := bi.findCompileUnit(pc); cu != nil {
+	cu := bi.findCompileUnit(pc)
+	if cu != nil {
 		base = cu.lowPC
 		image = cu.image
 	}
-	if image == nil || image.loclist.Empty() {
-		return nil
+	if image == nil {
+		return nil, nil
 	}
-
-	image.loclist.Seek(int(off))
-	var e loclist.Entry
-	for image.loclist.Next(&e) {
-		if e.BaseAddressSelection() {
-			base = e.HighPC
-			continue
-		}
-		if pc >= e.LowPC+base+image.StaticBase && pc < e.HighPC+base+image.StaticBase {
-			return e.Instr
-		}
+	rdr := image.loclistReader(cu)
+	if rdr.Empty() {
+		return nil, nil
 	}
 
-	return nil
+	rdr.SetBase(image.StaticBase, base)
+	e, ok := rdr.FindEntry(int(off), pc)
+	if !ok {
+		return nil, rdr.Err()
+	}
+	return e, nil
 }
 
 // findCompileUnit returns the compile unit containing address pc.
@@ -741,14 +967,58 @@ func (bi *BinaryInfo) findCompileUnit(pc uint64) *compileUnit {
 	return nil
 }
 
-func (bi *BinaryInfo) findCompileUnitForOffset(off dwarf.Offset) *compileUnit {
-	i := sort.Search(len(bi.compileUnits), func(i int) bool {
-		return bi.compileUnits[i].offset >= off
+// findCompileUnitForOffset returns the compile unit of image containing
+// the debug_info entry at offset off. DWARF offsets are only unique within
+// an image, each image (the executable, shared libraries and plugins) has
+// its own debug_info section.
+func (bi *BinaryInfo) findCompileUnitForOffset(image *Image, off dwarf.Offset) *compileUnit {
+	i := sort.Search(len(image.compileUnits), func(i int) bool {
+		return image.compileUnits[i].offset > off
 	})
-	if i > 0 {
-		i--
+	if i == 0 {
+		return nil
+	}
+	return image.compileUnits[i-1]
+}
+
+// DIEContribution describes the contribution to debug_info that contains
+// a debug_info entry.
+type DIEContribution struct {
+	ImagePath   string       // path of the image containing the entry
+	CompileUnit string       // name of the compile unit containing the entry
+	UnitOffset  dwarf.Offset // offset of the compile unit entry
+	Producer    string       // producer of the compile unit
+}
+
+// DIEContribution returns the compile unit, and the image it belongs to,
+// that contains the debug_info entry at offset off of image.
+func (bi *BinaryInfo) DIEContribution(image *Image, off dwarf.Offset) (DIEContribution, error) {
+	cu := bi.findCompileUnitForOffset(image, off)
+	if cu == nil {
+		return DIEContribution{}, fmt.Errorf("could not find compile unit for offset %#x in %s", off, image.Path)
+	}
+	return DIEContribution{ImagePath: image.Path, CompileUnit: cu.name, UnitOffset: cu.offset, Producer: cu.producer}, nil
+}
+
+// unitBaseAttr returns the value of the section offset attribute attr of
+// a compile unit entry, or 0 if the attribute isn't present.
+func unitBaseAttr(entry *dwarf.Entry, attr dwarf.Attr) uint64 {
+	v, _ := entry.Val(attr).(int64)
+	return uint64(v)
+}
+
+// UnitLanguage returns the value of the DW_AT_language attribute of the
+// compile unit of the executable whose entry starts at cuOffset. Returns 0
+// if no such compile unit exists or if it doesn't specify a language.
+// Mixed cgo binaries will contain compile units with language DW_LANG_C99
+// (12) as well as DW_LANG_Go (22).
+func (bi *BinaryInfo) UnitLanguage(cuOffset dwarf.Offset) int {
+	for _, cu := range bi.compileUnits {
+		if cu.image.index == 0 && cu.offset == cuOffset {
+			return cu.language
+		}
 	}
-	return bi.compileUnits[i]
+	return 0
 }
 
 // Producer returns the value of DW_AT_producer.
@@ -761,6 +1031,23 @@ func (bi *BinaryInfo) Producer() string {
 	return ""
 }
 
+// Producers returns the distinct values of DW_AT_producer of all compile
+// units, in the order they appear in the debug info. Binaries built with
+// cgo, or linked with C libraries, usually have more than one producer.
+// For Go compile units the compiler flags are omitted.
+func (bi *BinaryInfo) Producers() []string {
+	r := []string{}
+	seen := map[string]bool{}
+	for _, cu := range bi.compileUnits {
+		if cu.producer == "" || seen[cu.producer] {
+			continue
+		}
+		seen[cu.producer] = true
+		r = append(r, cu.producer)
+	}
+	return r
+}
+
 // Type returns the Dwarf type entry at `offset`.
 func (image *Image) Type(offset dwarf.Offset) (godwarf.Type, error) {
 	return godwarf.ReadType(image.dwarf, image.index, offset, image.typeCache)
@@ -795,26 +1082,28 @@ func (e *ErrNoBuildIDNote) Error() string {
 // Alternatively, if the debug file cannot be found be the build-id, Delve
 // will look in directories specified by the debug-info-directories config value.
 func (bi *BinaryInfo) openSeparateDebugInfo(image *Image, exe *elf.File, debugInfoDirectories []string) (*os.File, *elf.File, error) {
-	var debugFilePath string
-	for _, dir := range debugInfoDirectories {
-		var potentialDebugFilePath string
-		if strings.Contains(dir, "build-id") {
-			desc1, desc2, err := parseBuildID(exe)
-			if err != nil {
-				continue
-			}
-			potentialDebugFilePath = fmt.Sprintf("%s/%s/%s.debug", dir, desc1, desc2)
-		} else {
-			potentialDebugFilePath = fmt.Sprintf("%s/%s.debug", dir, filepath.Base(image.Path))
+	var buildID string
+	if desc1, desc2, err := parseBuildID(exe); err == nil {
+		buildID = desc1 + desc2
+	}
+	fromDebuginfod := false
+	debugFilePath, _, err := resolveDebugFile(image.Path, exe, buildID, debugInfoDirectories)
+	if err != nil {
+		// As a last resort ask the debuginfod servers, if any are configured.
+		// Downloads can take a long time and block loading, only do this for
+		// the executable and not for every shared library.
+		if buildID == "" || image.index != 0 {
+			return nil, nil, err
 		}
-		_, err := os.Stat(potentialDebugFilePath)
-		if err == nil {
-			debugFilePath = potentialDebugFilePath
-			break
+		var derr error
+		debugFilePath, derr = debuginfod.GetDebuginfo(buildID)
+		if derr != nil {
+			if derr != debuginfod.ErrDisabled {
+				bi.logger.Warnf("could not download debug info for %s: %v", image.Path, derr)
+			}
+			return nil, nil, err
 		}
-	}
-	if debugFilePath == "" {
-		return nil, nil, ErrNoDebugInfoFound
+		fromDebuginfod = true
 	}
 	sepFile, err := os.OpenFile(debugFilePath, 0, os.ModePerm)
 	if err != nil {
@@ -832,9 +1121,156 @@ func (bi *BinaryInfo) openSeparateDebugInfo(image *Image, exe *elf.File, debugIn
 		return nil, nil, fmt.Errorf("can't open separate debug file %q: %v", debugFilePath, ErrUnsupportedLinuxArch.Error())
 	}
 
+	image.sepDebugPath = debugFilePath
+	image.sepDebugFromDebuginfod = fromDebuginfod
 	return sepFile, elfFile, nil
 }
 
+// ResolveDebugFile searches for the file containing the separate debug
+// info of the executable at path name, the same way it is searched when
+// the executable is loaded, and returns its path as well as the list of
+// candidate paths that were tried, in order:
+//   - for each of the debug info directories (see LoadBinaryInfo) whose
+//     name contains "build-id" the file dir/xx/yyyy.debug, where xxyyyy is
+//     buildID, otherwise the file dir/<name of the executable>.debug;
+//   - the candidates for the file named by the .gnu_debuglink section of
+//     the executable (see findDebugLinkFile).
+//
+// If buildID is empty it is read from the .note.gnu.build-id section of
+// the executable. Debuginfod servers are not queried.
+func (bi *BinaryInfo) ResolveDebugFile(name, buildID string) (path string, tried []string, err error) {
+	exe, err := elf.Open(name)
+	if err == nil {
+		defer exe.Close()
+		if buildID == "" {
+			if desc1, desc2, err := parseBuildID(exe); err == nil {
+				buildID = desc1 + desc2
+			}
+		}
+	}
+	return resolveDebugFile(name, exe, buildID, bi.debugInfoDirectories)
+}
+
+// resolveDebugFile implements ResolveDebugFile for the executable at path,
+// exe can be nil if the executable could not be opened.
+func resolveDebugFile(path string, exe *elf.File, buildID string, debugInfoDirectories []string) (string, []string, error) {
+	var tried []string
+	for _, dir := range debugInfoDirectories {
+		var potentialDebugFilePath string
+		if strings.Contains(dir, "build-id") {
+			if len(buildID) <= 2 {
+				continue
+			}
+			potentialDebugFilePath = fmt.Sprintf("%s/%s/%s.debug", dir, buildID[:2], buildID[2:])
+		} else {
+			potentialDebugFilePath = fmt.Sprintf("%s/%s.debug", dir, filepath.Base(path))
+		}
+		tried = append(tried, potentialDebugFilePath)
+		if _, err := os.Stat(potentialDebugFilePath); err == nil {
+			return potentialDebugFilePath, tried, nil
+		}
+	}
+	if exe == nil {
+		return "", tried, ErrNoDebugInfoFound
+	}
+	debugFilePath, debugLinkTried, err := findDebugLinkFile(path, exe, debugInfoDirectories)
+	return debugFilePath, append(tried, debugLinkTried...), err
+}
+
+// findDebugLinkFile uses the .gnu_debuglink section of exe to find the
+// file containing its separate debug info, as described in GDB's
+// documentation [1]. The file is searched in the directory of the
+// executable, in its .debug subdirectory and in each of
+// debugInfoDirectories (followed by the directory of the executable) as
+// well as in /usr/lib/debug. Candidate files are only accepted if their
+// CRC32 matches the one recorded in .gnu_debuglink. The list of candidate
+// files that were tried is also returned.
+// [1] https://sourceware.org/gdb/onlinedocs/gdb/Separate-Debug-Files.html
+func findDebugLinkFile(path string, exe *elf.File, debugInfoDirectories []string) (string, []string, error) {
+	name, crc, err := parseDebugLink(exe)
+	if err != nil {
+		return "", nil, ErrNoDebugInfoFound
+	}
+
+	exeDir := filepath.Dir(path)
+	if absPath, err := filepath.Abs(path); err == nil {
+		exeDir = filepath.Dir(absPath)
+	}
+
+	candidates := []string{
+		filepath.Join(exeDir, name),
+		filepath.Join(exeDir, ".debug", name),
+	}
+	dirs := make([]string, 0, len(debugInfoDirectories)+1)
+	dirs = append(dirs, debugInfoDirectories...)
+	dirs = append(dirs, "/usr/lib/debug")
+	for _, dir := range dirs {
+		if strings.Contains(dir, "build-id") {
+			continue
+		}
+		candidates = append(candidates, filepath.Join(dir, exeDir, name), filepath.Join(dir, name))
+	}
+
+	tried := make([]string, 0, len(candidates))
+	seen := map[string]bool{path: true}
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		tried = append(tried, candidate)
+		if candidateCRC, err := fileCRC32(candidate); err == nil && candidateCRC == crc {
+			return candidate, tried, nil
+		}
+	}
+	return "", tried, ErrNoDebugInfoFound
+}
+
+// parseDebugLink returns the file name and CRC32 stored in the
+// .gnu_debuglink section of exe.
+func parseDebugLink(exe *elf.File) (string, uint32, error) {
+	sec := exe.Section(".gnu_debuglink")
+	if sec == nil {
+		return "", 0, errors.New("no .gnu_debuglink section")
+	}
+	buf, err := sec.Data()
+	if err != nil {
+		return "", 0, err
+	}
+	zero := bytes.IndexByte(buf, 0)
+	if zero <= 0 {
+		return "", 0, errors.New("malformed .gnu_debuglink section")
+	}
+	name := string(buf[:zero])
+	// the file name is followed by padding up to a 4 byte boundary and the
+	// CRC32 of the debug file.
+	crcoff := (zero + 4) &^ 3
+	if crcoff+4 > len(buf) {
+		return "", 0, errors.New("malformed .gnu_debuglink section")
+	}
+	return name, exe.ByteOrder.Uint32(buf[crcoff:]), nil
+}
+
+// fileCRC32 returns the CRC32 of the contents of the file at path.
+func fileCRC32(path string) (uint32, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, fh); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// elfHasDebugSections returns true if exe has a .debug_info or a
+// .zdebug_info section.
+func elfHasDebugSections(exe *elf.File) bool {
+	return exe.Section(".debug_info") != nil || exe.Section(".zdebug_info") != nil
+}
+
 func parseBuildID(exe *elf.File) (string, string, error) {
 	buildid := exe.Section(".note.gnu.build-id")
 	if buildid == nil {
@@ -906,6 +1342,12 @@ func loadBinaryInfoElf(bi *BinaryInfo, image *Image, path string, addr uint64, w
 		var serr error
 		sepFile, dwarfFile, serr = bi.openSeparateDebugInfo(image, elfFile, bi.debugInfoDirectories)
 		if serr != nil {
+			if serr == ErrNoDebugInfoFound && elfHasDebugSections(elfFile) {
+				// The executable has debug info, but we couldn't read it (for
+				// example because it is compressed in a format we don't support),
+				// report the original error.
+				return err
+			}
 			return serr
 		}
 		image.sepDebugCloser = sepFile
@@ -921,8 +1363,11 @@ func loadBinaryInfoElf(bi *BinaryInfo, image *Image, path string, addr uint64, w
 	if err != nil {
 		return err
 	}
-	debugLocBytes, _ := godwarf.GetDebugSectionElf(dwarfFile, "loc")
-	image.loclist = loclist.New(debugLocBytes, bi.Arch.PtrSize())
+	image.loadLoclists(bi, dwarfFile.ByteOrder, func(name string) ([]byte, error) {
+		return godwarf.GetDebugSectionElf(dwarfFile, name)
+	}, func(name string) (io.ReaderAt, int, error) {
+		return godwarf.GetDebugSectionReaderElf(dwarfFile, name)
+	})
 
 	wg.Add(2)
 	go bi.parseDebugFrameElf(image, dwarfFile, wg)
@@ -961,23 +1406,26 @@ func (bi *BinaryInfo) setGStructOffsetElf(image *Image, exe *elf.File, wg *sync.
 	// - Otherwise, Go asks the external linker to place the G pointer by
 	//   emitting runtime.tlsg, a TLS symbol, which is relocated to the chosen
 	//   offset in libc's TLS block.
+	// On arm64 the G pointer is kept in register X28 instead, programs using
+	// cgo also save it at runtime.tls_g in the TLS block so that it can be
+	// restored after calling C code.
 	symbols, err := exe.Symbols()
 	if err != nil {
 		image.setLoadError("could not parse ELF symbols: %v", err)
 		return
 	}
+	tlsgName := "runtime.tlsg"
+	if exe.Machine == elf.EM_AARCH64 {
+		tlsgName = "runtime.tls_g"
+	}
 	var tlsg *elf.Symbol
 	for _, symbol := range symbols {
-		if symbol.Name == "runtime.tlsg" {
+		if symbol.Name == tlsgName {
 			s := symbol
 			tlsg = &s
 			break
 		}
 	}
-	if tlsg == nil {
-		bi.gStructOffset = ^uint64(8) + 1 // -8
-		return
-	}
 	var tls *elf.Prog
 	for _, prog := range exe.Progs {
 		if prog.Type == elf.PT_TLS {
@@ -985,7 +1433,21 @@ func (bi *BinaryInfo) setGStructOffsetElf(image *Image, exe *elf.File, wg *sync.
 			break
 		}
 	}
-	if tls == nil {
+
+	if exe.Machine == elf.EM_AARCH64 {
+		if tlsg == nil || tls == nil {
+			// g is never saved in thread local storage.
+			return
+		}
+		// The thread pointer (TPIDR_EL0) points to a 16 byte thread control
+		// block, followed by the TLS block aligned to tls.Align.
+		tcbSize := uint64(2 * bi.Arch.PtrSize())
+		bi.gStructOffset = tlsg.Value + tcbSize + ((tls.Vaddr - tcbSize) & (tls.Align - 1))
+		bi.gStructOffsetTLSG = true
+		return
+	}
+
+	if tlsg == nil || tls == nil {
 		bi.gStructOffset = ^uint64(8) + 1 // -8
 		return
 	}
@@ -999,6 +1461,7 @@ func (bi *BinaryInfo) setGStructOffsetElf(image *Image, exe *elf.File, wg *sync.
 	// The TLS register points to the end of the TLS block, which is
 	// tls.Memsz long. runtime.tlsg is an offset from the beginning of that block.
 	bi.gStructOffset = ^(memsz) + 1 + tlsg.Value // -tls.Memsz + tlsg.Value
+	bi.gStructOffsetTLSG = true
 }
 
 // PE ////////////////////////////////////////////////////////////////
@@ -1036,8 +1499,11 @@ func loadBinaryInfoPE(bi *BinaryInfo, image *Image, path string, entryPoint uint
 	if err != nil {
 		return err
 	}
-	debugLocBytes, _ := godwarf.GetDebugSectionPE(peFile, "loc")
-	image.loclist = loclist.New(debugLocBytes, bi.Arch.PtrSize())
+	image.loadLoclists(bi, binary.LittleEndian, func(name string) ([]byte, error) {
+		return godwarf.GetDebugSectionPE(peFile, name)
+	}, func(name string) (io.ReaderAt, int, error) {
+		return godwarf.GetDebugSectionReaderPE(peFile, name)
+	})
 
 	wg.Add(2)
 	go bi.parseDebugFramePE(image, peFile, wg)
@@ -1121,8 +1587,11 @@ func loadBinaryInfoMacho(bi *BinaryInfo, image *Image, path string, entryPoint u
 	if err != nil {
 		return err
 	}
-	debugLocBytes, _ := godwarf.GetDebugSectionMacho(exe, "loc")
-	image.loclist = loclist.New(debugLocBytes, bi.Arch.PtrSize())
+	image.loadLoclists(bi, exe.ByteOrder, func(name string) ([]byte, error) {
+		return godwarf.GetDebugSectionMacho(exe, name)
+	}, func(name string) (io.ReaderAt, int, error) {
+		return godwarf.GetDebugSectionReaderMacho(exe, name)
+	})
 
 	wg.Add(2)
 	go bi.parseDebugFrameMacho(image, exe, wg)
@@ -1169,6 +1638,19 @@ func (bi *BinaryInfo) findType(name string) (godwarf.Type, error) {
 	return godwarf.ReadType(image.dwarf, ref.imageIndex, ref.offset, image.typeCache)
 }
 
+// findRuntimeGType returns the type of runtime.g.
+func (bi *BinaryInfo) findRuntimeGType() (godwarf.Type, error) {
+	if bi.runtimeGType != nil {
+		return bi.runtimeGType, nil
+	}
+	typ, err := bi.findType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+	bi.runtimeGType = typ
+	return typ, nil
+}
+
 func (bi *BinaryInfo) findTypeExpr(expr ast.Expr) (godwarf.Type, error) {
 	if lit, islit := expr.(*ast.BasicLit); islit && lit.Kind == token.STRING {
 		// Allow users to specify type names verbatim as quoted
@@ -1285,6 +1767,7 @@ func (bi *BinaryInfo) loadDebugInfoMaps(image *Image, debugLineBytes []byte, wg
 	}
 
 	image.runtimeTypeToDIE = make(map[uint64]runtimeTypeDIE)
+	bi.runtimeGType = nil
 
 	ctxt := newLoadDebugInfoMapsContext(bi, image)
 
@@ -1301,9 +1784,9 @@ func (bi *BinaryInfo) loadDebugInfoMaps(image *Image, debugLineBytes []byte, wg
 			cu.image = image
 			cu.entry = entry
 			cu.offset = entry.Offset
-			if lang, _ := entry.Val(dwarf.AttrLanguage).(int64); lang == dwarfGoLanguage {
-				cu.isgo = true
-			}
+			lang, _ := entry.Val(dwarf.AttrLanguage).(int64)
+			cu.language = int(lang)
+			cu.isgo = cu.language == dwarfGoLanguage
 			cu.name, _ = entry.Val(dwarf.AttrName).(string)
 			compdir, _ := entry.Val(dwarf.AttrCompDir).(string)
 			if compdir != "" {
@@ -1343,7 +1826,10 @@ func (bi *BinaryInfo) loadDebugInfoMaps(image *Image, debugLineBytes []byte, wg
 			if cu.isgo && gopkg != "" {
 				bi.PackageMap[gopkg] = append(bi.PackageMap[gopkg], escapePackagePath(strings.Replace(cu.name, "\\", "/", -1)))
 			}
+			cu.addrBase = unitBaseAttr(entry, dwarfAttrAddrBase)
+			cu.loclistsBase = unitBaseAttr(entry, dwarfAttrLoclistsBase)
 			bi.compileUnits = append(bi.compileUnits, cu)
+			image.compileUnits = append(image.compileUnits, cu)
 			if entry.Children {
 				bi.loadDebugInfoMapsCompileUnit(ctxt, image, reader, cu)
 			}
@@ -1358,6 +1844,7 @@ func (bi *BinaryInfo) loadDebugInfoMaps(image *Image, debugLineBytes []byte, wg
 	}
 
 	sort.Sort(compileUnitsByOffset(bi.compileUnits))
+	sort.Sort(compileUnitsByOffset(image.compileUnits))
 	sort.Sort(functionsDebugInfoByEntry(bi.Functions))
 	sort.Sort(packageVarsByAddr(bi.packageVars))
 
@@ -1418,7 +1905,11 @@ func (bi *BinaryInfo) loadDebugInfoMapsCompileUnit(ctxt *loadDebugInfoMapsContex
 				var addr uint64
 				if loc, ok := entry.Val(dwarf.AttrLocation).([]byte); ok {
 					if len(loc) == bi.Arch.PtrSize()+1 && op.Opcode(loc[0]) == op.DW_OP_addr {
-						addr = binary.LittleEndian.Uint64(loc[1:])
+						if bi.Arch.PtrSize() == 4 {
+							addr = uint64(binary.LittleEndian.Uint32(loc[1:]))
+						} else {
+							addr = binary.LittleEndian.Uint64(loc[1:])
+						}
 					}
 				}
 				if !cu.isgo {
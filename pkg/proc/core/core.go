@@ -355,6 +355,13 @@ func (t *Thread) Common() *proc.CommonThread {
 	return &t.common
 }
 
+// Postmortem always returns true, core files and minidumps are
+// snapshots of dead processes and may not contain the thread local storage
+// of threads.
+func (t *Thread) Postmortem() bool {
+	return true
+}
+
 // SetPC will always return an error, you cannot
 // change register values when debugging core files.
 func (t *Thread) SetPC(uint64) error {
@@ -389,6 +389,16 @@ func TestMinidump(t *testing.T) {
 		t.Fatalf("GoroutinesInfo() = %v, %v; wanted at least one goroutine", gs, err)
 	}
 	t.Logf("%d goroutines", len(gs))
+	foundThreadG := false
+	for _, th := range p.ThreadList() {
+		if g, _ := proc.GetG(th); g != nil {
+			t.Logf("thread %d running goroutine %d", th.ThreadID(), g.ID)
+			foundThreadG = true
+		}
+	}
+	if !foundThreadG {
+		t.Errorf("could not find the goroutine of any thread")
+	}
 	foundMain, foundTime := false, false
 	for _, g := range gs {
 		stack, err := g.Stacktrace(10, 0)
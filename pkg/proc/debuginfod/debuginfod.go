@@ -0,0 +1,120 @@
+// Package debuginfod implements a client for debuginfod servers, used to
+// download the separate debug info of an executable given its build ID.
+//
+// See https://sourceware.org/elfutils/Debuginfod.html
+package debuginfod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// URLsEnv is the environment variable containing the space separated
+// list of debuginfod servers to query. If it is empty no network access
+// is attempted.
+const URLsEnv = "DEBUGINFOD_URLS"
+
+// Timeout is the maximum time spent waiting for a single server.
+var Timeout = 30 * time.Second
+
+// ErrDisabled is returned by GetDebuginfo when no debuginfod server is
+// configured.
+var ErrDisabled = errors.New("debuginfod is disabled, " + URLsEnv + " is not set")
+
+// GetDebuginfo returns the path to a file containing the debug info of
+// the executable with the specified build ID, downloading it from the
+// servers listed in $DEBUGINFOD_URLS if it isn't already in the cache
+// directory.
+func GetDebuginfo(buildID string) (string, error) {
+	urls := strings.Fields(os.Getenv(URLsEnv))
+	if len(urls) == 0 {
+		return "", ErrDisabled
+	}
+	return getDebuginfo(urls, buildID)
+}
+
+func getDebuginfo(urls []string, buildID string) (string, error) {
+	if !validBuildID(buildID) {
+		return "", fmt.Errorf("invalid build ID %q", buildID)
+	}
+
+	cacheDir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, buildID)
+	path := filepath.Join(dir, "debuginfo")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	client := &http.Client{Timeout: Timeout}
+	var errs []string
+	for _, url := range urls {
+		err := download(client, strings.TrimSuffix(url, "/")+"/buildid/"+buildID+"/debuginfo", dir, path)
+		if err == nil {
+			return path, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("could not download debug info for build ID %s: %s", buildID, strings.Join(errs, "; "))
+}
+
+// download downloads url into path, dir is the directory containing path.
+func download(client *http.Client, url, dir, path string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// Download to a temporary file first so that an interrupted download
+	// doesn't leave a truncated file in the cache.
+	fh, err := ioutil.TempFile(dir, "debuginfo")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fh, resp.Body)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	return os.Rename(fh.Name(), path)
+}
+
+// cacheDir returns the directory used to cache downloaded files.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dlv", "debuginfod"), nil
+}
+
+func validBuildID(buildID string) bool {
+	if buildID == "" {
+		return false
+	}
+	for _, ch := range buildID {
+		if !((ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
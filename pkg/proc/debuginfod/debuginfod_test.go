@@ -0,0 +1,60 @@
+package debuginfod
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestGetDebuginfo(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("cache directory can not be overridden on " + runtime.GOOS)
+	}
+	tmpdir, err := ioutil.TempDir("", "debuginfod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	savedCache := os.Getenv("XDG_CACHE_HOME")
+	defer os.Setenv("XDG_CACHE_HOME", savedCache)
+	os.Setenv("XDG_CACHE_HOME", tmpdir)
+
+	const buildID = "0123456789abcdef"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/buildid/"+buildID+"/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("debug info"))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		path, err := getDebuginfo([]string{srv.URL + "/"}, buildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "debug info" {
+			t.Fatalf("wrong contents %q", buf)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected downloaded file to be cached, got %d requests", requests)
+	}
+
+	if _, err := getDebuginfo([]string{srv.URL}, "fedcba9876543210"); err == nil {
+		t.Errorf("expected error for missing build ID")
+	}
+	if _, err := getDebuginfo([]string{srv.URL}, "../../etc"); err == nil {
+		t.Errorf("expected error for invalid build ID")
+	}
+}
@@ -310,3 +310,52 @@ func TestIssue1636_InlineWithoutOrigin(t *testing.T) {
 	dwb.TagClose()
 	fakeBinaryInfo(t, dwb)
 }
+
+func TestUnitLanguage(t *testing.T) {
+	// Two compile units, one for C (DW_LANG_C99) and one for Go, sharing
+	// the same abbreviation table.
+	abbrev := []byte{
+		0x1,                        // abbrev code
+		byte(dwarf.TagCompileUnit), // tag
+		0x0,                        // no children
+		byte(dwarf.AttrName), byte(dwarfbuilder.DW_FORM_string),
+		byte(dwarf.AttrLanguage), byte(dwarfbuilder.DW_FORM_data1),
+		0x0, 0x0,
+		0x0,
+	}
+	var info bytes.Buffer
+	addUnit := func(name string, lang uint8) dwarf.Offset {
+		body := []byte{
+			0x4, 0x0, // version
+			0x0, 0x0, 0x0, 0x0, // debug_abbrev_offset
+			0x8, // address_size
+			0x1, // abbrev code
+		}
+		body = append(body, name...)
+		body = append(body, 0x0, lang)
+		binary.Write(&info, binary.LittleEndian, uint32(len(body)))
+		off := dwarf.Offset(info.Len() + 7)
+		info.Write(body)
+		return off
+	}
+	cOff := addUnit("cfile.c", 12)
+	goOff := addUnit("main", 22)
+
+	dwdata, err := dwarf.New(abbrev, nil, nil, info.Bytes(), nil, nil, nil, nil)
+	assertNoError(err, t, "creating dwarf")
+	bi := proc.NewBinaryInfo("linux", "amd64")
+	bi.LoadImageFromData(dwdata, nil, nil, nil)
+
+	for _, tc := range []struct {
+		off  dwarf.Offset
+		lang int
+	}{
+		{cOff, 12},
+		{goOff, 22},
+		{goOff + 1, 0},
+	} {
+		if lang := bi.UnitLanguage(tc.off); lang != tc.lang {
+			t.Errorf("wrong language for unit at %#x: %d, expected %d", tc.off, lang, tc.lang)
+		}
+	}
+}
@@ -173,6 +173,38 @@ func (scope *EvalScope) Locals() ([]*Variable, error) {
 	return vars, nil
 }
 
+// VariableLocation returns the location expression of the local variable
+// or argument called name at the current PC, it can be used to find out
+// why a variable is unreadable (for example because it is optimized out).
+// If more than one variable called name is visible the innermost one is
+// used.
+func (scope *EvalScope) VariableLocation(name string) (*VariableLocation, error) {
+	if scope.Fn == nil {
+		return nil, errors.New("unable to find function context")
+	}
+
+	var found reader.Entry
+	foundDepth := -1
+	varReader := reader.Variables(scope.image().dwarf, scope.Fn.offset, reader.ToRelAddr(scope.PC, scope.image().StaticBase), scope.Line, true, false)
+	for varReader.Next() {
+		entry := varReader.Entry()
+		n, _ := entry.Val(dwarf.AttrName).(string)
+		if n != name && n != "&"+name {
+			continue
+		}
+		if depth := varReader.Depth(); depth >= foundDepth {
+			found, foundDepth = entry, depth
+		}
+	}
+	if err := varReader.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("could not find symbol value for %s", name)
+	}
+	return scope.BinInfo.LocationOps(found, dwarf.AttrLocation, scope.PC)
+}
+
 func afterLastArgAddr(vars []*Variable) uintptr {
 	for i := len(vars) - 1; i >= 0; i-- {
 		v := vars[i]
@@ -0,0 +1,265 @@
+package proc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	protest "github.com/go-delve/delve/pkg/proc/test"
+)
+
+// loadTestBinaryInfo returns the BinaryInfo of the testnextprog fixture,
+// the caller must close it.
+func loadTestBinaryInfo(t testing.TB) *BinaryInfo {
+	t.Helper()
+	fixture := protest.BuildFixture("testnextprog", 0)
+	bi := NewBinaryInfo(runtime.GOOS, runtime.GOARCH)
+	if err := bi.LoadBinaryInfo(fixture.Path, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	return bi
+}
+
+// fakeMemory is a MemoryReadWriter that reads from buf, mapped at base,
+// and returns zeroes for every other address. Every read fails if err is
+// set, so do reads overlapping one of holes and reads larger than maxRead,
+// if it isn't zero. The number of reads of each size is counted in reads.
+type fakeMemory struct {
+	base    uintptr
+	buf     []byte
+	holes   []memHole
+	maxRead int
+	err     error
+	reads   map[int]int
+}
+
+func (mem *fakeMemory) ReadMemory(data []byte, addr uintptr) (int, error) {
+	if mem.reads == nil {
+		mem.reads = make(map[int]int)
+	}
+	mem.reads[len(data)]++
+	if mem.err != nil {
+		return 0, mem.err
+	}
+	if mem.maxRead > 0 && len(data) > mem.maxRead {
+		return 0, errors.New("read too large")
+	}
+	for i := range mem.holes {
+		if mem.holes[i].overlaps(addr, len(data)) {
+			return 0, fmt.Errorf("unmapped %#x", mem.holes[i].addr)
+		}
+	}
+	for i := range data {
+		data[i] = 0
+		if a := addr + uintptr(i); a >= mem.base && a < mem.base+uintptr(len(mem.buf)) {
+			data[i] = mem.buf[a-mem.base]
+		}
+	}
+	return len(data), nil
+}
+
+func (mem *fakeMemory) WriteMemory(addr uintptr, data []byte) (int, error) {
+	if mem.err != nil {
+		return 0, mem.err
+	}
+	if addr < mem.base || addr+uintptr(len(data)) > mem.base+uintptr(len(mem.buf)) {
+		return 0, fmt.Errorf("write outside of buffer %#x", addr)
+	}
+	return copy(mem.buf[addr-mem.base:], data), nil
+}
+
+// totalReads returns the number of calls to ReadMemory.
+func (mem *fakeMemory) totalReads() int {
+	n := 0
+	for _, cnt := range mem.reads {
+		n += cnt
+	}
+	return n
+}
+
+// Memory layout of the goroutines returned by fakeGMemory and
+// fakeGOnStack: the g struct is at fakeGAddr and the goroutine stack is
+// [fakeStackLo, fakeStackHi).
+const (
+	fakeGAddr   = 0x1000
+	fakeStackLo = 0x2000
+	fakeStackHi = 0x3000
+)
+
+// fakeGMemory returns a memory containing a zeroed runtime.g struct at
+// fakeGAddr, followed by the goroutine stack, and the fields of runtime.g
+// by name. Members of struct fields are also returned, named
+// "<field>.<member>", with their offset from the start of runtime.g.
+func fakeGMemory(t testing.TB, bi *BinaryInfo) (*fakeMemory, map[string]*godwarf.StructField) {
+	t.Helper()
+	typ, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ.Size() > fakeStackLo-fakeGAddr {
+		t.Fatalf("runtime.g too large: %d bytes", typ.Size())
+	}
+	fields := map[string]*godwarf.StructField{}
+	for _, field := range resolveTypedef(typ).(*godwarf.StructType).Field {
+		fields[field.Name] = field
+		styp, isstruct := resolveTypedef(field.Type).(*godwarf.StructType)
+		if !isstruct {
+			continue
+		}
+		for _, member := range styp.Field {
+			fields[field.Name+"."+member.Name] = &godwarf.StructField{Name: member.Name, Type: member.Type, ByteOffset: field.ByteOffset + member.ByteOffset}
+		}
+	}
+	return &fakeMemory{base: fakeGAddr, buf: make([]byte, fakeStackHi-fakeGAddr)}, fields
+}
+
+// parseFakeG parses the g struct at fakeGAddr.
+func parseFakeG(t testing.TB, bi *BinaryInfo, mem MemoryReadWriter) *G {
+	t.Helper()
+	gvar, err := newGVariableMem(bi, mem, fakeGAddr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gvar.parseG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// fakeGOnStack returns a waiting goroutine stopped at pc with stack
+// pointer sp, the words of stack are written to the goroutine stack
+// starting at sp.
+func fakeGOnStack(t testing.TB, bi *BinaryInfo, pc, sp uint64, stack ...uint64) *G {
+	t.Helper()
+	mem, fields := fakeGMemory(t, bi)
+	put := func(off int64, val uint64) {
+		binary.LittleEndian.PutUint64(mem.buf[off:], val)
+	}
+	mem.buf[fields["atomicstatus"].ByteOffset] = byte(Gwaiting)
+	put(fields["sched.pc"].ByteOffset, pc)
+	put(fields["sched.sp"].ByteOffset, sp)
+	put(fields["stack.lo"].ByteOffset, fakeStackLo)
+	put(fields["stack.hi"].ByteOffset, fakeStackHi)
+	for i, val := range stack {
+		put(int64(sp-fakeGAddr)+int64(8*i), val)
+	}
+	return parseFakeG(t, bi, mem)
+}
+
+// fakeDeferType builds a _defer struct type with the given fields, fields
+// are either "int32", "bool", "uintptr", "*funcval", "func()" or "*_defer".
+func fakeDeferType(fields ...[2]string) *godwarf.StructType {
+	uintptrType := &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "uintptr", ReflectKind: reflect.Uintptr}}}
+	funcvalType := &godwarf.StructType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "runtime.funcval"}, StructName: "runtime.funcval", Kind: "struct", Field: []*godwarf.StructField{{Name: "fn", Type: uintptrType, ByteOffset: 0}}}
+	deferType := &godwarf.StructType{CommonType: godwarf.CommonType{Name: "runtime._defer"}, StructName: "runtime._defer", Kind: "struct"}
+	off := int64(0)
+	for _, field := range fields {
+		var typ godwarf.Type
+		switch field[1] {
+		case "int32":
+			typ = &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 4, Name: "int32", ReflectKind: reflect.Int32}}}
+		case "bool":
+			typ = &godwarf.BoolType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "bool", ReflectKind: reflect.Bool}}}
+		case "uintptr":
+			typ = uintptrType
+		case "*funcval":
+			typ = &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*runtime.funcval", ReflectKind: reflect.Ptr}, Type: funcvalType}
+		case "func()":
+			typ = &godwarf.FuncType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "func()", ReflectKind: reflect.Func}}
+		case "*_defer":
+			typ = &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*runtime._defer", ReflectKind: reflect.Ptr}, Type: deferType}
+		}
+		if sz := typ.Size(); off%sz != 0 {
+			off += sz - off%sz
+		}
+		deferType.Field = append(deferType.Field, &godwarf.StructField{Name: field[0], Type: typ, ByteOffset: off})
+		off += typ.Size()
+	}
+	deferType.ByteSize = (off + 7) &^ 7
+	return deferType
+}
+
+// arch32 is an architecture with 4 byte pointers.
+type arch32 struct {
+	Arch
+}
+
+func (arch32) PtrSize() int { return 4 }
+
+// fakeAllgs returns a memory containing the runtime variables of bi
+// describing a runtime.allgs with a goroutine for each element of
+// statuses, the goroutine ID of the i-th goroutine is i+1.
+func fakeAllgs(t testing.TB, bi *BinaryInfo, statuses []uint64) *fakeMemory {
+	var gcache goroutineCache
+	gcache.init(bi)
+	if gcache.allglenAddr == 0 || gcache.allgentryAddr == 0 {
+		t.Fatal("could not find runtime.allgs")
+	}
+	_, fields := fakeGMemory(t, bi)
+	typ, _ := bi.findRuntimeGType()
+	gsize := int(typ.Size())
+
+	// The goroutines are placed after the runtime variables.
+	base := gcache.allglenAddr
+	if gcache.allgentryAddr < base {
+		base = gcache.allgentryAddr
+	}
+	allgptr := gcache.allglenAddr
+	if gcache.allgentryAddr > allgptr {
+		allgptr = gcache.allgentryAddr
+	}
+	allgptr = (allgptr + 0x100) &^ 0xf
+	gaddr := allgptr + uint64(8*len(statuses))
+	mem := &fakeMemory{base: uintptr(base), buf: make([]byte, int(gaddr-base)+gsize*len(statuses))}
+	binary.LittleEndian.PutUint64(mem.buf[gcache.allglenAddr-base:], uint64(len(statuses)))
+	binary.LittleEndian.PutUint64(mem.buf[gcache.allgentryAddr-base:], allgptr)
+	for i, status := range statuses {
+		addr := gaddr + uint64(i*gsize)
+		binary.LittleEndian.PutUint64(mem.buf[allgptr-base+uint64(8*i):], addr)
+		mem.buf[addr-base+uint64(fields["atomicstatus"].ByteOffset)] = byte(status)
+		binary.LittleEndian.PutUint64(mem.buf[addr-base+uint64(fields["goid"].ByteOffset):], uint64(i+1))
+	}
+	return mem
+}
+
+// tlsRegisters is a Registers implementation that only has a TLS base, a
+// stack pointer, a program counter and optionally a g register.
+type tlsRegisters struct {
+	tls, sp, pc uint64
+	gaddr       uint64
+	hasgaddr    bool
+}
+
+func (regs *tlsRegisters) PC() uint64                          { return regs.pc }
+func (regs *tlsRegisters) SP() uint64                          { return regs.sp }
+func (regs *tlsRegisters) BP() uint64                          { return 0 }
+func (regs *tlsRegisters) TLS() uint64                         { return regs.tls }
+func (regs *tlsRegisters) GAddr() (uint64, bool)               { return regs.gaddr, regs.hasgaddr }
+func (regs *tlsRegisters) Get(int) (uint64, error)             { return 0, errors.New("not implemented") }
+func (regs *tlsRegisters) Slice(floatingPoint bool) []Register { return nil }
+func (regs *tlsRegisters) Copy() Registers                     { return regs }
+
+// fakeGType returns a copy of the runtime.g type of bi where every field
+// is replaced by the result of edit, fields for which edit returns nil are
+// removed. It is used to emulate the g struct of older versions of Go.
+func fakeGType(t testing.TB, bi *BinaryInfo, edit func(field *godwarf.StructField) *godwarf.StructField) *godwarf.StructType {
+	t.Helper()
+	typ, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	styp := resolveTypedef(typ).(*godwarf.StructType)
+	r := *styp
+	r.Field = nil
+	for _, field := range styp.Field {
+		if field = edit(field); field != nil {
+			r.Field = append(r.Field, field)
+		}
+	}
+	return &r
+}
@@ -1,27 +1,33 @@
 package proc
 
-import "encoding/binary"
-
 type goroutineCache struct {
-	partialGCache map[int]*G
+	partialGCache map[int64]*G
 	allGCache     []*G
 
+	// goidIndex maps the ID of each goroutine in runtime.allgs to the
+	// address of its g struct, it is only used once indexComplete is set.
+	goidIndex     map[int64]uintptr
+	indexComplete bool
+
 	allgentryAddr, allglenAddr uint64
 }
 
 func (gcache *goroutineCache) init(bi *BinaryInfo) {
 	var err error
 
+	if len(bi.Images) == 0 {
+		return
+	}
 	exeimage := bi.Images[0]
 	rdr := exeimage.DwarfReader()
 
-	gcache.allglenAddr, _ = rdr.AddrFor("runtime.allglen", exeimage.StaticBase)
+	gcache.allglenAddr, _ = rdr.AddrFor("runtime.allglen", exeimage.StaticBase, bi.Arch.PtrSize())
 
 	rdr.Seek(0)
-	gcache.allgentryAddr, err = rdr.AddrFor("runtime.allgs", exeimage.StaticBase)
+	gcache.allgentryAddr, err = rdr.AddrFor("runtime.allgs", exeimage.StaticBase, bi.Arch.PtrSize())
 	if err != nil {
 		// try old name (pre Go 1.6)
-		gcache.allgentryAddr, _ = rdr.AddrFor("runtime.allg", exeimage.StaticBase)
+		gcache.allgentryAddr, _ = rdr.AddrFor("runtime.allg", exeimage.StaticBase, bi.Arch.PtrSize())
 	}
 }
 
@@ -29,32 +35,182 @@ func (gcache *goroutineCache) getRuntimeAllg(bi *BinaryInfo, mem MemoryReadWrite
 	if gcache.allglenAddr == 0 || gcache.allgentryAddr == 0 {
 		return 0, 0, ErrNoRuntimeAllG
 	}
-	allglenBytes := make([]byte, 8)
-	_, err := mem.ReadMemory(allglenBytes, uintptr(gcache.allglenAddr))
+	// runtime.allglen is an uintptr, like the pointer in runtime.allgs
+	ptrSize := int64(bi.Arch.PtrSize())
+	allglen, err := readUintRaw(mem, uintptr(gcache.allglenAddr), ptrSize)
 	if err != nil {
 		return 0, 0, err
 	}
-	allglen := binary.LittleEndian.Uint64(allglenBytes)
 
-	faddr := make([]byte, bi.Arch.PtrSize())
-	_, err = mem.ReadMemory(faddr, uintptr(gcache.allgentryAddr))
+	allgptr, err := readUintRaw(mem, uintptr(gcache.allgentryAddr), ptrSize)
 	if err != nil {
 		return 0, 0, err
 	}
-	allgptr := binary.LittleEndian.Uint64(faddr)
 
 	return allgptr, allglen, nil
 }
 
+// allgs returns an iterator over the entries of runtime.allgs.
+func (gcache *goroutineCache) allgs(bi *BinaryInfo, mem MemoryReadWriter) (*allgsIterator, error) {
+	allgptr, allglen, err := gcache.getRuntimeAllg(bi, mem)
+	if err != nil {
+		return nil, err
+	}
+	it := &allgsIterator{mem: mem, bi: bi, allgptr: allgptr, allglen: allglen}
+	it.statusOff, it.statusSize, it.statusErr = gStatusField(bi)
+	return it, nil
+}
+
+// newAllgsIterator returns an iterator over the entries of runtime.allgs
+// for callers that don't have a goroutineCache.
+func newAllgsIterator(mem MemoryReadWriter, bi *BinaryInfo) (*allgsIterator, error) {
+	var gcache goroutineCache
+	gcache.init(bi)
+	return gcache.allgs(bi, mem)
+}
+
+// allgsIterator reads the entries of runtime.allgs, either parsing the
+// whole goroutine or only reading its status.
+type allgsIterator struct {
+	mem              MemoryReadWriter
+	bi               *BinaryInfo
+	allgptr, allglen uint64
+
+	// statusOff and statusSize locate the atomicstatus field of runtime.g,
+	// statusErr is set if it could not be found.
+	statusOff  uint64
+	statusSize int64
+	statusErr  error
+}
+
+// status reads the status of the i-th goroutine without parsing it, ok is
+// false if the entry is nil.
+func (it *allgsIterator) status(i uint64) (status uint64, ok bool, err error) {
+	if it.statusErr != nil {
+		return 0, false, it.statusErr
+	}
+	ptrSize := int64(it.bi.Arch.PtrSize())
+	gaddr, err := readUintRaw(it.mem, uintptr(it.allgptr+i*uint64(ptrSize)), ptrSize)
+	if err != nil || gaddr == 0 {
+		return 0, false, err
+	}
+	status, err = readUintRaw(it.mem, uintptr(gaddr+it.statusOff), it.statusSize)
+	return status, err == nil, err
+}
+
+// g reads and parses the i-th goroutine. If the goroutine can not be read
+// a G with the Unreadable field set is returned, along with the error.
+func (it *allgsIterator) g(i uint64) (*G, error) {
+	gvar, err := newGVariableMem(it.bi, it.mem, uintptr(it.allgptr+(i*uint64(it.bi.Arch.PtrSize()))), true)
+	if err != nil {
+		return &G{Unreadable: err}, err
+	}
+	g, err := gvar.parseG()
+	if err != nil {
+		return &G{Unreadable: err}, err
+	}
+	return g, nil
+}
+
+// each calls fn for the goroutines at indices [start, end) of
+// runtime.allgs until it returns false. If skipDead is set dead goroutines
+// are skipped, their status is read before parsing them so that skipping
+// one only costs two small reads.
+func (it *allgsIterator) each(start, end uint64, skipDead bool, fn func(*G) bool) {
+	if end > it.allglen {
+		end = it.allglen
+	}
+	for i := start; i < end; i++ {
+		if skipDead {
+			if status, ok, _ := it.status(i); ok && status == Gdead {
+				continue
+			}
+		}
+		g, err := it.g(i)
+		if skipDead && err == nil && g.Status == Gdead {
+			continue
+		}
+		if !fn(g) {
+			return
+		}
+	}
+}
+
 func (gcache *goroutineCache) addGoroutine(g *G) {
 	if gcache.partialGCache == nil {
-		gcache.partialGCache = make(map[int]*G)
+		gcache.partialGCache = make(map[int64]*G)
 	}
-	gcache.partialGCache[g.ID] = g
+	gcache.partialGCache[g.ID64()] = g
+	gcache.addIndex(g)
+}
+
+func (gcache *goroutineCache) addIndex(g *G) {
+	if g.variable == nil {
+		return
+	}
+	if gcache.goidIndex == nil {
+		gcache.goidIndex = make(map[int64]uintptr)
+	}
+	gcache.goidIndex[g.ID64()] = g.variable.Addr
+}
+
+// GoroutineByID returns the goroutine with the specified ID, or nil if
+// there is no such goroutine in runtime.allgs.
+// The first call walks runtime.allgs to build an index from goroutine IDs
+// to g structs, after that only the requested goroutine is parsed.
+func (gcache *goroutineCache) GoroutineByID(bi *BinaryInfo, mem MemoryReadWriter, id int64) (*G, error) {
+	if g := gcache.partialGCache[id]; g != nil {
+		return g, nil
+	}
+	if !gcache.indexComplete {
+		allgs, err := gcache.allgs(bi, mem)
+		if err != nil {
+			return nil, err
+		}
+		allgs.each(0, allgs.allglen, false, func(g *G) bool {
+			if g.Unreadable == nil {
+				gcache.addIndex(g)
+			}
+			return true
+		})
+		gcache.indexComplete = true
+	}
+	gaddr, ok := gcache.goidIndex[id]
+	if !ok {
+		return nil, nil
+	}
+	gvar, err := newGVariableMem(bi, mem, gaddr, false)
+	if err != nil {
+		return nil, err
+	}
+	g, err := gvar.parseG()
+	if err != nil {
+		return nil, err
+	}
+	gcache.addGoroutine(g)
+	return g, nil
+}
+
+// Invalidate discards the goroutine ID index, it must be called whenever
+// the target resumes execution.
+func (gcache *goroutineCache) Invalidate() {
+	gcache.goidIndex = nil
+	gcache.indexComplete = false
 }
 
 // Clear clears the cached contents of the cache for runtime.allgs.
 func (gcache *goroutineCache) Clear() {
+	for _, g := range gcache.partialGCache {
+		g.clearCachedStack()
+		g.releaseMemory()
+	}
+	for _, g := range gcache.allGCache {
+		if g != nil {
+			g.clearCachedStack()
+			g.releaseMemory()
+		}
+	}
 	gcache.partialGCache = nil
 	gcache.allGCache = nil
+	gcache.Invalidate()
 }
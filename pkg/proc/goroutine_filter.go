@@ -0,0 +1,484 @@
+package proc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/constant"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FilterGoroutines returns the goroutines in gs for which pred returns
+// true.
+func FilterGoroutines(gs []*G, pred func(*G) bool) []*G {
+	r := []*G{}
+	for _, g := range gs {
+		if pred(g) {
+			r = append(r, g)
+		}
+	}
+	return r
+}
+
+// readableG returns true if g was read successfully from the target.
+func readableG(g *G) bool {
+	return g != nil && g.Unreadable == nil && (g.variable == nil || g.variable.Unreadable == nil)
+}
+
+// WaitReasonIs returns a predicate, for use with FilterGoroutines, that
+// matches goroutines whose wait reason is s.
+func WaitReasonIs(s string) func(*G) bool {
+	return func(g *G) bool {
+		return readableG(g) && g.WaitReason == s
+	}
+}
+
+// StatusIs returns a predicate, for use with FilterGoroutines, that
+// matches goroutines whose status is status.
+func StatusIs(status uint64) func(*G) bool {
+	return func(g *G) bool {
+		return readableG(g) && g.Status == status
+	}
+}
+
+// OnUserFrame returns a predicate, for use with FilterGoroutines, that
+// matches goroutines whose user location (see (*G).UserCurrent) is inside
+// the function named fn.
+func OnUserFrame(fn string) func(*G) bool {
+	return func(g *G) bool {
+		if !readableG(g) || g.variable == nil {
+			return false
+		}
+		loc := g.UserCurrent()
+		return loc.Fn != nil && loc.Fn.Name == fn
+	}
+}
+
+// InPackage returns a predicate, for use with FilterGoroutines, that
+// matches goroutines whose user location (see (*G).UserCurrent) is inside
+// a function of the package with import path pkgPath. The full import path
+// must be specified, "http" does not match functions of "net/http".
+// If the location of the goroutine does not belong to any function its
+// current location is used instead.
+func InPackage(pkgPath string) func(*G) bool {
+	return func(g *G) bool {
+		if !readableG(g) || g.variable == nil {
+			return false
+		}
+		loc := g.UserCurrent()
+		if loc.Fn == nil {
+			loc = g.CurrentLoc
+		}
+		return loc.Fn != nil && funcPackagePath(loc.Fn.Name) == pkgPath
+	}
+}
+
+// GoroutinesInPackage returns the goroutines in gs that are executing
+// code of the package with import path pkgPath, see InPackage.
+func GoroutinesInPackage(gs []*G, pkgPath string) []*G {
+	return FilterGoroutines(gs, InPackage(pkgPath))
+}
+
+// funcPackagePath returns the import path of the package of the function
+// named name. Unlike packageName it ignores the type parameters of generic
+// functions, which can contain other package paths, and undoes the
+// escaping of dots in the last element of the import path done by the
+// linker (for example gopkg.in/yaml%2ev2.Marshal).
+func funcPackagePath(name string) string {
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	return strings.Replace(packageName(name), "%2e", ".", -1)
+}
+
+// Kinds of synchronization objects returned by (*G).BlockedOn.
+const (
+	BlockedOnChanSend = "chan send"
+	BlockedOnChanRecv = "chan recv"
+	BlockedOnSelect   = "select"
+	BlockedOnMutex    = "sync.Mutex"
+)
+
+// blockedOnMutexFrames maps functions of package sync that block on a
+// semaphore to the name of their receiver.
+var blockedOnMutexFrames = map[string]string{
+	"sync.(*Mutex).lockSlow": "m",
+	"sync.(*Mutex).Lock":     "m",
+	"sync.(*RWMutex).Lock":   "rw",
+	"sync.(*RWMutex).RLock":  "rw",
+}
+
+// blockedOnMutexMaxDepth is the maximum number of frames searched by
+// (*G).BlockedOn for one of blockedOnMutexFrames.
+const blockedOnMutexMaxDepth = 10
+
+// BlockedOn returns the address and the kind of the synchronization object
+// that the goroutine is blocked on. Kind is one of BlockedOnChanSend,
+// BlockedOnChanRecv, BlockedOnSelect and BlockedOnMutex. For goroutines
+// blocked on a select statement the address of the first channel is
+// returned.
+func (g *G) BlockedOn() (addr uint64, kind string, ok bool) {
+	if !readableG(g) || g.variable == nil || g.Status != Gwaiting {
+		return 0, "", false
+	}
+	switch g.WaitReason {
+	case "chan send", "chan send (nil chan)":
+		kind = BlockedOnChanSend
+	case "chan receive", "chan receive (nil chan)":
+		kind = BlockedOnChanRecv
+	case "select", "select (no cases)":
+		kind = BlockedOnSelect
+	case "semacquire", "sync.Mutex.Lock", "sync.RWMutex.Lock", "sync.RWMutex.RLock":
+		return g.blockedOnMutex()
+	default:
+		return 0, "", false
+	}
+
+	sudog := loadRuntimeStructField(g.variable, "waiting", "runtime.sudog")
+	if sudog == nil {
+		return 0, "", false
+	}
+	cvar := sudog.fieldVariable("c")
+	if cvar == nil || cvar.Unreadable != nil || cvar.Kind != reflect.Ptr {
+		return 0, "", false
+	}
+	addr = uint64(cvar.maybeDereference().Addr)
+	return addr, kind, addr != 0
+}
+
+// blockedOnMutex searches the stack of g for a function of package sync
+// blocking on a mutex and returns the address of its receiver.
+func (g *G) blockedOnMutex() (uint64, string, bool) {
+	frames, err := g.Stacktrace(blockedOnMutexMaxDepth, 0)
+	if err != nil {
+		return 0, "", false
+	}
+	for i := range frames {
+		if frames[i].Current.Fn == nil {
+			continue
+		}
+		recv, ok := blockedOnMutexFrames[frames[i].Current.Fn.Name]
+		if !ok {
+			continue
+		}
+		scope := FrameToScope(g.variable.bi, g.variable.mem, g, frames[i:]...)
+		v, err := scope.EvalVariable(recv, loadSingleValue)
+		if err != nil || v.Unreadable != nil || v.Kind != reflect.Ptr {
+			return 0, "", false
+		}
+		addr := uint64(v.maybeDereference().Addr)
+		return addr, BlockedOnMutex, addr != 0
+	}
+	return 0, "", false
+}
+
+// netPollFDMaxDepth is the maximum number of frames searched by
+// (*G).NetPollFD.
+const netPollFDMaxDepth = 15
+
+// netPollFDExpr returns the expression that evaluates to the file
+// descriptor the goroutine is waiting on in a frame of function fname, or
+// the empty string.
+func netPollFDExpr(fname string) string {
+	switch {
+	case fname == "runtime.netpollblock" || fname == "runtime.poll_runtime_pollWait" || fname == "internal/poll.runtime_pollWait":
+		return "pd.fd"
+	case strings.HasPrefix(fname, "internal/poll.(*FD)."):
+		return "fd.Sysfd"
+	}
+	return ""
+}
+
+// NetPollFD returns the file descriptor that the goroutine is waiting on
+// if it is parked in the network poller (its wait reason is "IO wait").
+// This is best effort: the runtime doesn't link the goroutine to the
+// pollDesc it is waiting on, the file descriptor is read from the
+// arguments of the runtime functions implementing the wait
+// (runtime.netpollblock and runtime.poll_runtime_pollWait) or, failing
+// that, from the receiver of the calling method of internal/poll.FD.
+// Therefore it depends on the internals of the runtime and on the
+// arguments of those functions being available in the debug info. If the
+// file descriptor can not be found ok is false.
+func (g *G) NetPollFD() (fd int, ok bool, err error) {
+	if !readableG(g) || g.variable == nil || g.Status != Gwaiting || g.WaitReason != "IO wait" {
+		return 0, false, nil
+	}
+	frames, err := g.Stacktrace(netPollFDMaxDepth, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := range frames {
+		if frames[i].Call.Fn == nil {
+			continue
+		}
+		expr := netPollFDExpr(frames[i].Call.Fn.Name)
+		if expr == "" {
+			continue
+		}
+		scope := FrameToScope(g.variable.bi, g.variable.mem, g, frames[i:]...)
+		v, err := scope.EvalExpression(expr, loadSingleValue)
+		if err != nil || v.Unreadable != nil || v.Value == nil || v.Value.Kind() != constant.Int {
+			// try the next frame, the argument could be unavailable.
+			continue
+		}
+		n, _ := constant.Int64Val(v.Value)
+		return int(n), true, nil
+	}
+	return 0, false, nil
+}
+
+// GroupByBlockedOn groups the goroutines in gs by the address of the
+// synchronization object they are blocked on (see (*G).BlockedOn).
+// Goroutines that aren't blocked on a synchronization object are omitted.
+func GroupByBlockedOn(gs []*G) map[uint64][]*G {
+	r := make(map[uint64][]*G)
+	for _, g := range gs {
+		if addr, _, ok := g.BlockedOn(); ok {
+			r[addr] = append(r[addr], g)
+		}
+	}
+	return r
+}
+
+// GoroutineGroup is a group of goroutines with the same stack, see
+// GroupByStack.
+type GoroutineGroup struct {
+	G          *G   // first goroutine of the group
+	Count      int  // number of goroutines in the group
+	Unreadable bool // the group contains the goroutines whose stack could not be read
+}
+
+// GroupByStack groups the goroutines in gs that have the same topmost
+// depth frames, like the goroutine profile of pprof does. Goroutines whose
+// stack can not be read are put in a separate group.
+// Groups are sorted by decreasing size, groups of the same size are in the
+// order of their first goroutine in gs.
+func GroupByStack(gs []*G, depth int) []GoroutineGroup {
+	groups := []GoroutineGroup{}
+	index := map[string]int{}
+	unreadable := -1
+
+	var key bytes.Buffer
+	for _, g := range gs {
+		var frames []Stackframe
+		err := errors.New("unreadable goroutine")
+		if readableG(g) && g.variable != nil {
+			frames, err = g.Stacktrace(depth, 0)
+		}
+		if err != nil {
+			if unreadable < 0 {
+				unreadable = len(groups)
+				groups = append(groups, GoroutineGroup{G: g, Unreadable: true})
+			}
+			groups[unreadable].Count++
+			continue
+		}
+		key.Reset()
+		for i := range frames {
+			fmt.Fprintf(&key, "%#x ", frames[i].Current.PC)
+		}
+		i, ok := index[key.String()]
+		if !ok {
+			i = len(groups)
+			index[key.String()] = i
+			groups = append(groups, GoroutineGroup{G: g})
+		}
+		groups[i].Count++
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+	return groups
+}
+
+// GoroutineDiff describes how the set of goroutines changed between two
+// stops of the target process, see DiffGoroutines.
+type GoroutineDiff struct {
+	Added         []int64 // goroutines that didn't exist at the first stop
+	Removed       []int64 // goroutines that exited since the first stop
+	StatusChanged []int64 // goroutines whose status changed
+}
+
+// DiffGoroutines compares the goroutines returned by GoroutinesInfo at two
+// different stops of the target process. Goroutines are matched by their
+// ID, since the runtime reuses the g structs of exited goroutines a
+// goroutine with the same ID but a different start function is considered
+// a different goroutine. Unreadable goroutines are ignored.
+// All returned IDs are sorted.
+func DiffGoroutines(old, new []*G) GoroutineDiff {
+	oldm := make(map[int64]*G, len(old))
+	for _, g := range old {
+		if readableG(g) {
+			oldm[g.ID64()] = g
+		}
+	}
+
+	var diff GoroutineDiff
+	seen := make(map[int64]bool, len(new))
+	for _, g := range new {
+		if !readableG(g) {
+			continue
+		}
+		id := g.ID64()
+		seen[id] = true
+		oldg := oldm[id]
+		switch {
+		case oldg == nil:
+			diff.Added = append(diff.Added, id)
+		case oldg.StartPC != g.StartPC:
+			diff.Removed = append(diff.Removed, id)
+			diff.Added = append(diff.Added, id)
+		case oldg.Status != g.Status:
+			diff.StatusChanged = append(diff.StatusChanged, id)
+		}
+	}
+	for id := range oldm {
+		if !seen[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	for _, ids := range [][]int64{diff.Added, diff.Removed, diff.StatusChanged} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return diff
+}
+
+// mutexUnlockFrames maps functions of package sync that release a mutex
+// to the name of their receiver.
+var mutexUnlockFrames = map[string]string{
+	"sync.(*Mutex).Unlock":     "m",
+	"sync.(*Mutex).unlockSlow": "m",
+}
+
+// ErrMutexHolderUnknown is returned by WhoHoldsMutex when the mutex is
+// locked but the goroutine holding it can not be determined.
+var ErrMutexHolderUnknown = errors.New("could not determine which goroutine holds the mutex")
+
+// WhoHoldsMutex makes a best effort attempt at determining which of the
+// goroutines in gs holds the sync.Mutex at addr. If the mutex isn't locked
+// nil is returned.
+//
+// The runtime does not record which goroutine holds a sync.Mutex, only
+// whether it is locked, therefore the holder can only be found if it is in
+// the process of unlocking the mutex (i.e. one of its frames is
+// sync.(*Mutex).Unlock with addr as receiver). In every other case a
+// locked mutex will result in ErrMutexHolderUnknown.
+func WhoHoldsMutex(addr uint64, gs []*G, mem MemoryReadWriter, bi *BinaryInfo) (*G, error) {
+	for _, g := range gs {
+		if !readableG(g) || g.variable == nil {
+			continue
+		}
+		frames, err := g.Stacktrace(blockedOnMutexMaxDepth, 0)
+		if err != nil {
+			continue
+		}
+		for i := range frames {
+			if frames[i].Current.Fn == nil {
+				continue
+			}
+			recv, ok := mutexUnlockFrames[frames[i].Current.Fn.Name]
+			if !ok {
+				continue
+			}
+			scope := FrameToScope(bi, mem, g, frames[i:]...)
+			v, err := scope.EvalVariable(recv, loadSingleValue)
+			if err != nil || v.Unreadable != nil || v.Kind != reflect.Ptr {
+				continue
+			}
+			if uint64(v.maybeDereference().Addr) == addr {
+				return g, nil
+			}
+		}
+	}
+
+	// The first field of sync.Mutex is its state, the lowest bit of which
+	// is set while the mutex is locked.
+	state, err := readUintRaw(mem, uintptr(addr), 4)
+	if err != nil {
+		return nil, err
+	}
+	if state&1 == 0 {
+		return nil, nil
+	}
+	return nil, ErrMutexHolderUnknown
+}
+
+// maxSelectCases is the maximum number of cases of a select statement.
+const maxSelectCases = 1 << 16
+
+// InSelect returns true if the goroutine is blocked in a select statement.
+func (g *G) InSelect() bool {
+	return readableG(g) && g.Status == Gwaiting && (g.WaitReason == "select" || g.WaitReason == "select (no cases)")
+}
+
+// SelectCases returns the number of channels that the select statement,
+// which the goroutine is blocked in, is waiting on. Cases whose channel is
+// nil and the default case are not counted.
+func (g *G) SelectCases() (int, error) {
+	if !g.InSelect() || g.variable == nil {
+		return 0, errors.New("goroutine is not blocked in a select statement")
+	}
+	// The runtime enqueues one sudog for each channel in the select
+	// statement, linked through the waitlink field and starting at g.waiting.
+	n := 0
+	sudog := loadRuntimeStructField(g.variable, "waiting", "runtime.sudog")
+	for sudog != nil {
+		n++
+		if n > maxSelectCases {
+			return 0, errors.New("corrupted sudog list")
+		}
+		sudog = loadRuntimeStructField(sudog, "waitlink", "runtime.sudog")
+	}
+	return n, nil
+}
+
+// LongBlockedGoroutines returns the goroutines in gs that have been blocked
+// for at least threshold, sorted by decreasing wait time. Now must be the
+// current value of runtime.nanotime in the target. Goroutines for which
+// the wait time is not known (see (*G).WaitDuration) are skipped.
+func LongBlockedGoroutines(gs []*G, now int64, threshold time.Duration) []*G {
+	r := FilterGoroutines(gs, func(g *G) bool {
+		if !readableG(g) {
+			return false
+		}
+		d, ok := g.WaitDuration(now)
+		return ok && d >= threshold
+	})
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].WaitSince < r[j].WaitSince
+	})
+	return r
+}
+
+// SortByCreation sorts gs in the order the goroutines were created, as far
+// as it can be determined: the runtime assigns goroutine IDs from a
+// monotonically increasing counter when a goroutine is created (or when
+// the g struct of a dead goroutine is reused for a new one), therefore
+// ordering live goroutines by ascending ID approximates their creation
+// order. The order is only approximate because each P reserves IDs from
+// the global counter in batches (see _GoidCacheBatch in the runtime) and
+// the runtime does not record any other creation sequence number.
+// Dead goroutines, whose ID may belong to a goroutine that no longer
+// exists, are moved after the live ones, followed by nil entries.
+func SortByCreation(gs []*G) {
+	rank := func(g *G) int {
+		switch {
+		case g == nil:
+			return 2
+		case g.Status == Gdead:
+			return 1
+		default:
+			return 0
+		}
+	}
+	sort.SliceStable(gs, func(i, j int) bool {
+		ri, rj := rank(gs[i]), rank(gs[j])
+		if ri != rj || ri == 2 {
+			return ri < rj
+		}
+		return gs[i].ID < gs[j].ID
+	})
+}
@@ -0,0 +1,105 @@
+package proc
+
+import "encoding/json"
+
+// gJSONVersion is the version of the JSON representation of G, it must be
+// incremented every time an incompatible change is made to gJSON.
+const gJSONVersion = 1
+
+// gJSON is the JSON representation of G.
+type gJSON struct {
+	Version      int           `json:"version"`
+	ID           int           `json:"id"`
+	Status       uint64        `json:"status"`
+	StatusString string        `json:"statusString"`
+	PC           uint64        `json:"pc"`
+	SP           uint64        `json:"sp"`
+	BP           uint64        `json:"bp"`
+	GoStatement  *locationJSON `json:"goStatementLoc,omitempty"`
+	Start        *locationJSON `json:"startLoc,omitempty"`
+	CurrentLoc   locationJSON  `json:"currentLoc"`
+	WaitReason   string        `json:"waitReason,omitempty"`
+	SystemStack  bool          `json:"systemStack"`
+	SignalStack  bool          `json:"signalStack,omitempty"`
+	Unreadable   string        `json:"unreadable,omitempty"`
+}
+
+type locationJSON struct {
+	PC       uint64 `json:"pc"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function,omitempty"`
+}
+
+func newLocationJSON(loc Location) locationJSON {
+	r := locationJSON{PC: loc.PC, File: loc.File, Line: loc.Line}
+	if loc.Fn != nil {
+		r.Function = loc.Fn.Name
+	}
+	return r
+}
+
+// MarshalJSON implements json.Marshaler. The JSON representation of G
+// contains a version field which is incremented every time an
+// incompatible change is made to it. Goroutines that could not be read
+// are marshaled with an "unreadable" field containing the error.
+func (g *G) MarshalJSON() ([]byte, error) {
+	r := gJSON{
+		Version:      gJSONVersion,
+		ID:           g.ID,
+		Status:       g.Status,
+		StatusString: goroutineStatusString(g.Status),
+		PC:           g.PC,
+		SP:           g.SP,
+		BP:           g.BP,
+		CurrentLoc:   newLocationJSON(g.CurrentLoc),
+		WaitReason:   g.WaitReason,
+		SystemStack:  g.SystemStack,
+		SignalStack:  g.SignalStack,
+	}
+	if g.variable != nil {
+		gostmt := newLocationJSON(g.Go())
+		start := newLocationJSON(g.StartLoc())
+		r.GoStatement, r.Start = &gostmt, &start
+	}
+	if g.Unreadable != nil {
+		r.Unreadable = g.Unreadable.Error()
+	}
+	return json.Marshal(&r)
+}
+
+// goroutineStatusString returns a description of a goroutine status.
+func goroutineStatusString(status uint64) string {
+	const gscan = 0x1000 // the goroutine's stack is being scanned
+	var scan string
+	if status&gscan != 0 {
+		scan = " (scan)"
+		status &^= gscan
+	}
+	var s string
+	switch status {
+	case Gidle:
+		s = "idle"
+	case Grunnable:
+		s = "runnable"
+	case Grunning:
+		s = "running"
+	case Gsyscall:
+		s = "syscall"
+	case Gwaiting:
+		s = "waiting"
+	case GmoribundUnused:
+		s = "moribund"
+	case Gdead:
+		s = "dead"
+	case Genqueue:
+		s = "enqueue"
+	case Gcopystack:
+		s = "copystack"
+	case Gpreempted:
+		s = "preempted"
+	default:
+		s = "unknown"
+	}
+	return s + scan
+}
@@ -12,6 +12,7 @@ type ARM64Registers struct {
 	Regs     *ARM64PtraceRegs //general-purpose registers
 	Fpregs   []proc.Register  //Formatted floating point registers
 	Fpregset []byte           //holding all floating point register values
+	TPIDR    uint64           //thread pointer register TPIDR_EL0
 }
 
 // ARM64PtraceRegs is the struct used by the linux kernel to return the
@@ -89,11 +90,13 @@ func (r *ARM64Registers) BP() uint64 {
 
 // TLS returns the address of the thread local storage memory segment.
 func (r *ARM64Registers) TLS() uint64 {
-	return 0
+	return r.TPIDR
 }
 
 // GAddr returns the address of the G variable if it is known, 0 and false
 // otherwise.
+// The G variable is kept in X28, which is only valid while executing Go
+// code.
 func (r *ARM64Registers) GAddr() (uint64, bool) {
 	return r.Regs.Regs[28], true
 }
@@ -114,6 +117,7 @@ func (r *ARM64Registers) Copy() proc.Registers {
 	var rr ARM64Registers
 	rr.Regs = &ARM64PtraceRegs{}
 	*(rr.Regs) = *(r.Regs)
+	rr.TPIDR = r.TPIDR
 	if r.Fpregs != nil {
 		rr.Fpregs = make([]proc.Register, len(r.Fpregs))
 		copy(rr.Fpregs, r.Fpregs)
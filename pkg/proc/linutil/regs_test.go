@@ -58,3 +58,14 @@ func TestAMD64Get(t *testing.T) {
 		t.Fatalf("expected %#v, got %#v\n", val, rax)
 	}
 }
+
+func TestARM64TLS(t *testing.T) {
+	regs := ARM64Registers{Regs: &ARM64PtraceRegs{}, TPIDR: 0x7f0000001000}
+	// the thread pointer is read separately from the general purpose registers
+	if tls := regs.TLS(); tls != 0x7f0000001000 {
+		t.Fatalf("wrong TLS %#x", tls)
+	}
+	if tls := regs.Copy().TLS(); tls != 0x7f0000001000 {
+		t.Fatalf("wrong TLS after Copy %#x", tls)
+	}
+}
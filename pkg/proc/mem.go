@@ -3,7 +3,9 @@ package proc
 import (
 	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
 	"github.com/go-delve/delve/pkg/dwarf/op"
 )
 
@@ -31,10 +33,11 @@ type memCache struct {
 	cacheAddr uintptr
 	cache     []byte
 	mem       MemoryReadWriter
+	bufp      *[]byte // buffer of readBufPool backing cache, see releaseMemory
 }
 
 func (m *memCache) contains(addr uintptr, size int) bool {
-	return addr >= m.cacheAddr && addr <= (m.cacheAddr+uintptr(len(m.cache)-size))
+	return size <= len(m.cache) && addr >= m.cacheAddr && addr <= (m.cacheAddr+uintptr(len(m.cache)-size))
 }
 
 func (m *memCache) ReadMemory(data []byte, addr uintptr) (n int, err error) {
@@ -54,7 +57,28 @@ func (m *memCache) ReadMemory(data []byte, addr uintptr) (n int, err error) {
 }
 
 func (m *memCache) WriteMemory(addr uintptr, data []byte) (written int, err error) {
-	return m.mem.WriteMemory(addr, data)
+	written, err = m.mem.WriteMemory(addr, data)
+	if m.loaded {
+		updateCache(m.cache, m.cacheAddr, data[:written], addr)
+	}
+	return written, err
+}
+
+// updateCache copies the bytes of data, which have been written at addr,
+// that fall inside the region of memory cached by buf at cacheAddr so that
+// later reads through the cache see them.
+func updateCache(buf []byte, cacheAddr uintptr, data []byte, addr uintptr) {
+	start, end := addr, addr+uintptr(len(data))
+	if start < cacheAddr {
+		start = cacheAddr
+	}
+	if cacheEnd := cacheAddr + uintptr(len(buf)); end > cacheEnd {
+		end = cacheEnd
+	}
+	if start >= end {
+		return
+	}
+	copy(buf[start-cacheAddr:end-cacheAddr], data[start-addr:])
 }
 
 func cacheMemory(mem MemoryReadWriter, addr uintptr, size int) MemoryReadWriter {
@@ -71,8 +95,188 @@ func cacheMemory(mem MemoryReadWriter, addr uintptr, size int) MemoryReadWriter
 		}
 	case *compositeMemory:
 		return mem
+	case *uncachedMemory:
+		return mem
+	case *partialMemory:
+		return mem
+	}
+	return &memCache{false, addr, make([]byte, size), mem, nil}
+}
+
+// readBufPool holds the buffers used for the temporary memory reads done
+// while reading goroutines, to reduce the garbage created when walking
+// runtime.allgs.
+var readBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 8)
+		return &buf
+	},
+}
+
+// getReadBuf returns a buffer of size bytes from readBufPool, it must be
+// returned with putReadBuf once it is no longer used.
+func getReadBuf(size int) *[]byte {
+	bufp := readBufPool.Get().(*[]byte)
+	if cap(*bufp) < size {
+		*bufp = make([]byte, size)
+	}
+	*bufp = (*bufp)[:size]
+	return bufp
+}
+
+// putReadBuf returns a buffer obtained from getReadBuf to readBufPool.
+func putReadBuf(bufp *[]byte) {
+	readBufPool.Put(bufp)
+}
+
+// preloadMemory reads size bytes at addr with a single ReadMemory call and
+// returns a cache of them. If the read fails the region is read again one
+// word at a time (see readPartial) and a *partialMemory is returned, so
+// that the caller can still read the parts of the region that are
+// available. If nothing can be read a memory that cacheMemory will not
+// cache is returned.
+func preloadMemory(mem MemoryReadWriter, addr uintptr, size int) MemoryReadWriter {
+	if !cacheEnabled || size <= 0 {
+		return mem
+	}
+	if cacheMem, ok := mem.(*memCache); ok && cacheMem.loaded && cacheMem.contains(addr, size) {
+		return mem
+	}
+	// The buffer is kept by the returned cache until releaseMemory is
+	// called on it, it is returned to the pool immediately if the read
+	// fails.
+	bufp := getReadBuf(size)
+	if _, err := mem.ReadMemory(*bufp, addr); err != nil {
+		holes, err := readPartial(mem, *bufp, addr, partialReadWordSize)
+		if err != nil {
+			putReadBuf(bufp)
+			return &uncachedMemory{mem}
+		}
+		return &partialMemory{addr: addr, buf: *bufp, holes: holes, mem: mem, bufp: bufp}
+	}
+	return &memCache{true, addr, *bufp, mem, bufp}
+}
+
+// releaseMemory returns the buffer of a cache created by preloadMemory to
+// readBufPool, after that reads go to the underlying memory. It must be
+// called once the cache is no longer needed, when the target resumes
+// execution, otherwise the buffer is left to the garbage collector.
+func releaseMemory(mem MemoryReadWriter) {
+	switch m := mem.(type) {
+	case *memCache:
+		if m.bufp != nil {
+			putReadBuf(m.bufp)
+			m.bufp, m.cache, m.loaded = nil, nil, false
+		}
+	case *partialMemory:
+		if m.bufp != nil {
+			putReadBuf(m.bufp)
+			m.bufp, m.buf, m.holes = nil, nil, nil
+		}
+	}
+}
+
+// partialReadWordSize is the size of the reads done by preloadMemory after
+// a failed bulk read.
+const partialReadWordSize = 8
+
+// memHole is a range of addresses that could not be read.
+type memHole struct {
+	addr uintptr
+	size int
+	err  error // error returned reading the first word of the range
+}
+
+func (h *memHole) overlaps(addr uintptr, size int) bool {
+	return addr < h.addr+uintptr(h.size) && h.addr < addr+uintptr(size)
+}
+
+// readPartial reads len(buf) bytes at addr, one word of wordSize bytes at a
+// time, and returns the ranges of addresses that could not be read, merging
+// adjacent words. The bytes of buf corresponding to those ranges are
+// zeroed. An error is returned if no word could be read.
+func readPartial(mem MemoryReader, buf []byte, addr uintptr, wordSize int) ([]memHole, error) {
+	var holes []memHole
+	for off := 0; off < len(buf); off += wordSize {
+		end := off + wordSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		_, err := mem.ReadMemory(buf[off:end], addr+uintptr(off))
+		if err == nil {
+			continue
+		}
+		for i := off; i < end; i++ {
+			buf[i] = 0
+		}
+		if n := len(holes); n > 0 && holes[n-1].addr+uintptr(holes[n-1].size) == addr+uintptr(off) {
+			holes[n-1].size += end - off
+			continue
+		}
+		holes = append(holes, memHole{addr: addr + uintptr(off), size: end - off, err: err})
+	}
+	if len(holes) == 1 && holes[0].size == len(buf) {
+		return holes, holes[0].err
+	}
+	return holes, nil
+}
+
+// partialMemory is a cache of a region of memory that could only be read
+// in part, reads that overlap one of the holes of the region fail.
+type partialMemory struct {
+	addr  uintptr
+	buf   []byte
+	holes []memHole
+	mem   MemoryReadWriter
+	bufp  *[]byte // buffer of readBufPool backing buf, see releaseMemory
+}
+
+func (m *partialMemory) ReadMemory(data []byte, addr uintptr) (int, error) {
+	if addr < m.addr || addr+uintptr(len(data)) > m.addr+uintptr(len(m.buf)) {
+		return m.mem.ReadMemory(data, addr)
+	}
+	for i := range m.holes {
+		if m.holes[i].overlaps(addr, len(data)) {
+			return 0, fmt.Errorf("could not read %#x (%d bytes): %v", addr, len(data), m.holes[i].err)
+		}
 	}
-	return &memCache{false, addr, make([]byte, size), mem}
+	copy(data, m.buf[addr-m.addr:])
+	return len(data), nil
+}
+
+func (m *partialMemory) WriteMemory(addr uintptr, data []byte) (int, error) {
+	written, err := m.mem.WriteMemory(addr, data)
+	updateCache(m.buf, m.addr, data[:written], addr)
+	return written, err
+}
+
+// unreadableFields returns the names of the fields of typ, a struct stored
+// at the start of the region cached by m, that overlap one of its holes.
+func (m *partialMemory) unreadableFields(typ *godwarf.StructType) []string {
+	var r []string
+	for _, field := range typ.Field {
+		addr := m.addr + uintptr(field.ByteOffset)
+		for i := range m.holes {
+			if m.holes[i].overlaps(addr, int(field.Type.Size())) {
+				r = append(r, field.Name)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// uncachedMemory is a MemoryReadWriter that cacheMemory will not cache.
+type uncachedMemory struct {
+	mem MemoryReadWriter
+}
+
+func (m *uncachedMemory) ReadMemory(data []byte, addr uintptr) (int, error) {
+	return m.mem.ReadMemory(data, addr)
+}
+
+func (m *uncachedMemory) WriteMemory(addr uintptr, data []byte) (int, error) {
+	return m.mem.WriteMemory(addr, data)
 }
 
 // fakeAddress used by extractVarInfoFromEntry for variables that do not
@@ -140,6 +344,8 @@ func DereferenceMemory(mem MemoryReadWriter) MemoryReadWriter {
 	switch mem := mem.(type) {
 	case *compositeMemory:
 		return mem.realmem
+	case *uncachedMemory:
+		return mem.mem
 	}
 	return mem
 }
@@ -15,6 +15,9 @@ import (
 const (
 	AARCH64_GREGS_SIZE  = 34 * 8
 	AARCH64_FPREGS_SIZE = 32*16 + 8
+
+	// _NT_ARM_TLS is the register set containing TPIDR_EL0.
+	_NT_ARM_TLS = 0x401
 )
 
 func ptraceGetGRegs(pid int, regs *linutil.ARM64PtraceRegs) (err error) {
@@ -35,6 +38,17 @@ func ptraceSetGRegs(pid int, regs *linutil.ARM64PtraceRegs) (err error) {
 	return
 }
 
+// ptraceGetTLS returns the value of the TPIDR_EL0 register of the
+// specified thread.
+func ptraceGetTLS(pid int) (tls uint64, err error) {
+	iov := sys.Iovec{Base: (*byte)(unsafe.Pointer(&tls)), Len: uint64(unsafe.Sizeof(tls))}
+	_, _, err = syscall.Syscall6(syscall.SYS_PTRACE, sys.PTRACE_GETREGSET, uintptr(pid), _NT_ARM_TLS, uintptr(unsafe.Pointer(&iov)), 0, 0)
+	if err == syscall.Errno(0) {
+		err = nil
+	}
+	return
+}
+
 // PtraceGetFpRegset returns floating point registers of the specified thread
 // using PTRACE.
 func PtraceGetFpRegset(tid int) (fpregset []byte, err error) {
@@ -86,13 +100,21 @@ func (thread *Thread) SetDX(dx uint64) (err error) {
 func registers(thread *Thread, floatingPoint bool) (proc.Registers, error) {
 	var (
 		regs linutil.ARM64PtraceRegs
+		tls  uint64
 		err  error
 	)
-	thread.dbp.execPtraceFunc(func() { err = ptraceGetGRegs(thread.ID, &regs) })
+	thread.dbp.execPtraceFunc(func() {
+		err = ptraceGetGRegs(thread.ID, &regs)
+		if err == nil {
+			// TPIDR_EL0 is only needed to read g outside of Go code, see
+			// proc.getGVariable, failing to read it isn't fatal.
+			tls, _ = ptraceGetTLS(thread.ID)
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	r := &linutil.ARM64Registers{&regs, nil, nil}
+	r := &linutil.ARM64Registers{Regs: &regs, TPIDR: tls}
 	if floatingPoint {
 		r.Fpregs, r.Fpregset, err = thread.fpRegisters()
 		if err != nil {
@@ -2,12 +2,15 @@ package proc
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/token"
 	"path/filepath"
 	"strconv"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
 )
 
 // ErrNotExecutable is returned after attempting to execute a non-executable file
@@ -546,6 +549,14 @@ func StepInstruction(dbp *Target) (err error) {
 // while scanning for all available goroutines, or -1 if there was an error
 // or if the index already reached the last possible value.
 func GoroutinesInfo(dbp *Target, start, count int) ([]*G, int, error) {
+	return GoroutinesInfoContext(context.Background(), dbp, start, count)
+}
+
+// GoroutinesInfoContext is like GoroutinesInfo but stops scanning the
+// list of goroutines when ctx is canceled. In that case the goroutines
+// found so far are returned, together with the index to continue the scan
+// from and ctx.Err().
+func GoroutinesInfoContext(ctx context.Context, dbp *Target, start, count int) ([]*G, int, error) {
 	if _, err := dbp.Valid(); err != nil {
 		return nil, -1, err
 	}
@@ -572,23 +583,21 @@ func GoroutinesInfo(dbp *Target, start, count int) ([]*G, int, error) {
 		}
 	}
 
-	allgptr, allglen, err := dbp.gcache.getRuntimeAllg(dbp.BinInfo(), dbp.CurrentThread())
+	allgs, err := dbp.gcache.allgs(dbp.BinInfo(), dbp.CurrentThread())
 	if err != nil {
 		return nil, -1, err
 	}
 
-	for i := uint64(start); i < allglen; i++ {
+	for i := uint64(start); i < allgs.allglen; i++ {
 		if count != 0 && len(allg) >= count {
 			return allg, int(i), nil
 		}
-		gvar, err := newGVariable(dbp.CurrentThread(), uintptr(allgptr+(i*uint64(dbp.BinInfo().Arch.PtrSize()))), true)
-		if err != nil {
-			allg = append(allg, &G{Unreadable: err})
-			continue
+		if err := ctx.Err(); err != nil {
+			return allg, int(i), err
 		}
-		g, err := gvar.parseG()
+		g, err := allgs.g(i)
 		if err != nil {
-			allg = append(allg, &G{Unreadable: err})
+			allg = append(allg, g)
 			continue
 		}
 		if thg, allocated := threadg[g.ID]; allocated {
@@ -600,6 +609,7 @@ func GoroutinesInfo(dbp *Target, start, count int) ([]*G, int, error) {
 			// Prefer actual thread location information.
 			g.CurrentLoc = *loc
 			g.SystemStack = thg.SystemStack
+			g.SignalStack = thg.SignalStack
 		}
 		if g.Status != Gdead {
 			allg = append(allg, g)
@@ -608,11 +618,183 @@ func GoroutinesInfo(dbp *Target, start, count int) ([]*G, int, error) {
 	}
 	if start == 0 {
 		dbp.gcache.allGCache = allg
+		dbp.gcache.indexComplete = true
 	}
 
 	return allg, -1, nil
 }
 
+// CountGoroutinesByStatus returns the number of goroutines in
+// runtime.allgs for each goroutine status (Gidle, Grunnable, ...),
+// including dead goroutines. Only the status field of each goroutine is
+// read, making this much cheaper than GoroutinesInfo on programs with many
+// goroutines.
+func CountGoroutinesByStatus(mem MemoryReadWriter, bi *BinaryInfo) (map[uint64]int, error) {
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return nil, err
+	}
+	return allgs.countByStatus()
+}
+
+func (it *allgsIterator) countByStatus() (map[uint64]int, error) {
+	if it.statusErr != nil {
+		return nil, it.statusErr
+	}
+	r := make(map[uint64]int)
+	for i := uint64(0); i < it.allglen; i++ {
+		status, ok, err := it.status(i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			r[status]++
+		}
+	}
+	return r, nil
+}
+
+// gStatusField returns the offset and size of the atomicstatus field of
+// runtime.g.
+func gStatusField(bi *BinaryInfo) (off uint64, size int64, err error) {
+	typ, err := bi.findRuntimeGType()
+	if err != nil {
+		return 0, 0, err
+	}
+	styp, ok := resolveTypedef(typ).(*godwarf.StructType)
+	if !ok {
+		return 0, 0, fmt.Errorf("wrong type for runtime.g: %s", typ.String())
+	}
+	statusField := structField(styp, "atomicstatus")
+	for _, alt := range gFieldAlternates["atomicstatus"] {
+		if statusField != nil {
+			break
+		}
+		statusField = structField(styp, alt)
+	}
+	if statusField == nil {
+		return 0, 0, errors.New("could not find atomicstatus field of runtime.g")
+	}
+	off = uint64(statusField.ByteOffset)
+	if fstyp, isstruct := resolveTypedef(statusField.Type).(*godwarf.StructType); isstruct {
+		// atomic.Uint32 and similar wrapper types, see gField.
+		value := structField(fstyp, "value")
+		if value == nil {
+			return 0, 0, fmt.Errorf("could not find value of atomicstatus field of runtime.g (%s)", fstyp.String())
+		}
+		off += uint64(value.ByteOffset)
+		statusField = value
+	}
+	size = statusField.Type.Size()
+	if size <= 0 || size > 8 {
+		return 0, 0, fmt.Errorf("wrong size for atomicstatus field of runtime.g: %d", size)
+	}
+	return off, size, nil
+}
+
+// structField returns the field of typ called name, or nil.
+func structField(typ *godwarf.StructType, name string) *godwarf.StructField {
+	for _, field := range typ.Field {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// TotalGoroutines returns the number of entries of runtime.allgs (total)
+// and how many of them are not dead goroutines (live). Dead goroutines are
+// kept in runtime.allgs so that they can be reused.
+// If the status of the goroutines can not be read total is returned along
+// with the error.
+func TotalGoroutines(mem MemoryReadWriter, bi *BinaryInfo) (live int, total int, err error) {
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return 0, 0, err
+	}
+	counts, err := allgs.countByStatus()
+	if err != nil {
+		return 0, int(allgs.allglen), err
+	}
+	for status, n := range counts {
+		if status != Gdead {
+			live += n
+		}
+	}
+	return live, int(allgs.allglen), nil
+}
+
+// IterateGoroutines calls fn for each goroutine in runtime.allgs, except
+// dead goroutines, until fn returns false. Goroutines are parsed one at a
+// time, as they are passed to fn. Goroutines that could not be read are
+// passed to fn with the Unreadable field set.
+// Unlike GoroutinesInfo the Thread field of the goroutines is never set.
+func IterateGoroutines(mem MemoryReadWriter, bi *BinaryInfo, fn func(*G) bool) error {
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return err
+	}
+	allgs.each(0, allgs.allglen, true, fn)
+	return nil
+}
+
+// GoroutinesFromAllgs reads the goroutines in runtime.allgs, starting at
+// index start and reading at most count entries (all remaining entries if
+// count is 0). Dead goroutines are skipped, so fewer than count goroutines
+// can be returned even when more entries are available, the next page
+// starts at start+count.
+// Unlike GoroutinesInfo no thread is needed which makes this suitable to
+// read goroutines from core files. Goroutines that could not be read are
+// returned with the Unreadable field set.
+func GoroutinesFromAllgs(mem MemoryReadWriter, bi *BinaryInfo, start, count int) ([]*G, error) {
+	if start < 0 || count < 0 {
+		return nil, fmt.Errorf("invalid range %d %d", start, count)
+	}
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return nil, err
+	}
+	end := allgs.allglen
+	if count != 0 {
+		end = uint64(start + count)
+	}
+	return allgs.collect(uint64(start), end, true), nil
+}
+
+// LiveGoroutines returns the goroutines in runtime.allgs that are not dead.
+// The status of each goroutine is read before parsing it, so that skipping
+// a dead goroutine only costs two small reads.
+// Goroutines that could not be read are returned with the Unreadable field
+// set. See AllGoroutines to also read dead goroutines.
+func LiveGoroutines(mem MemoryReadWriter, bi *BinaryInfo) ([]*G, error) {
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return nil, err
+	}
+	return allgs.collect(0, allgs.allglen, true), nil
+}
+
+// AllGoroutines returns all goroutines in runtime.allgs, including dead
+// goroutines, which the runtime keeps in runtime.allgs so that they can be
+// reused.
+func AllGoroutines(mem MemoryReadWriter, bi *BinaryInfo) ([]*G, error) {
+	allgs, err := newAllgsIterator(mem, bi)
+	if err != nil {
+		return nil, err
+	}
+	return allgs.collect(0, allgs.allglen, false), nil
+}
+
+// collect returns the goroutines at indices [start, end) of runtime.allgs.
+func (it *allgsIterator) collect(start, end uint64, skipDead bool) []*G {
+	var r []*G
+	it.each(start, end, skipDead, func(g *G) bool {
+		r = append(r, g)
+		return true
+	})
+	return r
+}
+
 // FindGoroutine returns a G struct representing the goroutine
 // specified by `gid`.
 func FindGoroutine(dbp *Target, gid int) (*G, error) {
@@ -652,27 +834,15 @@ func FindGoroutine(dbp *Target, gid int) (*G, error) {
 		}
 	}
 
-	if g := dbp.gcache.partialGCache[gid]; g != nil {
-		return g, nil
+	g, err := dbp.gcache.GoroutineByID(dbp.BinInfo(), dbp.CurrentThread(), int64(gid))
+	if err != nil {
+		return nil, err
 	}
-
-	const goroutinesInfoLimit = 10
-	nextg := 0
-	for nextg >= 0 {
-		var gs []*G
-		var err error
-		gs, nextg, err = GoroutinesInfo(dbp, nextg, goroutinesInfoLimit)
-		if err != nil {
-			return nil, err
-		}
-		for i := range gs {
-			if gs[i].ID == gid {
-				if gs[i].Unreadable != nil {
-					return nil, gs[i].Unreadable
-				}
-				return gs[i], nil
-			}
+	if g != nil {
+		if g.Status == Gdead {
+			return nil, ErrGoroutineDead{ID: gid}
 		}
+		return g, nil
 	}
 
 	return nil, fmt.Errorf("Unknown goroutine %d", gid)
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/go-delve/delve/pkg/proc"
 	"github.com/go-delve/delve/pkg/proc/native"
 	protest "github.com/go-delve/delve/pkg/proc/test"
 )
@@ -21,6 +22,53 @@ func TestLoadingExternalDebugInfo(t *testing.T) {
 	p.Detach(true)
 }
 
+func TestLoadingExternalDebugInfoDebugLink(t *testing.T) {
+	fixture := protest.BuildFixture("locationsprog", 0)
+	defer os.Remove(fixture.Path)
+	name := filepath.Base(fixture.Path)
+	debugName := name + ".dbg"
+	defer os.Remove(fixture.Path + ".dbg")
+	for _, args := range [][]string{
+		{"objcopy", "--only-keep-debug", name, debugName},
+		{"strip", "--strip-debug", "--strip-unneeded", name},
+		{"objcopy", "--add-gnu-debuglink=" + debugName, name},
+	} {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = filepath.Dir(fixture.Path)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, out)
+		}
+	}
+
+	bi := proc.NewBinaryInfo("linux", "amd64")
+	if err := bi.LoadBinaryInfo(fixture.Path, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bi.LookupFunc["main.main"] == nil {
+		t.Fatal("could not find main.main in separate debug file")
+	}
+	objs := bi.LoadedObjectFiles()
+	if len(objs) != 1 {
+		t.Fatalf("wrong number of object files %d", len(objs))
+	}
+	if objs[0].Path != fixture.Path || !objs[0].HasDebugInfo || objs[0].Debuginfod || objs[0].LoadError != nil {
+		t.Errorf("wrong object file %#v", objs[0])
+	}
+	if objs[0].DebugInfoPath != fixture.Path+".dbg" {
+		t.Errorf("wrong debug info path %q", objs[0].DebugInfoPath)
+	}
+	bi.Close()
+
+	// Without the file named by .gnu_debuglink the executable has no debug
+	// info at all.
+	os.Remove(fixture.Path + ".dbg")
+	bi = proc.NewBinaryInfo("linux", "amd64")
+	if err := bi.LoadBinaryInfo(fixture.Path, 0, nil); err != proc.ErrNoDebugInfoFound {
+		t.Errorf("expected %v, got %v", proc.ErrNoDebugInfoFound, err)
+	}
+	bi.Close()
+}
+
 func stripAndCopyDebugInfo(f protest.Fixture, t *testing.T) {
 	name := filepath.Base(f.Path)
 	// Copy the debug information to an external file.
@@ -2,6 +2,7 @@ package proc_test
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -21,6 +22,7 @@ import (
 	"time"
 
 	"github.com/go-delve/delve/pkg/dwarf/frame"
+	"github.com/go-delve/delve/pkg/dwarf/op"
 	"github.com/go-delve/delve/pkg/goversion"
 	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/pkg/proc"
@@ -1843,6 +1845,338 @@ func TestPanicBreakpoint(t *testing.T) {
 	})
 }
 
+func TestGoroutinePanics(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("panic", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		bp := p.CurrentThread().Breakpoint()
+		if bp.Breakpoint == nil || bp.Name != proc.UnrecoveredPanic {
+			t.Fatalf("not on unrecovered-panic breakpoint: %v", bp)
+		}
+		panics, err := p.SelectedGoroutine().Panics()
+		assertNoError(err, t, "Panics()")
+		if len(panics) != 1 {
+			t.Fatalf("wrong number of panics %d", len(panics))
+		}
+		if panics[0].Unreadable != nil {
+			t.Fatalf("unreadable panic: %v", panics[0].Unreadable)
+		}
+		arg := panics[0].Arg
+		if arg == nil || len(arg.Children) != 1 || arg.Children[0].Kind != reflect.String || constant.StringVal(arg.Children[0].Value) != "BOOM!" {
+			t.Fatalf("wrong panic argument: %v", arg)
+		}
+		if panics[0].Recovered || panics[0].Aborted {
+			t.Fatalf("wrong panic state recovered=%v aborted=%v", panics[0].Recovered, panics[0].Aborted)
+		}
+	})
+}
+
+func TestGoroutineTimerWait(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("timerwait", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+		found := false
+		for _, g := range gs {
+			when, ok, err := g.TimerWait()
+			assertNoError(err, t, fmt.Sprintf("TimerWait() for goroutine %d", g.ID))
+			if g.StartLoc().Fn == nil || g.StartLoc().Fn.Name != "main.sleeper" {
+				continue
+			}
+			found = true
+			if !ok || when <= 0 {
+				t.Errorf("sleeping goroutine not waiting on a timer %d %v", when, ok)
+			}
+			if remaining, _, _ := g.TimerRemaining(when - int64(time.Minute)); remaining != time.Minute {
+				t.Errorf("wrong remaining time %v", remaining)
+			}
+		}
+		if !found {
+			t.Fatal("could not find sleeping goroutine")
+		}
+
+		when, ok, err := p.SelectedGoroutine().TimerWait()
+		if err != nil || ok {
+			t.Errorf("running goroutine waiting on a timer %d %v %v", when, ok, err)
+		}
+	})
+}
+
+func TestGoroutineFrameAt(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.helloworld")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g := p.SelectedGoroutine()
+		frames, err := g.Stacktrace(10, 0)
+		assertNoError(err, t, "Stacktrace()")
+
+		frame, err := g.FrameAt(1)
+		assertNoError(err, t, "FrameAt(1)")
+		if frame.Call.Fn == nil || frame.Call.Fn.Name != "main.main" {
+			t.Fatalf("wrong frame 1 %v", frame.Call)
+		}
+		if frame.Regs.CFA != frames[1].Regs.CFA || frame.Regs.CFA == 0 {
+			t.Errorf("wrong CFA %#x (expected %#x)", frame.Regs.CFA, frames[1].Regs.CFA)
+		}
+
+		if _, err := g.FrameAt(len(frames)); err == nil {
+			t.Errorf("no error for frame %d", len(frames))
+		}
+	})
+}
+
+func TestGoroutineRecoverable(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("panic", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		recoverable, err := p.SelectedGoroutine().Recoverable()
+		assertNoError(err, t, "Recoverable()")
+		if recoverable {
+			t.Fatal("unrecovered panic reported as recoverable")
+		}
+	})
+
+	withTestProcess("issue594", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "runtime.gorecover")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		recoverable, err := p.SelectedGoroutine().Recoverable()
+		assertNoError(err, t, "Recoverable()")
+		if !recoverable {
+			t.Fatal("panic being recovered reported as unrecoverable")
+		}
+
+		assertNoError(proc.Continue(p), t, "Continue()")
+		recoverable, err = p.SelectedGoroutine().Recoverable()
+		assertNoError(err, t, "Recoverable()")
+		if recoverable {
+			t.Fatal("goroutine reported as recoverable after the panic was recovered")
+		}
+	})
+}
+
+func TestGoroutineSchedInfo(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.main")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		mID, pID, err := p.SelectedGoroutine().SchedInfo()
+		assertNoError(err, t, "SchedInfo()")
+		t.Logf("m %d p %d", mID, pID)
+		if mID < 0 || pID < 0 {
+			t.Fatalf("running goroutine without M or P: %d %d", mID, pID)
+		}
+		status, ok, err := p.SelectedGoroutine().ProcessorStatus()
+		assertNoError(err, t, "ProcessorStatus()")
+		if !ok || status != proc.Prunning {
+			t.Fatalf("wrong P status %d %v", status, ok)
+		}
+	})
+}
+
+func TestGoroutineIsMain(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.testgoroutine")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		if p.SelectedGoroutine().IsMain(p.BinInfo()) {
+			t.Errorf("goroutine %d running main.testgoroutine reported as main", p.SelectedGoroutine().ID)
+		}
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+		var mains []int
+		for _, g := range gs {
+			if g.IsMain(p.BinInfo()) {
+				mains = append(mains, g.ID)
+			}
+		}
+		if len(mains) != 1 || mains[0] != 1 {
+			t.Errorf("wrong main goroutines %v", mains)
+		}
+	})
+}
+
+func TestGoroutineCachedStack(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.helloworld")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g := p.SelectedGoroutine()
+		frames, err := g.Stacktrace(10, 0)
+		assertNoError(err, t, "Stacktrace()")
+		for _, depth := range []int{1, 10, 5, 50} {
+			cached, err := g.CachedStack(depth)
+			assertNoError(err, t, "CachedStack()")
+			n := depth + 1
+			if n > len(frames) {
+				n = len(frames)
+			}
+			if len(cached) != n {
+				t.Fatalf("depth %d: wrong number of frames %d, expected %d", depth, len(cached), n)
+			}
+			for i := range cached {
+				if cached[i].Call.PC != frames[i].Call.PC {
+					t.Fatalf("depth %d: frame %d mismatch %#x %#x", depth, i, cached[i].Call.PC, frames[i].Call.PC)
+				}
+			}
+		}
+		if loc := g.UserCurrent(); loc.Fn == nil || loc.Fn.Name != "main.helloworld" {
+			t.Fatalf("wrong UserCurrent: %v", loc)
+		}
+	})
+}
+
+func TestIsSystemGoroutine(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.main")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		if p.SelectedGoroutine().IsSystemGoroutine() {
+			t.Fatal("main goroutine reported as a system goroutine")
+		}
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+		found := false
+		for _, g := range gs {
+			if g.IsSystemGoroutine() {
+				t.Logf("system goroutine %d %s", g.ID, g.StartLoc().Fn.Name)
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("no system goroutines found")
+		}
+	})
+}
+
+func TestCountGoroutinesByStatus(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		counts, err := proc.CountGoroutinesByStatus(p.CurrentThread(), p.BinInfo())
+		assertNoError(err, t, "CountGoroutinesByStatus")
+		t.Logf("%v", counts)
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+		live := 0
+		for status, n := range counts {
+			if status != proc.Gdead {
+				live += n
+			}
+		}
+		if live != len(gs) {
+			t.Fatalf("mismatched number of goroutines: %d (by status) %d (GoroutinesInfo)", live, len(gs))
+		}
+		if counts[proc.Grunning] < 1 {
+			t.Fatalf("no running goroutines")
+		}
+	})
+}
+
+func TestTotalGoroutines(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		live, total, err := proc.TotalGoroutines(p.CurrentThread(), p.BinInfo())
+		assertNoError(err, t, "TotalGoroutines")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+		if live != len(gs) || total < live {
+			t.Fatalf("wrong number of goroutines: %d live %d total, GoroutinesInfo returned %d", live, total, len(gs))
+		}
+	})
+}
+
+func TestIterateGoroutines(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+
+		n := 0
+		err = proc.IterateGoroutines(p.CurrentThread(), p.BinInfo(), func(g *proc.G) bool {
+			if g.Unreadable == nil && g.ID != gs[n].ID {
+				t.Errorf("goroutine %d mismatch %d %d", n, g.ID, gs[n].ID)
+			}
+			n++
+			return true
+		})
+		assertNoError(err, t, "IterateGoroutines")
+		if n != len(gs) {
+			t.Fatalf("wrong number of goroutines %d, expected %d", n, len(gs))
+		}
+
+		n = 0
+		selgid := p.SelectedGoroutine().ID
+		err = proc.IterateGoroutines(p.CurrentThread(), p.BinInfo(), func(g *proc.G) bool {
+			n++
+			return g.ID != selgid
+		})
+		assertNoError(err, t, "IterateGoroutines")
+		for i := range gs {
+			if gs[i].ID == selgid {
+				if n != i+1 {
+					t.Fatalf("iteration did not stop at goroutine %d (%d %d)", selgid, n, i+1)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestGoroutinesFromAllgs(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo")
+
+		var paged []*proc.G
+		for start := 0; start < 10000; start += 3 {
+			page, err := proc.GoroutinesFromAllgs(p.CurrentThread(), p.BinInfo(), start, 3)
+			assertNoError(err, t, "GoroutinesFromAllgs")
+			paged = append(paged, page...)
+			if len(paged) >= len(gs) {
+				break
+			}
+		}
+		if len(paged) != len(gs) {
+			t.Fatalf("wrong number of goroutines %d, expected %d", len(paged), len(gs))
+		}
+		for i := range gs {
+			if gs[i].Unreadable == nil && paged[i].ID != gs[i].ID {
+				t.Errorf("goroutine %d mismatch %d %d", i, paged[i].ID, gs[i].ID)
+			}
+		}
+	})
+}
+
+func TestUserCurrentInlined(t *testing.T) {
+	if !goversion.VersionAfterOrEqual(runtime.Version(), 1, 10) {
+		// Versions of go before 1.10 do not have DWARF information for inlined calls
+		t.Skip("inlining not supported")
+	}
+	withTestProcessArgs("testinline", t, ".", []string{}, protest.EnableInlining, func(p *proc.Target, fixture protest.Fixture) {
+		pcs, err := p.BinInfo().LineToPC(fixture.Source, 7)
+		assertNoError(err, t, "LineToPC")
+		for _, pc := range pcs {
+			_, err := p.SetBreakpoint(pc, proc.UserBreakpoint, nil)
+			assertNoError(err, t, fmt.Sprintf("SetBreakpoint(%#x)", pc))
+		}
+		assertNoError(proc.Continue(p), t, "Continue")
+		loc := p.SelectedGoroutine().UserCurrent()
+		if loc.Fn == nil || loc.Fn.Name != "main.inlineThis" || loc.Line != 7 {
+			t.Fatalf("wrong UserCurrent location: %v", loc)
+		}
+	})
+}
+
 func TestCmdLineArgs(t *testing.T) {
 	expectSuccess := func(p *proc.Target, fixture protest.Fixture) {
 		err := proc.Continue(p)
@@ -3445,6 +3779,25 @@ func TestIssue1034(t *testing.T) {
 	})
 }
 
+func TestGoroutineInCgo(t *testing.T) {
+	withTestProcess("cgostacktest/", t, func(p *proc.Target, fixture protest.Fixture) {
+		// first breakpoint is in C code called by main.main
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g, err := proc.GetG(p.CurrentThread())
+		assertNoError(err, t, "GetG")
+		if !g.InCgo() {
+			t.Fatal("goroutine stopped in C code not reported as InCgo")
+		}
+		sp, pc, ok := g.CgoBoundary()
+		if !ok || sp == 0 {
+			t.Fatalf("wrong cgo boundary %#x %#x %v", sp, pc, ok)
+		}
+		if fn := p.BinInfo().PCToFunc(pc); fn == nil || fn.Name != "runtime.cgocall" {
+			t.Errorf("cgo boundary PC %#x not in runtime.cgocall", pc)
+		}
+	})
+}
+
 func TestIssue1008(t *testing.T) {
 	// The external linker on macOS inserts "end of sequence" extended opcodes
 	// in debug_line. which we should support correctly.
@@ -3995,6 +4348,34 @@ func TestIssue1264(t *testing.T) {
 	})
 }
 
+func TestGoroutineDefers(t *testing.T) {
+	withTestProcess("deferstack", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue")
+		g := p.SelectedGoroutine()
+		defers, err := g.Defers(10)
+		assertNoError(err, t, "Defers")
+		tgt := []string{"main.f2", "main.f3", "main.f1", "main.f2"}
+		if len(defers) != len(tgt) {
+			t.Fatalf("wrong number of defers %d, expected %d", len(defers), len(tgt))
+		}
+		for i := range defers {
+			loc := defers[i].DeferredLoc()
+			t.Logf("%d %#x %s:%d started=%v", i, loc.PC, loc.File, loc.Line, defers[i].Started)
+			if loc.Fn == nil || loc.Fn.Name != tgt[i] {
+				t.Errorf("defer %d: wrong function %v, expected %s", i, loc, tgt[i])
+			}
+			if defers[i].Started {
+				t.Errorf("defer %d started", i)
+			}
+		}
+		defers, err = g.Defers(2)
+		assertNoError(err, t, "Defers")
+		if len(defers) != 2 {
+			t.Fatalf("wrong number of defers %d, expected 2", len(defers))
+		}
+	})
+}
+
 func TestReadDefer(t *testing.T) {
 	withTestProcess("deferstack", t, func(p *proc.Target, fixture protest.Fixture) {
 		assertNoError(proc.Continue(p), t, "Continue")
@@ -4231,6 +4612,76 @@ func TestDeadlockBreakpoint(t *testing.T) {
 	})
 }
 
+func TestGoroutineBlockedOn(t *testing.T) {
+	if buildMode == "pie" {
+		t.Skip("See https://github.com/golang/go/issues/29322")
+	}
+	withTestProcess("testdeadlock", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		groups := proc.GroupByBlockedOn(proc.FilterGoroutines(gs, proc.WaitReasonIs("chan receive")))
+		if len(groups) != 2 {
+			t.Fatalf("expected goroutines blocked on two channels, got %d", len(groups))
+		}
+		for addr, gs := range groups {
+			if len(gs) != 1 {
+				t.Errorf("expected one goroutine blocked on %#x, got %d", addr, len(gs))
+			}
+			for _, g := range gs {
+				_, kind, _ := g.BlockedOn()
+				if kind != proc.BlockedOnChanRecv {
+					t.Errorf("goroutine %d blocked on %q", g.ID, kind)
+				}
+			}
+		}
+	})
+}
+
+func TestGoroutineNetPollFD(t *testing.T) {
+	withTestProcess("netpollfd", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		listenerFD, _ := constant.Int64Val(evalVariable(p, t, "main.listenerFD").Value)
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		accepters := proc.FilterGoroutines(gs, proc.OnUserFrame("main.accepter"))
+		if len(accepters) != 1 {
+			t.Fatalf("expected one goroutine in main.accepter, got %d", len(accepters))
+		}
+		fd, ok, err := accepters[0].NetPollFD()
+		assertNoError(err, t, "NetPollFD()")
+		if !ok || int64(fd) != listenerFD {
+			t.Errorf("expected fd %d got %d %v (wait reason %q)", listenerFD, fd, ok, accepters[0].WaitReason)
+		}
+		if _, ok, _ := p.SelectedGoroutine().NetPollFD(); ok {
+			t.Errorf("main goroutine reported as waiting on a file descriptor")
+		}
+	})
+}
+
+func TestGoroutineSelectCases(t *testing.T) {
+	withTestProcess("selectprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		gs = proc.FilterGoroutines(gs, proc.OnUserFrame("main.selecter"))
+		if len(gs) != 1 {
+			t.Fatalf("expected one goroutine in main.selecter, got %d", len(gs))
+		}
+		if !gs[0].InSelect() {
+			t.Fatalf("goroutine not in select (wait reason %q)", gs[0].WaitReason)
+		}
+		n, err := gs[0].SelectCases()
+		assertNoError(err, t, "SelectCases()")
+		if n != 3 {
+			t.Fatalf("expected 3 cases, got %d", n)
+		}
+		if p.SelectedGoroutine().InSelect() {
+			t.Fatal("main goroutine reported in select")
+		}
+	})
+}
+
 func TestListImages(t *testing.T) {
 	pluginFixtures := protest.WithPlugins(t, protest.AllNonOptimized, "plugin1/", "plugin2/")
 
@@ -4286,9 +4737,12 @@ func TestAncestors(t *testing.T) {
 		if len(as) != 1 {
 			t.Fatalf("expected only one ancestor got %d", len(as))
 		}
+		if as2, err := p.SelectedGoroutine().Ancestors(1000); err != nil || len(as2) != 1 || as2[0].ID != as[0].ID {
+			t.Fatalf("G.Ancestors returned %#v %v", as2, err)
+		}
 		mainFound := false
 		for i, a := range as {
-			astack, err := a.Stack(100)
+			astack, err := a.Stack(p.BinInfo(), 100)
 			assertNoError(err, t, fmt.Sprintf("Ancestor %d stack", i))
 			t.Logf("ancestor %d\n", i)
 			logStacktrace(t, p.BinInfo(), astack)
@@ -4304,6 +4758,39 @@ func TestAncestors(t *testing.T) {
 	})
 }
 
+func TestCreationStack(t *testing.T) {
+	if !goversion.VersionAfterOrEqual(runtime.Version(), 1, 11) {
+		t.Skip("not supported on Go <= 1.10")
+	}
+	savedGodebug := os.Getenv("GODEBUG")
+	os.Setenv("GODEBUG", "tracebackancestors=100")
+	defer os.Setenv("GODEBUG", savedGodebug)
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.testgoroutine")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g := p.SelectedGoroutine()
+		locs, err := g.CreationStack(p.BinInfo(), 10)
+		assertNoError(err, t, "CreationStack")
+		for i := range locs {
+			t.Logf("%d %#x %s:%d", i, locs[i].PC, locs[i].File, locs[i].Line)
+		}
+		if len(locs) != 2 {
+			t.Fatalf("expected two locations got %d", len(locs))
+		}
+		if locs[0] != g.Go() {
+			t.Errorf("first location %v does not match Go() %v", locs[0], g.Go())
+		}
+		if locs[0].Fn == nil || locs[0].Fn.Name != "main.main" {
+			t.Errorf("wrong function for first location %v", locs[0])
+		}
+		locs, err = g.CreationStack(p.BinInfo(), 1)
+		assertNoError(err, t, "CreationStack")
+		if len(locs) != 1 {
+			t.Fatalf("expected one location got %d", len(locs))
+		}
+	})
+}
+
 func testCallConcurrentCheckReturns(p *proc.Target, t *testing.T, gid1, gid2 int) int {
 	found := 0
 	for _, thread := range p.ThreadList() {
@@ -4573,3 +5060,252 @@ func BenchmarkConditionalBreakpoints(b *testing.B) {
 		}
 	})
 }
+
+func TestGoroutineOrigin(t *testing.T) {
+	withTestProcess("goorigin", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.foo")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		created, entry := p.SelectedGoroutine().Origin()
+		t.Logf("created %s:%d entry %s:%d", created.File, created.Line, entry.File, entry.Line)
+		if created.Fn == nil || created.Fn.Name != "main.main" || created.Line != 11 {
+			t.Errorf("wrong created location %v", created)
+		}
+		if entry.Fn == nil || entry.Fn.Name != "main.main.func1" || entry.Line != 11 {
+			t.Errorf("wrong entry location %v", entry)
+		}
+		if entry.PC != entry.Fn.Entry {
+			t.Errorf("entry location %#x is not the entry point of %s", entry.PC, entry.Fn.Name)
+		}
+	})
+}
+
+func TestGoroutineSignalStack(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses linux specific syscalls")
+	}
+	withTestProcess("sigstack", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "runtime.sighandler")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g, err := proc.GetG(p.CurrentThread())
+		assertNoError(err, t, "GetG")
+		t.Logf("goroutine %d systemStack %v signalStack %v", g.ID, g.SystemStack, g.SignalStack)
+		if !g.SystemStack || !g.SignalStack {
+			t.Errorf("goroutine not on the signal stack")
+		}
+		if g.ID != 1 {
+			t.Errorf("expected main goroutine, got %d", g.ID)
+		}
+	})
+}
+
+func TestWhoHoldsMutex(t *testing.T) {
+	withTestProcess("mutexholder", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.holding")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		muvar := evalVariable(p, t, "main.mu")
+		addr := uint64(muvar.Addr)
+
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		if _, err := proc.WhoHoldsMutex(addr, gs, p.CurrentThread(), p.BinInfo()); err != proc.ErrMutexHolderUnknown {
+			t.Fatalf("expected ErrMutexHolderUnknown, got %v", err)
+		}
+
+		// The holder can be found while it is unlocking the mutex.
+		setFunctionBreakpoint(p, t, "sync.(*Mutex).unlockSlow")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err = proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		g, err := proc.WhoHoldsMutex(addr, gs, p.CurrentThread(), p.BinInfo())
+		assertNoError(err, t, "WhoHoldsMutex()")
+		if g == nil || g.ID != p.SelectedGoroutine().ID {
+			t.Fatalf("wrong holder %v", g)
+		}
+	})
+}
+
+func TestFindGoroutineCache(t *testing.T) {
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		p.ClearAllGCache()
+
+		// Look goroutines up in reverse order so that the first lookup scans
+		// all of runtime.allgs and the others are served by the cache.
+		for i := len(gs) - 1; i >= 0; i-- {
+			if gs[i].Unreadable != nil {
+				continue
+			}
+			g, err := proc.FindGoroutine(p, gs[i].ID)
+			assertNoError(err, t, fmt.Sprintf("FindGoroutine(%d)", gs[i].ID))
+			if g.ID != gs[i].ID || g.Status != gs[i].Status || g.PC != gs[i].PC {
+				t.Errorf("mismatched goroutine %d: %d %d %#x", gs[i].ID, g.ID, g.Status, g.PC)
+			}
+		}
+		if _, err := proc.FindGoroutine(p, 100000); err == nil {
+			t.Errorf("no error for unknown goroutine")
+		}
+	})
+}
+
+func TestCurrentGoroutineFromRegisters(t *testing.T) {
+	withTestProcess("testnextprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.helloworld")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		for _, thread := range p.ThreadList() {
+			tg, _ := proc.GetG(thread)
+			regs, err := thread.Registers(false)
+			assertNoError(err, t, "Registers()")
+			g, err := proc.CurrentGoroutineFromRegisters(regs, p.BinInfo(), thread)
+			if tg == nil {
+				if g != nil {
+					t.Errorf("thread %d: unexpected goroutine %d", thread.ThreadID(), g.ID)
+				}
+				continue
+			}
+			assertNoError(err, t, "CurrentGoroutineFromRegisters()")
+			if g.ID != tg.ID || g.SystemStack != tg.SystemStack || g.CurrentLoc.PC != tg.CurrentLoc.PC {
+				t.Errorf("thread %d: mismatched goroutine %d %d", thread.ThreadID(), g.ID, tg.ID)
+			}
+			if g.Thread != nil {
+				t.Errorf("thread %d: Thread field set", thread.ThreadID())
+			}
+		}
+	})
+}
+
+func TestStackDepthExceeds(t *testing.T) {
+	withTestProcess("stacktraceprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		g := p.SelectedGoroutine()
+		frames, err := g.Stacktrace(40, 0)
+		assertNoError(err, t, "Stacktrace()")
+		n := len(frames)
+		for _, tc := range []struct {
+			n   int
+			tgt bool
+		}{
+			{0, true},
+			{n - 1, true},
+			{n, false},
+			{n + 10, false},
+		} {
+			exceeds, err := g.StackDepthExceeds(tc.n)
+			assertNoError(err, t, "StackDepthExceeds()")
+			if exceeds != tc.tgt {
+				t.Errorf("StackDepthExceeds(%d) = %v, stack has %d frames", tc.n, exceeds, n)
+			}
+		}
+	})
+}
+
+func TestVariableLocation(t *testing.T) {
+	protest.AllowRecording(t)
+	withTestProcess("testvariables2", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		scope, err := proc.GoroutineScope(p.CurrentThread())
+		assertNoError(err, t, "GoroutineScope()")
+		loc, err := scope.VariableLocation("i1")
+		assertNoError(err, t, "VariableLocation(i1)")
+		if loc.OptimizedOut() {
+			t.Fatalf("i1 optimized out")
+		}
+		t.Logf("i1: %s", op.FormatOps(loc.Ops))
+		if _, err := scope.VariableLocation("nonexistent"); err == nil {
+			t.Errorf("no error for nonexistent variable")
+		}
+	})
+}
+
+func TestGoroutineWaitReasonCode(t *testing.T) {
+	if !goversion.VersionAfterOrEqual(runtime.Version(), 1, 11) {
+		t.Skip("wait reasons are strings before go1.11")
+	}
+	withTestProcess("selectprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		gs = proc.FilterGoroutines(gs, proc.OnUserFrame("main.selecter"))
+		if len(gs) != 1 {
+			t.Fatalf("expected one goroutine in main.selecter, got %d", len(gs))
+		}
+		if gs[0].WaitReasonCode != proc.WaitReasonSelect {
+			t.Errorf("wrong wait reason code %d (wait reason %q)", gs[0].WaitReasonCode, gs[0].WaitReason)
+		}
+	})
+}
+
+func TestRunningGoroutines(t *testing.T) {
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		running, err := proc.RunningGoroutines(p.ThreadList(), p.BinInfo())
+		assertNoError(err, t, "RunningGoroutines()")
+		g, ok := running[p.CurrentThread().ThreadID()]
+		if !ok {
+			t.Fatalf("no goroutine for the current thread")
+		}
+		if g.ID != p.SelectedGoroutine().ID {
+			t.Errorf("wrong goroutine for the current thread %d (expected %d)", g.ID, p.SelectedGoroutine().ID)
+		}
+		for tid, g := range running {
+			if g.Thread == nil || g.Thread.ThreadID() != tid {
+				t.Errorf("goroutine %d returned for thread %d", g.ID, tid)
+			}
+		}
+	})
+}
+
+func TestGoroutinesInfoContext(t *testing.T) {
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		gs, nextg, err := proc.GoroutinesInfoContext(ctx, p, 0, 0)
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if len(gs) != 0 || nextg != 0 {
+			t.Errorf("unexpected result of canceled scan %d %d", len(gs), nextg)
+		}
+		gs, _, err = proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		if len(gs) < 10 {
+			t.Errorf("too few goroutines after canceled scan: %d", len(gs))
+		}
+	})
+}
+
+func TestGroupByStack(t *testing.T) {
+	withTestProcess("goroutinestackprog", t, func(p *proc.Target, fixture protest.Fixture) {
+		setFunctionBreakpoint(p, t, "main.stacktraceme")
+		assertNoError(proc.Continue(p), t, "Continue()")
+		gs, _, err := proc.GoroutinesInfo(p, 0, 0)
+		assertNoError(err, t, "GoroutinesInfo()")
+		groups := proc.GroupByStack(gs, 50)
+		n := 0
+		for _, group := range groups {
+			n += group.Count
+		}
+		if n != len(gs) {
+			t.Errorf("groups contain %d goroutines, expected %d", n, len(gs))
+		}
+		// All instances of main.agoroutine are blocked on the same line.
+		found := false
+		for _, group := range groups {
+			if loc := group.G.UserCurrent(); loc.Fn != nil && loc.Fn.Name == "main.agoroutine" {
+				if found || group.Count != 10 {
+					t.Errorf("wrong group for main.agoroutine: %d goroutines", group.Count)
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no group for main.agoroutine")
+		}
+	})
+}
@@ -1,7 +1,31 @@
 package proc
 
 import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/constant"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/dwarf/loclist"
+	"github.com/go-delve/delve/pkg/dwarf/op"
+	"github.com/go-delve/delve/pkg/proc/debuginfod"
+	protest "github.com/go-delve/delve/pkg/proc/test"
 )
 
 func TestAlignAddr(t *testing.T) {
@@ -73,3 +97,1780 @@ func TestAlignAddr(t *testing.T) {
 		c(example.align, example.in+0x10000, example.tgt+0x10000)
 	}
 }
+
+// goroutineIDs returns the IDs of gs, -1 for nil goroutines.
+func goroutineIDs(gs []*G) []int {
+	r := []int{}
+	for _, g := range gs {
+		if g == nil {
+			r = append(r, -1)
+			continue
+		}
+		r = append(r, g.ID)
+	}
+	return r
+}
+
+func TestFilterGoroutines(t *testing.T) {
+	gs := []*G{
+		{ID: 1, Status: Grunning},
+		{ID: 2, Status: Gwaiting, WaitReason: "chan receive"},
+		{ID: 3, Status: Gwaiting, WaitReason: "select"},
+		{Unreadable: errors.New("unreadable")},
+		nil,
+		{ID: 4, Status: Gwaiting, WaitReason: "chan receive"},
+	}
+
+	for _, tc := range []struct {
+		name string
+		pred func(*G) bool
+		tgt  []int
+	}{
+		{"WaitReasonIs", WaitReasonIs("chan receive"), []int{2, 4}},
+		{"StatusIs(Gwaiting)", StatusIs(Gwaiting), []int{2, 3, 4}},
+		{"StatusIs(Grunning)", StatusIs(Grunning), []int{1}},
+		{"StatusIs(Gdead)", StatusIs(Gdead), []int{}},
+		{"OnUserFrame", OnUserFrame("main.main"), []int{}},
+	} {
+		out := goroutineIDs(FilterGoroutines(gs, tc.pred))
+		if !reflect.DeepEqual(out, tc.tgt) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.tgt, out)
+		}
+	}
+}
+
+func TestGoroutinesInPackage(t *testing.T) {
+	frame := func(fname string) Stackframe {
+		loc := Location{Fn: &Function{Name: fname}}
+		return Stackframe{Current: loc, Call: loc}
+	}
+	g := func(id int, fnames ...string) *G {
+		r := &G{ID: id, variable: &Variable{}, cachedStackComplete: true}
+		for _, fname := range fnames {
+			r.cachedStack = append(r.cachedStack, frame(fname))
+		}
+		if len(r.cachedStack) > 0 {
+			r.CurrentLoc = r.cachedStack[0].Current
+		}
+		return r
+	}
+	gs := []*G{
+		g(1, "runtime.gopark", "net/http.(*conn).serve", "runtime.goexit"),
+		g(2, "runtime.gopark", "net/http/httputil.(*ReverseProxy).ServeHTTP"),
+		g(3, "main.handler", "net/http.HandlerFunc.ServeHTTP"),
+		g(4, "runtime.gopark", "runtime.bgsweep"),
+		g(5, "gopkg.in/yaml%2ev2.Marshal"),
+		g(6, "net/http.get[go.shape.struct { main.x *net/http/httputil.ReverseProxy }]"),
+		g(7),
+		{ID: 8, Unreadable: errors.New("unreadable")},
+		nil,
+	}
+	for _, tc := range []struct {
+		pkgPath string
+		tgt     []int
+	}{
+		{"net/http", []int{1, 6}},
+		{"net/http/httputil", []int{2}},
+		{"http", []int{}},
+		{"main", []int{3}},
+		{"runtime", []int{4}},
+		{"gopkg.in/yaml.v2", []int{5}},
+	} {
+		if out := goroutineIDs(GoroutinesInPackage(gs, tc.pkgPath)); !reflect.DeepEqual(out, tc.tgt) {
+			t.Errorf("%s: expected %v got %v", tc.pkgPath, tc.tgt, out)
+		}
+	}
+}
+
+func TestDiffGoroutines(t *testing.T) {
+	old := []*G{
+		{ID: 1, Status: Grunning, StartPC: 0x1000},
+		{ID: 2, Status: Gwaiting, StartPC: 0x2000},
+		{ID: 3, Status: Grunnable, StartPC: 0x3000},
+		{ID: 4, Status: Gwaiting, StartPC: 0x4000},
+		{ID: 5, Unreadable: errors.New("unreadable")},
+	}
+	new := []*G{
+		{ID: 1, Status: Grunning, StartPC: 0x1000},
+		{ID: 2, Status: Gsyscall, StartPC: 0x2000},
+		{ID: 4, Status: Gwaiting, StartPC: 0x5000}, // reused ID
+		{ID: 6, Status: Grunnable, StartPC: 0x6000},
+		nil,
+	}
+	tgt := GoroutineDiff{
+		Added:         []int64{4, 6},
+		Removed:       []int64{3, 4},
+		StatusChanged: []int64{2},
+	}
+	if diff := DiffGoroutines(old, new); !reflect.DeepEqual(diff, tgt) {
+		t.Errorf("expected %#v got %#v", tgt, diff)
+	}
+	if diff := DiffGoroutines(old, old); diff.Added != nil || diff.Removed != nil || diff.StatusChanged != nil {
+		t.Errorf("expected no differences got %#v", diff)
+	}
+}
+
+func TestLongBlockedGoroutines(t *testing.T) {
+	const now = int64(100 * time.Second)
+	gs := []*G{
+		{ID: 1, Status: Grunning},
+		{ID: 2, Status: Gwaiting, WaitSince: now - int64(6*time.Second)},
+		{ID: 3, Status: Gwaiting, WaitSince: now - int64(time.Second)},
+		{ID: 4, Status: Gwaiting}, // wait time unknown
+		{ID: 5, Status: Gwaiting, WaitSince: now - int64(time.Minute)},
+		{ID: 6, Unreadable: errors.New("unreadable"), Status: Gwaiting, WaitSince: 1},
+		nil,
+		{ID: 7, Status: Grunnable, WaitSince: 1},
+		{ID: 8, Status: Gwaiting, WaitSince: now - int64(5*time.Second)},
+	}
+	out := goroutineIDs(LongBlockedGoroutines(gs, now, 5*time.Second))
+	if tgt := []int{5, 2, 8}; !reflect.DeepEqual(out, tgt) {
+		t.Errorf("expected %v got %v", tgt, out)
+	}
+	if d, ok := gs[1].WaitDuration(now); !ok || d != 6*time.Second {
+		t.Errorf("wrong wait duration %v %v", d, ok)
+	}
+}
+
+func TestSortByCreation(t *testing.T) {
+	gs := []*G{
+		{ID: 7, Status: Gwaiting},
+		{ID: 3, Status: Gdead},
+		nil,
+		{ID: 1, Status: Grunning},
+		{ID: 12, Status: Grunnable},
+		{ID: 0, Status: Gdead},
+		{ID: 5, Unreadable: errors.New("unreadable")},
+	}
+	SortByCreation(gs)
+	if out, tgt := goroutineIDs(gs), []int{1, 5, 7, 12, 0, 3, -1}; !reflect.DeepEqual(out, tgt) {
+		t.Errorf("expected %v got %v", tgt, out)
+	}
+}
+
+func TestGroupByStackUnreadable(t *testing.T) {
+	gs := []*G{
+		{ID: 1, Unreadable: errors.New("unreadable")},
+		nil,
+		{ID: 2, Unreadable: errors.New("unreadable")},
+	}
+	groups := GroupByStack(gs, 10)
+	if len(groups) != 1 || !groups[0].Unreadable || groups[0].Count != 3 || groups[0].G != gs[0] {
+		t.Errorf("wrong groups %#v", groups)
+	}
+}
+
+func TestFindRuntimeGTypeCache(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	typ1, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ1.Common().Name != "runtime.g" {
+		t.Fatalf("wrong type %s", typ1.Common().Name)
+	}
+
+	// Any further lookup of runtime.g in the DWARF info fails, the second
+	// call must not do one.
+	ref := bi.types["runtime.g"]
+	delete(bi.types, "runtime.g")
+	typ2, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatalf("runtime.g type not cached: %v", err)
+	}
+	if typ1 != typ2 {
+		t.Fatalf("runtime.g type not cached")
+	}
+
+	// Without the cache the lookup does fail.
+	bi.runtimeGType = nil
+	if _, err := bi.findRuntimeGType(); err == nil {
+		t.Fatalf("runtime.g type found without debug info")
+	}
+	bi.types["runtime.g"] = ref
+	if typ3, err := bi.findRuntimeGType(); err != nil || typ3.Common().Name != "runtime.g" {
+		t.Fatalf("wrong type %v %v", typ3, err)
+	}
+}
+
+func TestParseGErrors(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	readErr := errors.New("read error")
+	gvar, err := newGVariableMem(bi, &fakeMemory{err: readErr}, fakeGAddr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gvar.parseG()
+	if unreadable, ok := err.(ErrGStructUnreadable); !ok || unreadable.Err != readErr || unreadable.Addr != fakeGAddr {
+		t.Errorf("wrong error for unreadable memory: %#v", err)
+	}
+	if _, ok := err.(GoroutineError); !ok {
+		t.Errorf("%T does not implement GoroutineError", err)
+	}
+
+	gvar, err = newGVariableMem(bi, &fakeMemory{}, fakeGAddr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gvar.parseG()
+	if _, ok := err.(ErrNoGoroutine); !ok {
+		t.Errorf("wrong error for nil g: %#v", err)
+	}
+}
+
+func TestCompileUnitIndirectForms(t *testing.T) {
+	var addr, loclists bytes.Buffer
+	// .debug_addr: 8 byte header followed by two subsections of two entries
+	// each.
+	addr.Write(make([]byte, 8))
+	for _, a := range []uint64{0x1000, 0x1010, 0x2000, 0x2010} {
+		binary.Write(&addr, binary.LittleEndian, a)
+	}
+	// .debug_loclists: 12 byte header, offsets table with one entry and a
+	// location list using an address index.
+	loclists.Write(make([]byte, 12))
+	binary.Write(&loclists, binary.LittleEndian, uint32(4))
+	loclists.Write([]byte{0x3, 0x1, 0x10, 0x1, byte(op.DW_OP_reg0)}) // DW_LLE_startx_length
+	loclists.WriteByte(0x0)                                          // DW_LLE_end_of_list
+
+	loclist5, err := loclist.NewDwarf5(loclists.Bytes(), 8, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := &Image{
+		debugAddr: godwarf.ParseAddr(addr.Bytes(), 8, binary.LittleEndian),
+		loclist5:  loclist5,
+	}
+	cu := &compileUnit{image: image, addrBase: 8 + 2*8, loclistsBase: 12, ranges: [][2]uint64{{0x2000, 0x3000}}}
+
+	for idx, tgt := range []uint64{0x2000, 0x2010} {
+		a, err := cu.addrx(uint64(idx))
+		if err != nil || a != tgt {
+			t.Errorf("addrx(%d): %#x %v, expected %#x", idx, a, err, tgt)
+		}
+	}
+	if _, err := cu.addrx(2); err == nil {
+		t.Errorf("no error for out of bounds address index")
+	}
+
+	// DW_FORM_loclistx
+	bi := NewBinaryInfo("linux", "amd64")
+	bi.Images = []*Image{image}
+	bi.compileUnits = []*compileUnit{cu}
+	entry := &dwarf.Entry{Field: []dwarf.Field{{Attr: dwarf.AttrLocation, Val: uint64(0), Class: dwarf.ClassLocList}}}
+	instr, _, err := bi.locationExpr(entry, dwarf.AttrLocation, 0x2018)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(instr, []byte{byte(op.DW_OP_reg0)}) {
+		t.Errorf("wrong location expression %x", instr)
+	}
+	loc, err := bi.LocationOps(entry, dwarf.AttrLocation, 0x2018)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Loclist == nil || len(loc.Ops) != 1 || loc.Ops[0].Opcode != op.DW_OP_reg0 {
+		t.Errorf("wrong location %#v", loc)
+	}
+	entry.Field[0].Val = uint64(100)
+	if _, _, err := bi.locationExpr(entry, dwarf.AttrLocation, 0x2018); err == nil {
+		t.Errorf("no error for out of bounds loclist index")
+	}
+	if _, err := bi.LocationOps(entry, dwarf.AttrLocation, 0x2018); err == nil {
+		t.Errorf("no error for out of bounds loclist index")
+	}
+}
+
+func TestStackBoundsValid(t *testing.T) {
+	for _, tc := range []struct {
+		g     G
+		valid bool
+	}{
+		{G{Status: Gwaiting, SP: 0x1800, stacklo: 0x1000, stackhi: 0x2000}, true},
+		{G{Status: Gwaiting, SP: 0x2800, stacklo: 0x1000, stackhi: 0x2000}, false},
+		{G{Status: Grunning, SP: 0x2800, stacklo: 0x1000, stackhi: 0x2000}, true},
+		{G{Status: Grunnable, SP: 0x1800, stacklo: 0x3000, stackhi: 0x2000}, false},
+		{G{Status: Gdead}, true},
+	} {
+		if valid := tc.g.StackBoundsValid(); valid != tc.valid {
+			t.Errorf("%#x [%#x, %#x] status %d: got %v expected %v", tc.g.SP, tc.g.stacklo, tc.g.stackhi, tc.g.Status, valid, tc.valid)
+		}
+	}
+}
+
+func TestGoroutineMarshalJSON(t *testing.T) {
+	g := &G{ID: 5, Status: Gwaiting, PC: 0x1000, SP: 0x2000, WaitReason: "chan receive", CurrentLoc: Location{PC: 0x1000, File: "main.go", Line: 10, Fn: &Function{Name: "main.f"}}}
+	buf, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", buf)
+	for key, tgt := range map[string]interface{}{
+		"version":      float64(gJSONVersion),
+		"id":           float64(5),
+		"status":       float64(Gwaiting),
+		"statusString": "waiting",
+		"waitReason":   "chan receive",
+	} {
+		if out[key] != tgt {
+			t.Errorf("wrong value for %s: %v expected %v", key, out[key], tgt)
+		}
+	}
+	if loc, _ := out["currentLoc"].(map[string]interface{}); loc == nil || loc["function"] != "main.f" || loc["line"] != float64(10) {
+		t.Errorf("wrong current location %v", out["currentLoc"])
+	}
+	if _, ok := out["unreadable"]; ok {
+		t.Errorf("unexpected unreadable field")
+	}
+
+	buf, err = json.Marshal(&G{Unreadable: errors.New("some error")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = nil
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["unreadable"] != "some error" {
+		t.Errorf("wrong unreadable field: %s", buf)
+	}
+}
+
+func TestGCRelated(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	startPC := func(name string) uint64 {
+		fn := bi.LookupFunc[name]
+		if fn == nil {
+			t.Fatalf("could not find %s", name)
+		}
+		return fn.Entry
+	}
+
+	for _, tc := range []struct {
+		g   *G
+		tgt bool
+	}{
+		{&G{Status: Gwaiting, WaitReason: "GC assist marking"}, true},
+		{&G{Status: Gwaiting, WaitReason: "GC worker (idle)"}, true},
+		{&G{Status: Gwaiting, WaitReason: "chan receive"}, false},
+		{&G{Status: Grunning, StartPC: startPC("runtime.gcBgMarkWorker"), variable: &Variable{bi: bi}}, true},
+		{&G{Status: Gwaiting, WaitReason: "chan receive", StartPC: startPC("main.main"), variable: &Variable{bi: bi}}, false},
+	} {
+		if out := tc.g.GCRelated(); out != tc.tgt {
+			t.Errorf("%q %#x: got %v expected %v", tc.g.WaitReason, tc.g.StartPC, out, tc.tgt)
+		}
+	}
+}
+
+func TestParseGBulkRead(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	bulk := &fakeMemory{}
+	parseFakeG(t, bi, bulk)
+	if bulk.totalReads() != 1 {
+		t.Errorf("wrong number of reads for the g struct: %d", bulk.totalReads())
+	}
+
+	// If the g struct can not be read at once every field is read
+	// individually.
+	partial := &fakeMemory{maxRead: 16}
+	g := parseFakeG(t, bi, partial)
+	if g.Unreadable != nil {
+		t.Errorf("unexpected unreadable error: %v", g.Unreadable)
+	}
+	if partial.totalReads() <= 1 {
+		t.Errorf("wrong number of reads for the g struct: %d", partial.totalReads())
+	}
+	t.Logf("reads: %d bulk, %d fallback", bulk.totalReads(), partial.totalReads())
+}
+
+func BenchmarkParseG(b *testing.B) {
+	bi := loadTestBinaryInfo(b)
+	defer bi.Close()
+
+	mem := &fakeMemory{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseFakeG(b, bi, mem)
+	}
+	b.Logf("%d reads for %d g structs", mem.totalReads(), b.N)
+}
+
+func TestStackBeingCopied(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	g := &G{Status: Gcopystack, SP: 0x1800, stacklo: 0x1000, stackhi: 0x2000, variable: &Variable{bi: bi, mem: &fakeMemory{}}}
+	if !g.StackBeingCopied() {
+		t.Fatal("stack not being copied")
+	}
+	if _, err := g.Stacktrace(10, 0); err != ErrStackBeingCopied {
+		t.Errorf("wrong error %v", err)
+	}
+	g.Status = Gwaiting
+	if g.StackBeingCopied() {
+		t.Fatal("stack being copied")
+	}
+}
+
+func TestUserCurrentMaxFrames(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// Every return address on this stack is inside runtime.gopark, so that
+	// unwinding it never reaches a user frame nor ends.
+	fn := bi.LookupFunc["runtime.gopark"]
+	if fn == nil {
+		t.Fatal("could not find runtime.gopark")
+	}
+	pc := fn.Entry + 1
+	stack := make([]uint64, (fakeStackHi-fakeStackLo)/8)
+	for i := range stack {
+		stack[i] = pc
+	}
+	g := fakeGOnStack(t, bi, pc, fakeStackLo, stack...)
+
+	saved := userCurrentMaxFrames
+	defer func() { userCurrentMaxFrames = saved }()
+	userCurrentMaxFrames = 10
+	if frames, _ := g.Stacktrace(2*userCurrentMaxFrames, 0); len(frames) <= userCurrentMaxFrames {
+		t.Fatalf("synthetic stack too short: %d frames", len(frames))
+	}
+	if loc := g.UserCurrent(); loc != g.CurrentLoc {
+		t.Errorf("wrong location %#x %v", loc.PC, loc.Fn)
+	}
+}
+
+func TestGoroutineStackContains(t *testing.T) {
+	bi := NewBinaryInfo("linux", "amd64")
+	mem := &fakeMemory{base: 0x1000, buf: make([]byte, 0x1000)}
+	for _, off := range []int{0x10, 0x800, 0xff8} {
+		binary.LittleEndian.PutUint64(mem.buf[off:], 0xc000012345)
+	}
+	// not aligned
+	binary.LittleEndian.PutUint64(mem.buf[0x904:], 0xc000012345)
+
+	g := &G{Status: Gwaiting, SP: 0x1004, stacklo: 0x1000, stackhi: 0x2000, variable: &Variable{bi: bi, mem: mem}}
+	found, slots, err := g.StackContains(0xc000012345, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || !reflect.DeepEqual(slots, []uint64{0x1010, 0x1800, 0x1ff8}) {
+		t.Errorf("wrong slots %v %#x", found, slots)
+	}
+
+	found, slots, err = g.StackContains(0xdeadbeef, mem)
+	if err != nil || found || len(slots) != 0 {
+		t.Errorf("unexpected result %v %#x %v", found, slots, err)
+	}
+
+	g.SP = 0x3000
+	if _, _, err := g.StackContains(0xc000012345, mem); err == nil {
+		t.Error("no error for SP outside of the stack")
+	}
+}
+
+func TestDeferLayouts(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	fn := bi.LookupFunc["main.main"]
+
+	for _, tc := range []struct {
+		name    string
+		typ     *godwarf.StructType
+		siz     bool // has the siz field
+		started bool // has the started field
+	}{
+		{"go1.13", fakeDeferType([2]string{"siz", "int32"}, [2]string{"started", "bool"}, [2]string{"heap", "bool"}, [2]string{"sp", "uintptr"}, [2]string{"pc", "uintptr"}, [2]string{"fn", "*funcval"}, [2]string{"_panic", "uintptr"}, [2]string{"link", "*_defer"}), true, true},
+		{"go1.18", fakeDeferType([2]string{"started", "bool"}, [2]string{"heap", "bool"}, [2]string{"openDefer", "bool"}, [2]string{"sp", "uintptr"}, [2]string{"pc", "uintptr"}, [2]string{"fn", "func()"}, [2]string{"_panic", "uintptr"}, [2]string{"link", "*_defer"}), false, true},
+		{"go1.22", fakeDeferType([2]string{"heap", "bool"}, [2]string{"rangefunc", "bool"}, [2]string{"sp", "uintptr"}, [2]string{"pc", "uintptr"}, [2]string{"fn", "func()"}, [2]string{"link", "*_defer"}, [2]string{"head", "uintptr"}), false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const (
+				d1, d2  = 0x1000, 0x1100
+				funcval = 0x1200
+			)
+			mem := &fakeMemory{base: 0x1000, buf: make([]byte, 0x300)}
+			binary.LittleEndian.PutUint64(mem.buf[funcval-0x1000:], fn.Entry)
+			put := func(base uint64, name string, val uint64) {
+				for _, field := range tc.typ.Field {
+					if field.Name == name {
+						buf := make([]byte, 8)
+						binary.LittleEndian.PutUint64(buf, val)
+						copy(mem.buf[base-0x1000+uint64(field.ByteOffset):], buf[:field.Type.Size()])
+						return
+					}
+				}
+			}
+			for _, base := range []uint64{d1, d2} {
+				put(base, "siz", 16)
+				put(base, "started", 1)
+				put(base, "heap", 1)
+				put(base, "pc", 0x4321)
+				put(base, "fn", funcval)
+			}
+			put(d1, "sp", 0x2000)
+			put(d2, "sp", 0x2100)
+			put(d1, "link", d2)
+
+			d := &Defer{variable: newVariable("", d1, tc.typ, bi, mem)}
+			d.load()
+			if d.Unreadable != nil {
+				t.Fatal(d.Unreadable)
+			}
+			if d.DeferredPC != fn.Entry || d.DeferPC != 0x4321 || d.SP != 0x2000 {
+				t.Errorf("wrong defer DeferredPC=%#x DeferPC=%#x SP=%#x", d.DeferredPC, d.DeferPC, d.SP)
+			}
+			if d.Started != tc.started || !d.Heap {
+				t.Errorf("wrong defer flags started=%v heap=%v", d.Started, d.Heap)
+			}
+			if tc.siz != (d.argSz == 16) {
+				t.Errorf("wrong argument size %d", d.argSz)
+			}
+			d = d.Next()
+			if d == nil || d.Unreadable != nil || d.SP != 0x2100 || d.DeferredPC != fn.Entry {
+				t.Fatalf("wrong next defer %#v", d)
+			}
+			if d.Next() != nil {
+				t.Errorf("defer list not terminated")
+			}
+		})
+	}
+}
+
+func TestFindCompileUnitForOffsetImages(t *testing.T) {
+	bi := NewBinaryInfo("linux", "amd64")
+	exe := &Image{Path: "/exe", index: 0}
+	plugin := &Image{Path: "/plugin.so", index: 1}
+	bi.Images = []*Image{exe, plugin}
+	// Both images have units starting at offset 0x0b, as loadDebugInfoMaps
+	// does every unit is also indexed by the image it belongs to.
+	for _, cu := range []*compileUnit{
+		{name: "plugin/b", offset: 0x100, image: plugin},
+		{name: "plugin/a", offset: 0x0b, image: plugin},
+		{name: "runtime", offset: 0x200, image: exe},
+		{name: "main", offset: 0x0b, image: exe},
+	} {
+		bi.compileUnits = append(bi.compileUnits, cu)
+		cu.image.compileUnits = append(cu.image.compileUnits, cu)
+	}
+	sort.Sort(compileUnitsByOffset(bi.compileUnits))
+	sort.Sort(compileUnitsByOffset(exe.compileUnits))
+	sort.Sort(compileUnitsByOffset(plugin.compileUnits))
+
+	for _, tc := range []struct {
+		image *Image
+		off   dwarf.Offset
+		cu    string
+	}{
+		{exe, 0x0b, "main"},
+		{exe, 0x150, "main"},
+		{exe, 0x250, "runtime"},
+		{plugin, 0x50, "plugin/a"},
+		{plugin, 0x150, "plugin/b"},
+		{plugin, 0x250, "plugin/b"},
+		{exe, 0x5, ""},
+	} {
+		c, err := bi.DIEContribution(tc.image, tc.off)
+		if tc.cu == "" {
+			if err == nil {
+				t.Errorf("%s %#x: expected error, got %v", tc.image.Path, tc.off, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %#x: %v", tc.image.Path, tc.off, err)
+			continue
+		}
+		if c.CompileUnit != tc.cu || c.ImagePath != tc.image.Path {
+			t.Errorf("%s %#x: got %s in %s, expected %s", tc.image.Path, tc.off, c.CompileUnit, c.ImagePath, tc.cu)
+		}
+	}
+}
+
+func TestGoroutineStackHighWater(t *testing.T) {
+	bi := NewBinaryInfo("linux", "amd64")
+	mem := &fakeMemory{base: 0x1000, buf: make([]byte, 0x1000)}
+	for i := 0x200; i < 0x400; i++ {
+		mem.buf[i] = 0xfd
+	}
+	binary.LittleEndian.PutUint64(mem.buf[0x400:], 0x1234)
+	binary.LittleEndian.PutUint64(mem.buf[0xf00:], 0x5678)
+
+	g := &G{Status: Gwaiting, SP: 0x1f00, stacklo: 0x1000, stackhi: 0x2000, variable: &Variable{bi: bi, mem: mem}}
+	hw, err := g.StackHighWater(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hw != 0xc00 {
+		t.Errorf("wrong high water mark %#x", hw)
+	}
+
+	// never lower than the current usage
+	g.SP = 0x1100
+	if hw, _ := g.StackHighWater(mem); hw != 0xf00 {
+		t.Errorf("wrong high water mark %#x", hw)
+	}
+
+	// unused stack
+	mem.buf = make([]byte, 0x1000)
+	g.SP = 0x2000
+	if hw, _ := g.StackHighWater(mem); hw != 0 {
+		t.Errorf("wrong high water mark for unused stack %#x", hw)
+	}
+}
+
+func TestGoroutineSPInStack(t *testing.T) {
+	g := &G{stacklo: 0x1000, stackhi: 0x2000}
+	for _, tc := range []struct {
+		sp  uint64
+		tgt bool
+	}{
+		{0xff8, false},
+		{0x1000, true},
+		{0x1ff8, true},
+		{0x2000, false},
+		{0, false},
+	} {
+		if out := g.SPInStack(tc.sp); out != tc.tgt {
+			t.Errorf("SPInStack(%#x) = %v, expected %v", tc.sp, out, tc.tgt)
+		}
+	}
+	if (&G{}).SPInStack(0) {
+		t.Errorf("SPInStack returned true for unknown stack bounds")
+	}
+
+	if runtime.GOARCH != "amd64" {
+		t.Skip("stack layout only valid on amd64")
+	}
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// The goroutine is stopped at the entry point of main.helloworld, the
+	// return address is at SP and the caller's SP is SP+8.
+	stacktrace := func(sp uint64) []Stackframe {
+		g := fakeGOnStack(t, bi, bi.LookupFunc["main.helloworld"].Entry, sp, bi.LookupFunc["main.main"].Entry+1)
+		frames, err := g.Stacktrace(10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frames
+	}
+	if frames := stacktrace(0x2f00); len(frames) != 2 || frames[1].Current.Fn == nil || frames[1].Current.Fn.Name != "main.main" {
+		t.Errorf("wrong stacktrace %v", frames)
+	}
+	// the caller's SP would be stack.hi, the return address is not unwound.
+	if frames := stacktrace(0x2ff8); len(frames) != 1 || !frames[0].Bottom {
+		t.Errorf("wrong stacktrace for caller outside of the stack %v", frames)
+	}
+}
+
+func TestGoroutineDumpStack(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("stack layout only valid on amd64")
+	}
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// stopped at the entry point of main.helloworld called by main.main
+	mainFn := bi.LookupFunc["main.main"]
+	helloworld := bi.LookupFunc["main.helloworld"]
+	goexit := bi.LookupFunc["runtime.goexit"]
+	g := fakeGOnStack(t, bi, helloworld.Entry, 0x2f00, mainFn.Entry+1, goexit.Entry+1)
+	g.ID = 7
+	g.WaitReason = "chan receive"
+	g.GoPC = mainFn.Entry + 2
+
+	var buf bytes.Buffer
+	if err := g.DumpStack(&buf, 10); err != nil {
+		t.Fatal(err)
+	}
+	f, l, _ := bi.PCToLine(helloworld.Entry)
+	mainf, mainl, _ := bi.PCToLine(mainFn.Entry)
+	tgt := fmt.Sprintf("goroutine 7 [chan receive]:\n"+
+		"main.helloworld()\n\t%s:%d +0x0\n"+
+		"main.main()\n\t%s:%d +0x1\n"+
+		"created by main.main\n\t%s:%d +0x2\n", f, l, mainf, mainl, mainf, mainl)
+	if out := buf.String(); out != tgt {
+		t.Errorf("expected:\n%s\ngot:\n%s", tgt, out)
+	}
+
+	buf.Reset()
+	g.Status = Grunnable
+	if err := g.DumpStack(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "goroutine 7 [runnable]:\nmain.helloworld()\n") || strings.Contains(out, "main.main()") {
+		t.Errorf("wrong output for depth 0:\n%s", out)
+	}
+
+	if frames, _ := g.Stacktrace(10, 0); len(frames) == 0 || frames[len(frames)-1].Call.Fn != goexit {
+		t.Errorf("runtime.goexit is not the last frame of the stack")
+	}
+
+	if err := (&G{ID: 8}).DumpStack(&buf, 10); err == nil {
+		t.Errorf("no error for goroutine without g struct")
+	}
+}
+
+func TestAsyncPreempted(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("stack layout only valid on amd64")
+	}
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	mainFn := bi.LookupFunc["main.main"]
+	asyncPreempt := bi.LookupFunc["runtime.asyncPreempt"]
+	if asyncPreempt == nil {
+		t.Skip("runtime.asyncPreempt not found")
+	}
+
+	if fakeGOnStack(t, bi, mainFn.Entry, 0x2f00).AsyncPreempted() {
+		t.Errorf("goroutine stopped in main.main reported as preempted")
+	}
+
+	mem, fields := fakeGMemory(t, bi)
+	mem.buf[fields["atomicstatus"].ByteOffset] = byte(Gwaiting)
+	mem.buf[fields["asyncSafePoint"].ByteOffset] = 1
+	if !parseFakeG(t, bi, mem).AsyncPreempted() {
+		t.Errorf("goroutine with asyncSafePoint set not reported as preempted")
+	}
+
+	g := fakeGOnStack(t, bi, asyncPreempt.Entry, 0x2f00, mainFn.Entry+1)
+	if !g.AsyncPreempted() {
+		t.Errorf("goroutine stopped in runtime.asyncPreempt not reported as preempted")
+	}
+
+	// old is runtime.g without the fields added in go1.14.
+	old := fakeGType(t, bi, func(field *godwarf.StructField) *godwarf.StructField {
+		if field.Name == "preemptStop" || field.Name == "asyncSafePoint" {
+			return nil
+		}
+		return field
+	})
+	oldg, err := newVariable("", fakeGAddr, old, bi, g.variable.mem).parseG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldg.AsyncPreempted() {
+		t.Errorf("goroutine without preemptStop field reported as preempted")
+	}
+}
+
+func TestReadPartial(t *testing.T) {
+	mem := &fakeMemory{base: 0x1000, buf: bytes.Repeat([]byte{0xaa}, 0x40)}
+	mem.holes = []memHole{{addr: 0x1008, size: 0x10}, {addr: 0x1030, size: 1}}
+
+	buf := make([]byte, 0x3c)
+	holes, err := readPartial(mem, buf, 0x1000, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := [][2]uint64{}
+	for _, hole := range holes {
+		out = append(out, [2]uint64{uint64(hole.addr), uint64(hole.size)})
+	}
+	if tgt := [][2]uint64{{0x1008, 0x10}, {0x1030, 0x8}}; !reflect.DeepEqual(out, tgt) {
+		t.Errorf("expected holes %#x got %#x", tgt, out)
+	}
+	if buf[0] != 0xaa || buf[0x8] != 0 || buf[0x17] != 0 || buf[0x18] != 0xaa || buf[0x30] != 0 {
+		t.Errorf("wrong buffer contents %x", buf)
+	}
+
+	pmem := &partialMemory{addr: 0x1000, buf: buf, holes: holes, mem: mem}
+	data := make([]byte, 8)
+	if _, err := pmem.ReadMemory(data, 0x1018); err != nil || data[0] != 0xaa {
+		t.Errorf("could not read outside of the holes: %v", err)
+	}
+	if _, err := pmem.ReadMemory(data, 0x1004); err == nil {
+		t.Errorf("no error reading a hole")
+	}
+
+	mem.holes = []memHole{{addr: 0x1000, size: 0x40}}
+	if _, err := readPartial(mem, buf, 0x1000, 8); err == nil {
+		t.Errorf("no error when nothing can be read")
+	}
+}
+
+func TestParseGPartial(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	_, fields := fakeGMemory(t, bi)
+	if fields["waitsince"] == nil || fields["goid"] == nil {
+		t.Skip("unexpected g struct layout")
+	}
+
+	parse := func(hole string) *G {
+		mem, _ := fakeGMemory(t, bi)
+		binary.LittleEndian.PutUint64(mem.buf[fields["goid"].ByteOffset:], 42)
+		binary.LittleEndian.PutUint64(mem.buf[fields["waitsince"].ByteOffset:], 1234)
+		mem.holes = []memHole{{addr: fakeGAddr + uintptr(fields[hole].ByteOffset), size: int(fields[hole].Type.Size())}}
+		return parseFakeG(t, bi, mem)
+	}
+
+	// optional field
+	g := parse("waitsince")
+	if g.Unreadable != nil || g.ID != 42 || g.WaitSince != 0 {
+		t.Errorf("wrong goroutine %d %d %v", g.ID, g.WaitSince, g.Unreadable)
+	}
+	if !reflect.DeepEqual(g.UnknownFields, []string{"waitsince"}) {
+		t.Errorf("wrong unknown fields %v", g.UnknownFields)
+	}
+
+	// required field
+	g = parse("goid")
+	if g.Unreadable == nil || g.WaitSince != 1234 {
+		t.Errorf("wrong goroutine %d %v", g.WaitSince, g.Unreadable)
+	}
+	if !reflect.DeepEqual(g.UnknownFields, []string{"goid"}) {
+		t.Errorf("wrong unknown fields %v", g.UnknownFields)
+	}
+}
+
+func TestGMemoryWrite(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	_, fields := fakeGMemory(t, bi)
+	if fields["waitsince"] == nil || fields["goid"] == nil {
+		t.Skip("unexpected g struct layout")
+	}
+	goidAddr := fakeGAddr + uintptr(fields["goid"].ByteOffset)
+
+	for _, partial := range []bool{false, true} {
+		mem, _ := fakeGMemory(t, bi)
+		binary.LittleEndian.PutUint64(mem.buf[fields["goid"].ByteOffset:], 42)
+		if partial {
+			mem.holes = []memHole{{addr: fakeGAddr + uintptr(fields["waitsince"].ByteOffset), size: int(fields["waitsince"].Type.Size())}}
+		}
+		g := parseFakeG(t, bi, mem)
+		if _, ok := g.variable.mem.(*partialMemory); ok != partial {
+			t.Fatalf("partial=%v: unexpected memory %T", partial, g.variable.mem)
+		}
+
+		// Writes through the memory of the g variable must be visible to
+		// later reads of the cached g struct.
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, 43)
+		if _, err := g.variable.mem.WriteMemory(goidAddr, buf); err != nil {
+			t.Fatal(err)
+		}
+		if goid, err := readUintRaw(g.variable.mem, goidAddr, 8); err != nil || goid != 43 {
+			t.Errorf("partial=%v: read %d %v after write", partial, goid, err)
+		}
+	}
+}
+
+func TestParseGDefensive(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	mem, fields := fakeGMemory(t, bi)
+	mem.buf[fields["atomicstatus"].ByteOffset] = byte(Gwaiting)
+	binary.LittleEndian.PutUint64(mem.buf[fields["goid"].ByteOffset:], 42)
+
+	// missing is runtime.g without a required field (gopc) and an optional
+	// one (syscallpc).
+	missing := fakeGType(t, bi, func(field *godwarf.StructField) *godwarf.StructField {
+		if field.Name == "gopc" || field.Name == "syscallpc" {
+			return nil
+		}
+		return field
+	})
+	g, err := newVariable("", fakeGAddr, missing, bi, mem).parseG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ID != 42 || g.Status != Gwaiting {
+		t.Errorf("wrong goroutine %d %d", g.ID, g.Status)
+	}
+	if g.Unreadable == nil || !strings.Contains(g.Unreadable.Error(), "gopc") {
+		t.Errorf("wrong error for missing field: %v", g.Unreadable)
+	}
+
+	// unreadable wait reason
+	mem.holes = []memHole{{addr: fakeGAddr + uintptr(fields["waitreason"].ByteOffset), size: int(fields["waitreason"].Type.Size())}}
+	g = parseFakeG(t, bi, mem)
+	if g.ID != 42 || g.Status != Gwaiting || g.WaitReason != "" {
+		t.Errorf("wrong goroutine %d %d %q", g.ID, g.Status, g.WaitReason)
+	}
+	if g.Unreadable == nil || !strings.Contains(g.Unreadable.Error(), "waitreason") {
+		t.Errorf("wrong error for unreadable field: %v", g.Unreadable)
+	}
+}
+
+func TestReadPointers32(t *testing.T) {
+	bi := NewBinaryInfo("linux", "amd64")
+	bi.Arch = arch32{bi.Arch}
+	mem := &fakeMemory{base: 0x1000, buf: make([]byte, 0x100)}
+	for i := range mem.buf {
+		mem.buf[i] = 0xff
+	}
+	put := func(addr, v uint32) {
+		binary.LittleEndian.PutUint32(mem.buf[addr-0x1000:], v)
+	}
+	put(0x1000, 3)      // runtime.allglen
+	put(0x1008, 0x2000) // runtime.allgs
+	put(0x1010, 0x3000) // string pointer
+	put(0x1014, 5)      // string length
+	put(0x1020, 0x4000) // funcval pointer
+
+	gcache := goroutineCache{allglenAddr: 0x1000, allgentryAddr: 0x1008}
+	allgptr, allglen, err := gcache.getRuntimeAllg(bi, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allgptr != 0x2000 || allglen != 3 {
+		t.Errorf("wrong allgs %#x %d", allgptr, allglen)
+	}
+
+	addr, strlen, err := readStringInfo(mem, bi.Arch, 0x1010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != 0x3000 || strlen != 5 {
+		t.Errorf("wrong string %#x %d", addr, strlen)
+	}
+	put(0x1014, 0xffffffff)
+	if _, _, err := readStringInfo(mem, bi.Arch, 0x1010); err == nil {
+		t.Errorf("no error for negative string length")
+	}
+
+	v := &Variable{bi: bi, mem: mem, Addr: 0x1020}
+	if fv := v.funcvalAddr(); fv != 0x4000 || v.Unreadable != nil {
+		t.Errorf("wrong funcval address %#x %v", fv, v.Unreadable)
+	}
+}
+
+func TestLiveGoroutines(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// Four goroutines, two of which are dead.
+	mem := fakeAllgs(t, bi, []uint64{Grunning, Gdead, Gwaiting, Gdead})
+
+	gtyp, _ := bi.findRuntimeGType()
+	for _, tc := range []struct {
+		name  string
+		fn    func(MemoryReadWriter, *BinaryInfo) ([]*G, error)
+		tgt   []int
+		reads int
+	}{
+		{"LiveGoroutines", LiveGoroutines, []int{1, 3}, 2},
+		{"AllGoroutines", AllGoroutines, []int{1, 2, 3, 4}, 4},
+		{"GoroutinesFromAllgs", func(mem MemoryReadWriter, bi *BinaryInfo) ([]*G, error) {
+			return GoroutinesFromAllgs(mem, bi, 1, 2)
+		}, []int{3}, 1},
+		{"IterateGoroutines", func(mem MemoryReadWriter, bi *BinaryInfo) ([]*G, error) {
+			var gs []*G
+			err := IterateGoroutines(mem, bi, func(g *G) bool {
+				gs = append(gs, g)
+				return true
+			})
+			return gs, err
+		}, []int{1, 3}, 2},
+	} {
+		mem.reads = nil
+		gs, err := tc.fn(mem, bi)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		ids := []int{}
+		for _, g := range gs {
+			ids = append(ids, g.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.tgt) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.tgt, ids)
+		}
+		if reads := mem.reads[int(gtyp.Size())]; reads != tc.reads {
+			t.Errorf("%s: expected %d goroutines to be parsed, got %d", tc.name, tc.reads, reads)
+		}
+	}
+
+	mem.reads = nil
+	counts, err := CountGoroutinesByStatus(mem, bi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgt := map[uint64]int{Grunning: 1, Gwaiting: 1, Gdead: 2}; !reflect.DeepEqual(counts, tgt) {
+		t.Errorf("CountGoroutinesByStatus: expected %v got %v", tgt, counts)
+	}
+	if reads := mem.reads[int(gtyp.Size())]; reads != 0 {
+		t.Errorf("CountGoroutinesByStatus: %d goroutines parsed", reads)
+	}
+	if live, total, err := TotalGoroutines(mem, bi); err != nil || live != 2 || total != 4 {
+		t.Errorf("TotalGoroutines: %d %d %v", live, total, err)
+	}
+}
+
+func TestGoroutineByID(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	mem := fakeAllgs(t, bi, []uint64{Grunning, Gdead, Gwaiting, Gwaiting})
+	gtyp, _ := bi.findRuntimeGType()
+	gsize := int(gtyp.Size())
+
+	var gcache goroutineCache
+	gcache.init(bi)
+
+	// The first lookup builds the index parsing every goroutine, and then
+	// parses the requested one again.
+	g, err := gcache.GoroutineByID(bi, mem, 3)
+	if err != nil || g == nil || g.ID != 3 || g.Status != Gwaiting {
+		t.Fatalf("GoroutineByID(3): %v %v", g, err)
+	}
+	if reads := mem.reads[gsize]; reads != 5 {
+		t.Errorf("expected 5 goroutines to be parsed building the index, got %d", reads)
+	}
+
+	// Subsequent lookups only parse the requested goroutine.
+	for _, id := range []int64{4, 2, 1} {
+		mem.reads = nil
+		g, err := gcache.GoroutineByID(bi, mem, id)
+		if err != nil || g == nil || int64(g.ID) != id {
+			t.Fatalf("GoroutineByID(%d): %v %v", id, g, err)
+		}
+		if reads := mem.reads[gsize]; reads != 1 {
+			t.Errorf("GoroutineByID(%d): expected 1 goroutine to be parsed, got %d", id, reads)
+		}
+	}
+	mem.reads = nil
+	if g, err := gcache.GoroutineByID(bi, mem, 4); err != nil || g == nil || mem.reads[gsize] != 0 {
+		t.Errorf("GoroutineByID(4) not cached: %v %v %d", g, err, mem.reads[gsize])
+	}
+	if g, err := gcache.GoroutineByID(bi, mem, 10); err != nil || g != nil {
+		t.Errorf("GoroutineByID(10): %v %v", g, err)
+	}
+	if mem.reads[gsize] != 0 {
+		t.Errorf("unknown goroutine lookup walked runtime.allgs")
+	}
+
+	// After clearing the cache the index is built again.
+	gcache.Clear()
+	mem.reads = nil
+	if g, err := gcache.GoroutineByID(bi, mem, 1); err != nil || g == nil || g.ID != 1 {
+		t.Fatalf("GoroutineByID(1): %v %v", g, err)
+	}
+	if reads := mem.reads[gsize]; reads != 5 {
+		t.Errorf("expected the index to be rebuilt after Clear, got %d reads", reads)
+	}
+
+	// Goroutine IDs are not truncated to int: a goroutine whose ID has
+	// the same low 32 bits as goroutine 3 is a different goroutine.
+	goid := int64(1)<<32 + 3
+	gcache.addGoroutine(&G{ID: int(int32(goid)), goid: goid})
+	if g, err := gcache.GoroutineByID(bi, mem, 3); err != nil || g == nil || g.ID64() != 3 || g.Status != Gwaiting {
+		t.Errorf("GoroutineByID(3): %v %v", g, err)
+	}
+	if g, err := gcache.GoroutineByID(bi, mem, goid); err != nil || g == nil || g.ID64() != goid {
+		t.Errorf("GoroutineByID(%#x): %v %v", goid, g, err)
+	}
+}
+
+func BenchmarkLiveGoroutines(b *testing.B) {
+	bi := loadTestBinaryInfo(b)
+	defer bi.Close()
+
+	statuses := make([]uint64, 1000)
+	for i := range statuses {
+		statuses[i] = Gwaiting
+	}
+	mem := fakeAllgs(b, bi, statuses)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LiveGoroutines(mem, bi); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGoroutineCache walks runtime.allgs filling a goroutine cache,
+// which is cleared at every iteration like when the target resumes, so
+// that the buffers of the g structs are reused. Run with -benchmem.
+func BenchmarkGoroutineCache(b *testing.B) {
+	bi := loadTestBinaryInfo(b)
+	defer bi.Close()
+
+	statuses := make([]uint64, 1000)
+	for i := range statuses {
+		statuses[i] = Gwaiting
+	}
+	mem := fakeAllgs(b, bi, statuses)
+	var gcache goroutineCache
+	gcache.init(bi)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		allgs, err := gcache.allgs(bi, mem)
+		if err != nil {
+			b.Fatal(err)
+		}
+		allgs.each(0, allgs.allglen, true, func(g *G) bool {
+			gcache.addGoroutine(g)
+			return true
+		})
+		gcache.Clear()
+	}
+}
+
+func TestGoroutineCacheClearMemory(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	mem := fakeAllgs(t, bi, []uint64{Gwaiting})
+	var gcache goroutineCache
+	gcache.init(bi)
+	g, err := gcache.GoroutineByID(bi, mem, 1)
+	if err != nil || g == nil {
+		t.Fatalf("GoroutineByID(1): %v %v", g, err)
+	}
+	typ, _ := bi.findRuntimeGType()
+	_, fields := fakeGMemory(t, bi)
+	goidAddr := g.variable.Addr + uintptr(fields["goid"].ByteOffset)
+
+	// While the g struct is cached changes to the target's memory are not
+	// seen, once the cache is cleared they are.
+	binary.LittleEndian.PutUint64(mem.buf[goidAddr-mem.base:], 2)
+	if goid, _ := readUintRaw(g.variable.mem, goidAddr, 8); goid != 1 {
+		t.Errorf("expected cached goid 1, got %d", goid)
+	}
+	gcache.Clear()
+	mem.reads = nil
+	if goid, _ := readUintRaw(g.variable.mem, goidAddr, 8); goid != 2 {
+		t.Errorf("expected goid 2 after Clear, got %d", goid)
+	}
+	if mem.reads[8] != 1 || mem.reads[int(typ.Size())] != 0 {
+		t.Errorf("wrong reads after Clear %v", mem.reads)
+	}
+}
+
+func TestGFieldAlternates(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	typ, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, fields := fakeGMemory(t, bi)
+	mem.buf[fields["atomicstatus"].ByteOffset] = byte(Gwaiting)
+
+	// renamed is runtime.g with atomicstatus called status, like in go1.3
+	// and earlier.
+	renamed := fakeGType(t, bi, func(field *godwarf.StructField) *godwarf.StructField {
+		if field.Name != "atomicstatus" {
+			return field
+		}
+		fieldCopy := *field
+		fieldCopy.Name = "status"
+		return &fieldCopy
+	})
+
+	for _, typ := range []godwarf.Type{typ, renamed} {
+		g, err := newVariable("", fakeGAddr, typ, bi, mem).parseG()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Status != Gwaiting {
+			t.Errorf("%s: wrong status %d", typ, g.Status)
+		}
+	}
+}
+
+func TestLockedThread(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	typ, err := bi.findRuntimeGType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtyp, err := bi.findType("runtime.m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var procidOff int64 = -1
+	for _, field := range resolveTypedef(mtyp).(*godwarf.StructType).Field {
+		if field.Name == "procid" {
+			procidOff = field.ByteOffset
+		}
+	}
+	if procidOff < 0 {
+		t.Fatal("could not find runtime.m.procid")
+	}
+
+	// The m struct is placed right after the stack of the fake goroutine.
+	const maddr = fakeStackHi
+	mem, fields := fakeGMemory(t, bi)
+	mem.buf = append(mem.buf, make([]byte, mtyp.Size())...)
+	binary.LittleEndian.PutUint64(mem.buf[maddr-fakeGAddr+procidOff:], 1234)
+
+	// pointer is runtime.g where lockedm is a *runtime.m, like in go1.8 and
+	// earlier, instead of a muintptr.
+	pointer := fakeGType(t, bi, func(field *godwarf.StructField) *godwarf.StructField {
+		if field.Name != "lockedm" {
+			return field
+		}
+		fieldCopy := *field
+		fieldCopy.Type = &godwarf.PtrType{
+			CommonType: godwarf.CommonType{ByteSize: 8, Name: "*runtime.m", ReflectKind: reflect.Ptr},
+			Type:       mtyp,
+		}
+		return &fieldCopy
+	})
+
+	for _, typ := range []godwarf.Type{typ, pointer} {
+		binary.LittleEndian.PutUint64(mem.buf[fields["lockedm"].ByteOffset:], 0)
+		g, err := newVariable("", fakeGAddr, typ, bi, mem).parseG()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tid, locked := g.LockedThread(); locked {
+			t.Errorf("%s: goroutine locked to thread %d", typ, tid)
+		}
+
+		binary.LittleEndian.PutUint64(mem.buf[fields["lockedm"].ByteOffset:], maddr)
+		g, err = newVariable("", fakeGAddr, typ, bi, mem).parseG()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tid, locked := g.LockedThread(); !locked || tid != 1234 {
+			t.Errorf("%s: wrong locked thread %d %v", typ, tid, locked)
+		}
+	}
+}
+
+func TestGStatusField(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	_, fields := fakeGMemory(t, bi)
+	status := fields["atomicstatus"]
+	if value := fields["atomicstatus.value"]; value != nil {
+		status = value
+	}
+	off, size, err := gStatusField(bi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != uint64(status.ByteOffset) || size != status.Type.Size() {
+		t.Errorf("wrong status field %#x %d, expected %#x %d", off, size, status.ByteOffset, status.Type.Size())
+	}
+
+	u32 := &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 4, Name: "uint32", ReflectKind: reflect.Uint32}}}
+	wrapper := func(fields ...*godwarf.StructField) *godwarf.StructType {
+		return &godwarf.StructType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "runtime/internal/atomic.Uint32", ReflectKind: reflect.Struct}, StructName: "runtime/internal/atomic.Uint32", Kind: "struct", Field: fields}
+	}
+	for _, tc := range []struct {
+		name  string
+		field *godwarf.StructField
+		off   uint64
+		size  int64
+		err   bool
+	}{
+		{"plain", &godwarf.StructField{Name: "atomicstatus", ByteOffset: 0x10, Type: u32}, 0x10, 4, false},
+		{"go1.3", &godwarf.StructField{Name: "status", ByteOffset: 0x10, Type: u32}, 0x10, 4, false},
+		{"wrapper", &godwarf.StructField{Name: "atomicstatus", ByteOffset: 0x10, Type: wrapper(
+			&godwarf.StructField{Name: "_", ByteOffset: 0, Type: u32},
+			&godwarf.StructField{Name: "value", ByteOffset: 4, Type: u32})}, 0x14, 4, false},
+		{"wrapper without value", &godwarf.StructField{Name: "atomicstatus", ByteOffset: 0x10, Type: wrapper(
+			&godwarf.StructField{Name: "v", ByteOffset: 0, Type: u32})}, 0, 0, true},
+	} {
+		bi.runtimeGType = &godwarf.StructType{CommonType: godwarf.CommonType{ByteSize: 0x20, Name: "runtime.g", ReflectKind: reflect.Struct}, StructName: "runtime.g", Kind: "struct", Field: []*godwarf.StructField{tc.field}}
+		off, size, err := gStatusField(bi)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: no error", tc.name)
+			}
+			continue
+		}
+		if err != nil || off != tc.off || size != tc.size {
+			t.Errorf("%s: got %#x %d %v, expected %#x %d", tc.name, off, size, err, tc.off, tc.size)
+		}
+	}
+}
+
+func TestGoroutineIsMainStartPC(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	for _, tc := range []struct {
+		id     int
+		fn     string
+		isMain bool
+	}{
+		{1, "runtime.main", true},
+		{1, "main.testgoroutine", false},
+		{7, "runtime.main", true}, // ID is not used
+	} {
+		fn := bi.LookupFunc[tc.fn]
+		if fn == nil {
+			t.Fatalf("could not find %s", tc.fn)
+		}
+		g := &G{ID: tc.id, StartPC: fn.Entry}
+		if isMain := g.IsMain(bi); isMain != tc.isMain {
+			t.Errorf("%d %s: got %v expected %v", tc.id, tc.fn, isMain, tc.isMain)
+		}
+	}
+	if (&G{ID: 1}).IsMain(bi) {
+		t.Errorf("goroutine without start function or stack reported as main")
+	}
+}
+
+func TestProducers(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	producers := bi.Producers()
+	t.Logf("producers: %q", producers)
+	found := false
+	seen := map[string]bool{}
+	for _, producer := range producers {
+		if seen[producer] {
+			t.Errorf("duplicate producer %q", producer)
+		}
+		seen[producer] = true
+		if producer == bi.Producer() {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("producer of the go compile unit %q not found", bi.Producer())
+	}
+}
+
+// TestGoroutineFields checks the accessors of G that decode a single field
+// of runtime.g.
+func TestGoroutineFields(t *testing.T) {
+	if s := (GFlagPreemptStop | GFlagRaceIgnore).String(); s != "preemptStop|raceignore" {
+		t.Errorf("wrong string %q", s)
+	}
+	if s := GFlags(0).String(); s != "" {
+		t.Errorf("wrong string %q", s)
+	}
+	if _, err := (&G{}).RawField("gopc"); err == nil {
+		t.Errorf("no error reading field of goroutine without runtime.g")
+	}
+	if id := (&G{ID: 5}).ID64(); id != 5 {
+		t.Errorf("wrong ID64 %d", id)
+	}
+	goid := int64(1)<<40 + 3
+	if id := (&G{ID: int(int32(goid)), goid: goid}).ID64(); id != goid {
+		t.Errorf("wrong ID64 %d", id)
+	}
+
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	mainFn, morestack := bi.LookupFunc["main.main"], bi.LookupFunc["runtime.morestack"]
+	if mainFn == nil || morestack == nil {
+		t.Fatal("could not find main.main or runtime.morestack")
+	}
+
+	// zero value of every field
+	g := parseFakeG(t, bi, &fakeMemory{})
+	if ctxt, ok := g.ClosureContext(); ok {
+		t.Errorf("closure context returned for nil ctxt %#x", ctxt)
+	}
+	if loc := g.SyscallLoc(); loc.PC != g.CurrentLoc.PC {
+		t.Errorf("syscall location returned for goroutine not in a syscall %#v", loc)
+	}
+	if g.Flags != 0 || g.InStackGrowth() {
+		t.Errorf("wrong flags %v or stack growth %v", g.Flags, g.InStackGrowth())
+	}
+	// Without GODEBUG=tracebackancestors the ancestors field is nil.
+	if as, err := g.Ancestors(10); err != nil || as == nil || len(as) != 0 {
+		t.Errorf("expected empty list of ancestors, got %#v %v", as, err)
+	}
+
+	mem, fields := fakeGMemory(t, bi)
+	put := func(name string, val uint64) {
+		if fields[name] == nil {
+			t.Fatalf("no %s field", name)
+		}
+		binary.LittleEndian.PutUint64(mem.buf[fields[name].ByteOffset:], val)
+	}
+	mem.buf[fields["atomicstatus"].ByteOffset] = byte(Gsyscall)
+	put("sched.pc", morestack.Entry+1)
+	put("sched.ctxt", 0xc000010020)
+	put("syscallpc", mainFn.Entry)
+	put("syscallsp", 0xc000123450)
+	put("gopc", 0x4567)
+	mem.buf[fields["throwsplit"].ByteOffset] = 1
+	g = parseFakeG(t, bi, mem)
+
+	if ctxt, ok := g.ClosureContext(); !ok || ctxt != 0xc000010020 {
+		t.Errorf("wrong closure context %#x %v", ctxt, ok)
+	}
+	if g.SyscallPC != mainFn.Entry || g.SyscallSP != 0xc000123450 {
+		t.Errorf("wrong syscall registers %#x %#x", g.SyscallPC, g.SyscallSP)
+	}
+	if loc := g.SyscallLoc(); loc.PC != mainFn.Entry || loc.Fn != mainFn {
+		t.Errorf("wrong syscall location %#v", loc)
+	}
+	if g.Flags != GFlagThrowSplit {
+		t.Errorf("wrong flags %v", g.Flags)
+	}
+	if !g.InStackGrowth() {
+		t.Errorf("goroutine stopped in runtime.morestack not in stack growth")
+	}
+	v, err := g.RawField("gopc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := constant.Uint64Val(v.Value); v.Unreadable != nil || n != 0x4567 {
+		t.Errorf("wrong value for gopc %v (%v)", v.Value, v.Unreadable)
+	}
+	if _, err := g.RawField("nonexistent"); err == nil {
+		t.Errorf("no error reading nonexistent field")
+	}
+}
+
+func TestGoroutinePanicsNil(t *testing.T) {
+	// Goroutines without a g struct, like the ones of threads not running
+	// Go code, have no panics.
+	if ps, err := (&G{}).Panics(); ps != nil || err != nil {
+		t.Errorf("expected no panics, got %v %v", ps, err)
+	}
+	unreadable := errors.New("unreadable")
+	if _, err := (&G{Unreadable: unreadable}).Panics(); err != unreadable {
+		t.Errorf("expected %v got %v", unreadable, err)
+	}
+
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+	if ps, err := parseFakeG(t, bi, &fakeMemory{}).Panics(); ps != nil || err != nil {
+		t.Errorf("expected no panics, got %v %v", ps, err)
+	}
+}
+
+func TestCheckGStructOffset(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("test only valid on linux/amd64")
+	}
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	if known, ok := knownGStructOffset(bi); ok && bi.GStructOffset() != known {
+		t.Fatalf("g struct offset %#x does not match known offset %#x", bi.GStructOffset(), known)
+	}
+	bi.gStructOffsetTLSG = false
+	bi.gStructOffset = knownGStructOffsets["linux/amd64"]
+
+	_, fields := fakeGMemory(t, bi)
+	typ, _ := bi.findRuntimeGType()
+	statusOff := fields["atomicstatus"].ByteOffset
+
+	// The TLS base is at 0x10000, the g pointer at 0xfff8 points to a valid
+	// g struct, the word before it to a g struct with an invalid status.
+	const tls = 0x10000
+	gsize := uint64(typ.Size())
+	mem := &fakeMemory{base: tls - 0x10, buf: make([]byte, 0x10+2*gsize)}
+	binary.LittleEndian.PutUint64(mem.buf[0x8:], tls)
+	binary.LittleEndian.PutUint64(mem.buf[0x0:], tls+gsize)
+	binary.LittleEndian.PutUint32(mem.buf[0x10+gsize+uint64(statusOff):], 0x77)
+
+	regs := &tlsRegisters{tls: tls}
+	if err := checkGStructOffset(regs, bi, mem); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bi.gStructOffset = ^uint64(16) + 1 // -16
+	err := checkGStructOffset(regs, bi, mem)
+	if err == nil {
+		t.Fatal("no error for wrong g struct offset")
+	}
+	t.Logf("%v", err)
+	if !strings.Contains(err.Error(), "expected 0xfffffffffffffff8") {
+		t.Errorf("error does not contain the known offset: %v", err)
+	}
+	// Externally linked programs and other architectures have no known
+	// offset.
+	bi.gStructOffsetTLSG = true
+	if err := checkGStructOffset(regs, bi, mem); err == nil || strings.Contains(err.Error(), "expected") {
+		t.Errorf("wrong error for externally linked program: %v", err)
+	}
+	bi.gStructOffsetTLSG = false
+	if _, ok := knownGStructOffset(NewBinaryInfo("linux", "arm64")); ok {
+		t.Errorf("known g struct offset for linux/arm64")
+	}
+}
+
+func TestGetGVariableFromRegs(t *testing.T) {
+	// Without any image the goroutine can not be searched by stack pointer.
+	regs := &tlsRegisters{tls: 0x10000, sp: 0x2f00}
+	unreadable := &fakeMemory{err: errors.New("unreadable")}
+	if _, err := getGVariableFromRegs(regs, NewBinaryInfo("linux", "amd64"), unreadable, true); err == nil {
+		t.Error("no error for unreadable thread local storage")
+	}
+
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test only valid on amd64")
+	}
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// On windows the TEB is at regs.TLS(), its ArbitraryUserPointer field
+	// points to the TLS slot containing the address of the g struct.
+	const teb, slot = 0x2800, 0x2900
+	bi.Arch = AMD64Arch("windows")
+	bi.gStructOffset = knownGStructOffsets["windows/amd64"]
+	mem, fields := fakeGMemory(t, bi)
+	binary.LittleEndian.PutUint64(mem.buf[fields["goid"].ByteOffset:], 5)
+	binary.LittleEndian.PutUint64(mem.buf[teb+0x28-fakeGAddr:], slot)
+	binary.LittleEndian.PutUint64(mem.buf[slot-fakeGAddr:], fakeGAddr)
+	gvar, err := getGVariableFromRegs(&tlsRegisters{tls: teb}, bi, mem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := gvar.parseG(); err != nil || g.ID != 5 {
+		t.Errorf("wrong goroutine %v %v", g, err)
+	}
+	mem.holes = []memHole{{addr: slot, size: 8}}
+	if _, err := getGVariableFromRegs(&tlsRegisters{tls: teb}, bi, mem, false); err == nil {
+		t.Errorf("no error for unreadable TLS slot")
+	}
+
+	// If the TLS can not be read the goroutine of core files is searched by
+	// stack pointer.
+	bi.Arch = AMD64Arch(runtime.GOOS)
+	mem = fakeAllgs(t, bi, []uint64{Gwaiting, Grunning})
+	var gcache goroutineCache
+	gcache.init(bi)
+	allgptr, _, err := gcache.getRuntimeAllg(bi, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 2; i++ {
+		gaddr, _ := readUintRaw(mem, uintptr(allgptr+8*i), 8)
+		binary.LittleEndian.PutUint64(mem.buf[gaddr-uint64(mem.base)+uint64(fields["stack.lo"].ByteOffset):], 0x2000)
+		binary.LittleEndian.PutUint64(mem.buf[gaddr-uint64(mem.base)+uint64(fields["stack.hi"].ByteOffset):], 0x3000)
+	}
+	mem.holes = []memHole{{addr: uintptr(regs.tls - 0x100), size: 0x200}}
+	if _, err := getGVariableFromRegs(regs, bi, mem, false); err == nil {
+		t.Errorf("goroutine searched by stack pointer for a live process")
+	}
+	gvar, err = getGVariableFromRegs(regs, bi, mem, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := gvar.parseG(); err != nil || g.ID != 2 {
+		t.Errorf("wrong goroutine %v %v", g, err)
+	}
+}
+
+func TestGetGVariableFromRegsARM64(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	// Programs linked with cgo save g at runtime.tls_g, X28 is only valid
+	// while executing Go code.
+	const tp, bogusG = 0x2800, 0x2f00
+	bi.Arch = ARM64Arch("linux")
+	bi.gStructOffset = 0x10
+	bi.gStructOffsetTLSG = true
+	mem, fields := fakeGMemory(t, bi)
+	binary.LittleEndian.PutUint64(mem.buf[fields["goid"].ByteOffset:], 5)
+	binary.LittleEndian.PutUint64(mem.buf[tp+0x10-fakeGAddr:], fakeGAddr)
+
+	fn := bi.LookupFunc["main.main"]
+	if fn == nil {
+		t.Fatal("could not find main.main")
+	}
+	for _, tc := range []struct {
+		name  string
+		pc    uint64
+		gaddr uint64
+	}{
+		{"C code", 0, bogusG},
+		{"Go code", fn.Entry, fakeGAddr},
+	} {
+		regs := &tlsRegisters{tls: tp, pc: tc.pc, gaddr: tc.gaddr, hasgaddr: true}
+		gvar, err := getGVariableFromRegs(regs, bi, mem, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if g, err := gvar.parseG(); err != nil || g.ID != 5 {
+			t.Errorf("%s: wrong goroutine %v %v", tc.name, g, err)
+		}
+	}
+
+	// Without runtime.tls_g the register is the only way to find g.
+	bi.gStructOffsetTLSG = false
+	regs := &tlsRegisters{tls: tp, gaddr: fakeGAddr, hasgaddr: true}
+	gvar, err := getGVariableFromRegs(regs, bi, mem, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := gvar.parseG(); err != nil || g.ID != 5 {
+		t.Errorf("wrong goroutine %v %v", g, err)
+	}
+}
+
+func TestCallSitePC(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	fn := bi.LookupFunc["main.main"]
+	if fn == nil {
+		t.Fatal("could not find main.main")
+	}
+	for _, tc := range []struct{ pc, tgt uint64 }{
+		{fn.Entry, fn.Entry},
+		{fn.Entry + 5, fn.Entry + 4},
+		{1, 1},
+	} {
+		if out := CallSitePC(bi, tc.pc); out != tc.tgt {
+			t.Errorf("CallSitePC(%#x) = %#x, expected %#x", tc.pc, out, tc.tgt)
+		}
+	}
+}
+
+func TestGoroutineBlockedByGC(t *testing.T) {
+	bi := loadTestBinaryInfo(t)
+	defer bi.Close()
+
+	zero := &fakeMemory{}
+	scope := globalScope(bi, bi.Images[0], zero)
+	sched, err := scope.findGlobal("runtime", "sched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcwaiting, err := sched.structMember("gcwaiting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcphase, err := scope.findGlobal("runtime", "gcphase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stw := &fakeMemory{base: gcwaiting.Addr, buf: []byte{1, 0, 0, 0}}
+	marktermination := &fakeMemory{base: gcphase.Addr, buf: []byte{gcphaseMarkTermination, 0, 0, 0}}
+
+	tests := []struct {
+		name string
+		g    G
+		mem  MemoryReadWriter
+		tgt  bool
+	}{
+		{"assist", G{Status: Gwaiting, WaitReasonCode: WaitReasonGCAssistWait}, zero, true},
+		{"assist marking", G{Status: Gwaiting, WaitReasonCode: WaitReasonGCAssistMarking}, zero, true},
+		{"chan receive", G{Status: Gwaiting, WaitReasonCode: WaitReasonChanReceive}, stw, false},
+		{"runnable", G{Status: Grunnable}, zero, false},
+		{"runnable stw", G{Status: Grunnable}, stw, true},
+		{"runnable mark termination", G{Status: Grunnable}, marktermination, true},
+		{"preempted stw", G{Status: Gwaiting, WaitReasonCode: WaitReasonPreempted}, stw, true},
+		{"running stw", G{Status: Grunning}, stw, false},
+	}
+	for _, tc := range tests {
+		got, err := tc.g.BlockedByGC(tc.mem, bi)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.tgt {
+			t.Errorf("%s: BlockedByGC() = %v, expected %v", tc.name, got, tc.tgt)
+		}
+	}
+
+	// Runnable goroutines need the runtime variables of the executable.
+	if _, err := (&G{Status: Grunnable}).BlockedByGC(zero, NewBinaryInfo("linux", "amd64")); err == nil {
+		t.Errorf("no error without an executable")
+	}
+}
+
+func TestResolveDebugFile(t *testing.T) {
+	fixture := protest.BuildFixture("testnextprog", 0)
+	dir, err := ioutil.TempDir("", "debuginfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plainDir := filepath.Join(dir, "debug")
+	buildIDDir := filepath.Join(dir, "build-id")
+	if err := os.MkdirAll(filepath.Join(buildIDDir, "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(buildIDDir, "ab", "cdef.debug"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bi := NewBinaryInfo(runtime.GOOS, runtime.GOARCH)
+	bi.debugInfoDirectories = []string{plainDir, buildIDDir}
+	plainCandidate := plainDir + "/" + filepath.Base(fixture.Path) + ".debug"
+
+	path, tried, err := bi.ResolveDebugFile(fixture.Path, "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgt := buildIDDir + "/ab/cdef.debug"; path != tgt {
+		t.Errorf("expected %q got %q", tgt, path)
+	}
+	if tgt := []string{plainCandidate, path}; !reflect.DeepEqual(tried, tgt) {
+		t.Errorf("expected %q got %q", tgt, tried)
+	}
+
+	// not found, the fixture has no .gnu_debuglink section
+	path, tried, err = bi.ResolveDebugFile(fixture.Path, "ff0123")
+	if err != ErrNoDebugInfoFound {
+		t.Errorf("expected %v got %q, %v", ErrNoDebugInfoFound, path, err)
+	}
+	if tgt := []string{plainCandidate, buildIDDir + "/ff/0123.debug"}; !reflect.DeepEqual(tried, tgt) {
+		t.Errorf("expected %q got %q", tgt, tried)
+	}
+}
+
+func TestSeparateDebugInfoDebuginfod(t *testing.T) {
+	exe, err := elf.Open("/bin/ls")
+	if err != nil {
+		t.Skip("no /bin/ls")
+	}
+	defer exe.Close()
+	if _, _, err := parseBuildID(exe); err != nil {
+		t.Skip("/bin/ls has no build ID")
+	}
+	dir, err := ioutil.TempDir("", "debuginfod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	for key, val := range map[string]string{debuginfod.URLsEnv: srv.URL, "XDG_CACHE_HOME": dir} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, val)
+	}
+
+	bi := NewBinaryInfo("linux", "amd64")
+	bi.debugInfoDirectories = []string{dir}
+	for _, tc := range []struct {
+		index    int
+		requests int
+	}{
+		{1, 0}, // shared libraries never query the debuginfod servers
+		{0, 1},
+	} {
+		requests = 0
+		image := &Image{Path: "/bin/ls", index: tc.index}
+		if _, _, err := bi.openSeparateDebugInfo(image, exe, bi.debugInfoDirectories); err == nil {
+			t.Errorf("image %d: found separate debug info", tc.index)
+		}
+		if requests != tc.requests {
+			t.Errorf("image %d: %d requests to debuginfod, expected %d", tc.index, requests, tc.requests)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"go/constant"
+	"io"
+	"reflect"
 
 	"github.com/go-delve/delve/pkg/dwarf/frame"
 	"github.com/go-delve/delve/pkg/dwarf/op"
@@ -123,6 +125,13 @@ func (g *G) stackIterator(opts StacktraceOptions) (*stackIterator, error) {
 			g.variable.bi.Arch.RegistersToDwarfRegisters(so.StaticBase, regs),
 			g.stackhi, stkbar, g.stkbarPos, g, opts), nil
 	}
+	if g.StackBeingCopied() {
+		return nil, ErrStackBeingCopied
+	}
+	if err := g.checkStackBounds(); err != nil {
+		// the g struct is corrupted, don't unwind garbage
+		return nil, err
+	}
 	so := g.variable.bi.PCToImage(g.PC)
 	return newStackIterator(
 		g.variable.bi, g.variable.mem,
@@ -163,6 +172,112 @@ func (g *G) Stacktrace(depth int, opts StacktraceOptions) ([]Stackframe, error)
 	return frames, nil
 }
 
+// StackDepthExceeds returns true if the stack of the goroutine has more
+// than n frames (including inlined calls), i.e. if Stacktrace(n-1, 0)
+// would return a truncated stack. The stack is only unwound up to frame
+// n+1.
+func (g *G) StackDepthExceeds(n int) (bool, error) {
+	if n < 0 {
+		return false, errors.New("negative stack depth")
+	}
+	frames, err := g.Stacktrace(n, 0)
+	if err != nil {
+		return false, err
+	}
+	cnt := 0
+	for i := range frames {
+		if frames[i].Err == nil {
+			cnt++
+		}
+	}
+	return cnt > n, nil
+}
+
+// FrameAt returns the n-th frame of the goroutine's stack, counting from
+// the top-most frame (n = 0). The returned frame contains the register
+// context and CFA needed to evaluate variables in it.
+// An error is returned if the stack has n frames or fewer.
+func (g *G) FrameAt(n int) (*Stackframe, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("frame %d out of range", n)
+	}
+	frames, err := g.Stacktrace(n, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n >= len(frames) {
+		return nil, fmt.Errorf("frame %d out of range, stack has %d frames", n, len(frames))
+	}
+	if frames[n].Err != nil {
+		return nil, frames[n].Err
+	}
+	return &frames[n], nil
+}
+
+// DumpStack writes the stack of the goroutine to w, at most depth frames,
+// in the format used by the runtime for tracebacks and by goroutine
+// profiles with debug=2:
+//
+//	goroutine 1 [chan receive]:
+//	main.f(...)
+//		/path/to/main.go:10
+//	main.main()
+//		/path/to/main.go:20 +0x25
+//	created by main.init
+//		/path/to/main.go:5 +0x1f
+//
+// Arguments are not printed: inlined calls are followed by "(...)", like
+// the runtime does, other calls by "()". The PC offset of inlined calls is
+// omitted. Like in runtime tracebacks the runtime.goexit frame at the
+// bottom of goroutine stacks is not shown.
+func (g *G) DumpStack(w io.Writer, depth int) error {
+	if g.variable == nil {
+		if g.Unreadable != nil {
+			return g.Unreadable
+		}
+		return fmt.Errorf("could not read stack of goroutine %d", g.ID)
+	}
+	frames, err := g.Stacktrace(depth, 0)
+	if err != nil {
+		return err
+	}
+	status := goroutineStatusString(g.Status)
+	if g.Status == Gwaiting && g.WaitReason != "" {
+		status = g.WaitReason
+	}
+	if _, locked := g.LockedThread(); locked {
+		status += ", locked to thread"
+	}
+	fmt.Fprintf(w, "goroutine %d [%s]:\n", g.ID, status)
+	for i := range frames {
+		frame := &frames[i]
+		if frame.Err != nil {
+			fmt.Fprintf(w, "...error: %v\n", frame.Err)
+			break
+		}
+		if frame.Call.Fn != nil && frame.Call.Fn.Name == "runtime.goexit" {
+			continue
+		}
+		dumpStackFrame(w, frame.Call.Fn, frame.Inlined, frame.Call.File, frame.Call.Line, frame.Current.PC)
+	}
+	if loc := g.Go(); loc.Fn != nil {
+		fmt.Fprintf(w, "created by %s\n\t%s:%d +%#x\n", loc.Fn.Name, loc.File, loc.Line, loc.PC-loc.Fn.Entry)
+	}
+	return nil
+}
+
+// dumpStackFrame writes a single frame for DumpStack.
+func dumpStackFrame(w io.Writer, fn *Function, inlined bool, file string, line int, pc uint64) {
+	switch {
+	case fn == nil:
+		fmt.Fprintf(w, "?()\n\t?:0 pc=%#x\n", pc)
+	case inlined:
+		fmt.Fprintf(w, "%s(...)\n\t%s:%d\n", fn.Name, file, line)
+	default:
+		fmt.Fprintf(w, "%s()\n\t%s:%d +%#x\n", fn.Name, file, line, pc-fn.Entry)
+	}
+}
+
 // NullAddrError is an error for a null address.
 type NullAddrError struct{}
 
@@ -262,6 +377,14 @@ func (it *stackIterator) Next() bool {
 		return true
 	}
 
+	if !it.systemstack && it.g != nil && it.g.stackhi != 0 && !it.g.SPInStack(callFrameRegs.SP()) {
+		// The stack pointer of the caller is outside of the goroutine stack,
+		// the saved registers we just read are garbage: stop here instead of
+		// unwinding them.
+		it.atend = true
+		return true
+	}
+
 	it.top = false
 	it.pc = it.frame.Ret
 	it.regs = callFrameRegs
@@ -328,13 +451,26 @@ func (it *stackIterator) newStackframe(ret, retaddr uint64) Stackframe {
 			// these frames are inserted by runtime.systemstack and there is no CALL
 			// instruction to look for at pc - 1
 		default:
-			r.lastpc = it.pc - 1
-			r.Call.File, r.Call.Line = r.Current.Fn.cu.lineInfo.PCToLine(r.Current.Fn.Entry, it.pc-1)
+			r.lastpc = CallSitePC(it.bi, it.pc)
+			r.Call.File, r.Call.Line = r.Current.Fn.cu.lineInfo.PCToLine(r.Current.Fn.Entry, r.lastpc)
 		}
 	}
 	return r
 }
 
+// CallSitePC converts the return address returnPC into an address inside
+// the CALL instruction that pushed it, so that it is attributed to the
+// line of the call. This mimics what runtime/traceback.go does.
+// If returnPC is the entry point of its function, or isn't inside any
+// function, it is returned unchanged: there is no CALL instruction before
+// it.
+func CallSitePC(bi *BinaryInfo, returnPC uint64) uint64 {
+	if fn := bi.PCToFunc(returnPC); fn != nil && returnPC > fn.Entry {
+		return returnPC - 1
+	}
+	return returnPC
+}
+
 func (it *stackIterator) stacktrace(depth int) ([]Stackframe, error) {
 	if depth < 0 {
 		return nil, errors.New("negative maximum stack depth")
@@ -495,13 +631,13 @@ func (it *stackIterator) executeFrameRegRule(regnum uint64, rule frame.DWRule, c
 	case frame.RuleRegister:
 		return it.regs.Reg(rule.Reg), nil
 	case frame.RuleExpression:
-		v, _, err := op.ExecuteStackProgram(it.regs, rule.Expression)
+		v, _, err := op.ExecuteStackProgram(it.regs, rule.Expression, it.bi.Arch.PtrSize())
 		if err != nil {
 			return nil, err
 		}
 		return it.readRegisterAt(regnum, uint64(v))
 	case frame.RuleValExpression:
-		v, _, err := op.ExecuteStackProgram(it.regs, rule.Expression)
+		v, _, err := op.ExecuteStackProgram(it.regs, rule.Expression, it.bi.Arch.PtrSize())
 		if err != nil {
 			return nil, err
 		}
@@ -540,6 +676,8 @@ type Defer struct {
 	DeferredPC uint64 // Value of field _defer.fn.fn, the deferred function
 	DeferPC    uint64 // PC address of instruction that added this defer
 	SP         uint64 // Value of SP register when this function was deferred (this field gets adjusted when the stack is moved to match the new stack space)
+	Started    bool   // Value of field _defer.started, the deferred call has started running
+	Heap       bool   // Value of field _defer.heap, the defer record is allocated on the heap
 	link       *Defer // Next deferred function
 	argSz      int64
 
@@ -591,6 +729,12 @@ func (g *G) readDefers(frames []Stackframe) {
 	}
 }
 
+// load decodes the _defer struct backing d.
+// The layout of _defer changed several times between Go 1.11 and Go 1.22:
+// the siz field was removed in Go 1.17, fn changed from *funcval to func()
+// in Go 1.17 and the started field was removed in Go 1.22. Only the fields
+// present in the target's runtime are decoded, the others are left at
+// their zero value.
 func (d *Defer) load() {
 	d.variable.loadValue(LoadConfig{false, 1, 0, 0, -1, 0})
 	if d.variable.Unreadable != nil {
@@ -598,21 +742,38 @@ func (d *Defer) load() {
 		return
 	}
 
-	fnvar := d.variable.fieldVariable("fn").maybeDereference()
-	if fnvar.Addr != 0 {
-		fnvar = fnvar.loadFieldNamed("fn")
-		if fnvar.Unreadable == nil {
-			d.DeferredPC, _ = constant.Uint64Val(fnvar.Value)
+	if fnvar := d.variable.fieldVariable("fn"); fnvar != nil {
+		if fnvar.Kind == reflect.Func {
+			// Go 1.17 and later, fn is a func() value
+			d.DeferredPC = uint64(fnvar.Base)
+		} else if fnvar = fnvar.maybeDereference(); fnvar.Addr != 0 {
+			fnvar = fnvar.loadFieldNamed("fn")
+			if fnvar != nil {
+				d.DeferredPC, _ = constant.Uint64Val(fnvar.Value)
+			}
 		}
 	}
 
-	d.DeferPC, _ = constant.Uint64Val(d.variable.fieldVariable("pc").Value)
-	d.SP, _ = constant.Uint64Val(d.variable.fieldVariable("sp").Value)
-	d.argSz, _ = constant.Int64Val(d.variable.fieldVariable("siz").Value)
+	if pcvar := d.variable.fieldVariable("pc"); pcvar != nil && pcvar.Value != nil {
+		d.DeferPC, _ = constant.Uint64Val(pcvar.Value)
+	}
+	if spvar := d.variable.fieldVariable("sp"); spvar != nil && spvar.Value != nil {
+		d.SP, _ = constant.Uint64Val(spvar.Value)
+	}
+	if sizvar := d.variable.fieldVariable("siz"); sizvar != nil && sizvar.Value != nil {
+		d.argSz, _ = constant.Int64Val(sizvar.Value)
+	}
+	if startedvar := d.variable.fieldVariable("started"); startedvar != nil && startedvar.Value != nil {
+		d.Started = constant.BoolVal(startedvar.Value)
+	}
+	if heapvar := d.variable.fieldVariable("heap"); heapvar != nil && heapvar.Value != nil {
+		d.Heap = constant.BoolVal(heapvar.Value)
+	}
 
-	linkvar := d.variable.fieldVariable("link").maybeDereference()
-	if linkvar.Addr != 0 {
-		d.link = &Defer{variable: linkvar}
+	if linkvar := d.variable.fieldVariable("link"); linkvar != nil {
+		if linkvar = linkvar.maybeDereference(); linkvar.Addr != 0 {
+			d.link = &Defer{variable: linkvar}
+		}
 	}
 }
 
@@ -635,6 +796,13 @@ func (d *Defer) Next() *Defer {
 	return d.link
 }
 
+// DeferredLoc returns the location of the entry point of the deferred
+// function.
+func (d *Defer) DeferredLoc() Location {
+	f, l, fn := d.variable.bi.PCToLine(d.DeferredPC)
+	return Location{PC: d.DeferredPC, File: f, Line: l, Fn: fn}
+}
+
 // EvalScope returns an EvalScope relative to the argument frame of this deferred call.
 // The argument frame of a deferred call is stored in memory immediately
 // after the deferred header.
@@ -672,3 +840,41 @@ func (d *Defer) EvalScope(thread Thread) (*EvalScope, error) {
 
 	return scope, nil
 }
+
+// Panic represents one panic that a goroutine is currently unwinding
+// through.
+type Panic struct {
+	Arg       *Variable // Argument passed to panic
+	Recovered bool      // Value of field _panic.recovered
+	Aborted   bool      // Value of field _panic.aborted
+	link      *Panic    // Earlier panic
+
+	variable   *Variable
+	Unreadable error
+}
+
+func (p *Panic) load() {
+	p.variable.loadValue(LoadConfig{false, 1, 0, 0, -1, 0})
+	if p.variable.Unreadable != nil {
+		p.Unreadable = p.variable.Unreadable
+		return
+	}
+
+	if argvar, err := p.variable.structMember("arg"); err == nil {
+		argvar.Name = "arg"
+		argvar.loadValue(loadFullValue)
+		p.Arg = argvar
+	}
+
+	if recoveredvar := p.variable.fieldVariable("recovered"); recoveredvar != nil && recoveredvar.Value != nil {
+		p.Recovered = constant.BoolVal(recoveredvar.Value)
+	}
+	if abortedvar := p.variable.fieldVariable("aborted"); abortedvar != nil && abortedvar.Value != nil {
+		p.Aborted = constant.BoolVal(abortedvar.Value)
+	}
+
+	linkvar := p.variable.fieldVariable("link").maybeDereference()
+	if linkvar.Addr != 0 {
+		p.link = &Panic{variable: linkvar}
+	}
+}
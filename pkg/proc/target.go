@@ -20,6 +20,11 @@ func NewTarget(p Process) *Target {
 		fncallForG: make(map[int]*callInjection),
 	}
 	t.gcache.init(p.BinInfo())
+	// A wrong g struct offset would make every goroutine disappear, check
+	// it before the first time it is used to find a goroutine.
+	if err := ValidateGStructOffset(p.CurrentThread()); err != nil {
+		p.BinInfo().logger.Warnf("%v", err)
+	}
 	return t
 }
 
@@ -1,7 +1,6 @@
 package proc
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -419,27 +418,200 @@ func setStepIntoBreakpoint(dbp Process, text []AsmInstruction, cond ast.Expr) er
 	return nil
 }
 
-func getGVariable(thread Thread) (*Variable, error) {
+// getGVariable returns a variable for the g struct of the goroutine
+// running on thread. Where the runtime keeps the g pointer depends on the
+// architecture:
+//   - amd64: in thread local storage, at regs.TLS()+GStructOffset(). On
+//     windows that slot contains a pointer to a pointer to g (see DerefTLS).
+//   - arm64: in register X28 (returned by regs.GAddr()). The register is
+//     only valid while executing Go code, C code is free to use it.
+//     Programs using cgo also save g in thread local storage, at
+//     runtime.tls_g from the thread pointer, which is read instead outside
+//     of Go code.
+func getGVariable(thread Thread, bi *BinaryInfo) (*Variable, error) {
 	regs, err := thread.Registers(false)
 	if err != nil {
 		return nil, err
 	}
+	pmthread, ok := thread.(postmortemThread)
+	return getGVariableFromRegs(regs, bi, thread, ok && pmthread.Postmortem())
+}
+
+// postmortemThread is implemented by the threads of core files and
+// minidumps.
+type postmortemThread interface {
+	// Postmortem returns true if the memory of the thread is a snapshot
+	// taken after the process died, which may not include the thread local
+	// storage of the thread.
+	Postmortem() bool
+}
 
+// getGVariableFromRegs is like getGVariable but uses the registers regs
+// and reads memory from mem. If postmortem is set and the
+// thread local storage can not be read the g struct is searched in
+// runtime.allgs using the stack pointer, see findGByStackPointer.
+func getGVariableFromRegs(regs Registers, bi *BinaryInfo, mem MemoryReadWriter, postmortem bool) (*Variable, error) {
 	gaddr, hasgaddr := regs.GAddr()
+	if hasgaddr && bi.gStructOffsetTLSG && !isGoCode(bi, regs.PC()) {
+		// The g register is only valid in Go code, for example the thread
+		// could be executing C code called through cgo or be stopped in the
+		// vdso, use the copy of g in thread local storage instead.
+		hasgaddr = false
+	}
 	if !hasgaddr {
-		gaddrbs := make([]byte, thread.Arch().PtrSize())
-		_, err := thread.ReadMemory(gaddrbs, uintptr(regs.TLS()+thread.BinInfo().GStructOffset()))
+		var err error
+		gaddr, err = readTLSG(regs, bi, mem)
 		if err != nil {
+			// The thread local storage isn't always available in core files,
+			// for example Windows minidumps only contain the TEB of each thread
+			// if they were created with MiniDumpWithProcessThreadData. Look for
+			// the goroutine whose stack contains the stack pointer instead.
+			if postmortem {
+				if gaddr, ok := findGByStackPointer(mem, bi, regs.SP()); ok {
+					return newGVariableMem(bi, mem, uintptr(gaddr), false)
+				}
+			}
 			return nil, err
 		}
-		gaddr = binary.LittleEndian.Uint64(gaddrbs)
 	}
 
-	return newGVariable(thread, uintptr(gaddr), thread.Arch().DerefTLS())
+	return newGVariableMem(bi, mem, uintptr(gaddr), false)
 }
 
-func newGVariable(thread Thread, gaddr uintptr, deref bool) (*Variable, error) {
-	typ, err := thread.BinInfo().findType("runtime.g")
+// readTLSG reads the address of the g struct of the thread whose
+// registers are regs from its thread local storage, at
+// regs.TLS()+GStructOffset().
+// On windows regs.TLS() is the address of the TEB of the thread and
+// GStructOffset is the offset of its ArbitraryUserPointer field, which
+// points to the TLS slot containing the address of the g struct (see
+// DerefTLS).
+func readTLSG(regs Registers, bi *BinaryInfo, mem MemoryReadWriter) (uint64, error) {
+	ptrSize := int64(bi.Arch.PtrSize())
+	gaddr, err := readUintRaw(mem, uintptr(regs.TLS()+bi.GStructOffset()), ptrSize)
+	if err != nil || !bi.Arch.DerefTLS() || gaddr == 0 {
+		return gaddr, err
+	}
+	slot := gaddr
+	gaddr, err = readUintRaw(mem, uintptr(slot), ptrSize)
+	if err != nil {
+		return 0, ErrGStructUnreadable{Addr: slot, Err: err}
+	}
+	return gaddr, nil
+}
+
+// knownGStructOffsets maps the GOOS/GOARCH of internally linked programs
+// to the offset of the g pointer from the TLS base, see GStructOffset.
+// The offset of externally linked programs depends on the TLS segment of
+// the executable and is not listed, nor are architectures that keep g in a
+// register.
+var knownGStructOffsets = map[string]uint64{
+	"linux/amd64":   ^uint64(8) + 1, // -8
+	"freebsd/amd64": ^uint64(8) + 1, // -8
+	"darwin/amd64":  0x30,           // go1.11 and later
+	"windows/amd64": 0x28,           // ArbitraryUserPointer, points to the g pointer
+}
+
+// knownGStructOffset returns the offset of the g pointer listed in
+// knownGStructOffsets for bi, if any.
+func knownGStructOffset(bi *BinaryInfo) (uint64, bool) {
+	if bi.gStructOffsetTLSG {
+		return 0, false
+	}
+	off, ok := knownGStructOffsets[bi.GOOS+"/"+bi.goarch]
+	return off, ok
+}
+
+// ValidateGStructOffset checks that the g pointer can be read from the
+// thread local storage of thread, at the offset returned by
+// GStructOffset, and that it points to something that looks like a g
+// struct. If GStructOffset is wrong no goroutines can be found, this
+// function returns an error describing the problem.
+// Architectures that keep g in a register are not checked.
+func ValidateGStructOffset(thread Thread) error {
+	regs, err := thread.Registers(false)
+	if err != nil {
+		return err
+	}
+	return checkGStructOffset(regs, thread.BinInfo(), thread)
+}
+
+func checkGStructOffset(regs Registers, bi *BinaryInfo, mem MemoryReadWriter) error {
+	if _, hasgaddr := regs.GAddr(); hasgaddr || regs.TLS() == 0 {
+		// g is kept in a register or thread local storage hasn't been set up
+		// yet, nothing to check.
+		return nil
+	}
+	fail := func(format string, args ...interface{}) error {
+		linkmode := "internal"
+		if bi.gStructOffsetTLSG {
+			linkmode = "external"
+		}
+		msg := fmt.Sprintf("g struct offset %#x for %s/%s (%s linking) seems to be wrong: %s", bi.GStructOffset(), bi.GOOS, bi.goarch, linkmode, fmt.Sprintf(format, args...))
+		if known, ok := knownGStructOffset(bi); ok && known != bi.GStructOffset() {
+			msg += fmt.Sprintf(" (expected %#x)", known)
+		}
+		return errors.New(msg)
+	}
+
+	gaddr, err := readUintRaw(mem, uintptr(regs.TLS()+bi.GStructOffset()), int64(bi.Arch.PtrSize()))
+	if err != nil {
+		return fail("could not read thread local storage: %v", err)
+	}
+	if gaddr == 0 {
+		// Not executing Go code, nothing to check.
+		return nil
+	}
+	gvar, err := newGVariableMem(bi, mem, uintptr(gaddr), bi.Arch.DerefTLS())
+	if err != nil {
+		return err
+	}
+	g, err := gvar.parseG()
+	if err != nil {
+		return fail("%v", err)
+	}
+	const gscan = 0x1000
+	switch {
+	case g.Unreadable != nil:
+		return fail("%v", g.Unreadable)
+	case g.Status&^gscan > Gpreempted:
+		return fail("invalid goroutine status %d", g.Status)
+	case g.ID64() < 0:
+		return fail("invalid goroutine id %d", g.ID64())
+	}
+	return nil
+}
+
+// isGoCode returns true if pc belongs to a function compiled by the Go
+// toolchain.
+func isGoCode(bi *BinaryInfo, pc uint64) bool {
+	fn := bi.PCToFunc(pc)
+	return fn != nil && fn.cu.isgo
+}
+
+// findGByStackPointer returns the address of the g struct of the running
+// goroutine whose stack contains sp.
+// Goroutines executing on a system stack can not be found this way.
+func findGByStackPointer(mem MemoryReadWriter, bi *BinaryInfo, sp uint64) (gaddr uint64, ok bool) {
+	if len(bi.Images) == 0 {
+		return 0, false
+	}
+	IterateGoroutines(mem, bi, func(g *G) bool {
+		if g.Unreadable != nil || g.variable == nil || (g.Status != Grunning && g.Status != Gsyscall) {
+			return true
+		}
+		if sp >= g.stacklo && sp < g.stackhi {
+			gaddr, ok = uint64(g.variable.Addr), true
+			return false
+		}
+		return true
+	})
+	return gaddr, ok
+}
+
+// newGVariableMem returns a variable for the g struct at gaddr, or for the
+// pointer to it at gaddr if deref is set, read from mem.
+func newGVariableMem(bi *BinaryInfo, mem MemoryReadWriter, gaddr uintptr, deref bool) (*Variable, error) {
+	typ, err := bi.findRuntimeGType()
 	if err != nil {
 		return nil, err
 	}
@@ -449,7 +621,7 @@ func newGVariable(thread Thread, gaddr uintptr, deref bool) (*Variable, error) {
 	if deref {
 		typ = &godwarf.PtrType{
 			CommonType: godwarf.CommonType{
-				ByteSize:    int64(thread.Arch().PtrSize()),
+				ByteSize:    int64(bi.Arch.PtrSize()),
 				Name:        "",
 				ReflectKind: reflect.Ptr,
 				Offset:      0,
@@ -460,7 +632,22 @@ func newGVariable(thread Thread, gaddr uintptr, deref bool) (*Variable, error) {
 		name = "runtime.curg"
 	}
 
-	return newVariableFromThread(thread, name, gaddr, typ), nil
+	return newVariable(name, gaddr, typ, bi, mem), nil
+}
+
+// isGsignal returns true if g is the goroutine used by the runtime to
+// execute signal handlers on the thread described by mvar (a pointer to a
+// runtime.m struct).
+func isGsignal(g *G, mvar *Variable) bool {
+	if mvar == nil {
+		return false
+	}
+	gsignal, err := mvar.structMember("gsignal")
+	if err != nil || gsignal.Kind != reflect.Ptr {
+		return false
+	}
+	gsignal = gsignal.maybeDereference()
+	return gsignal.Unreadable == nil && gsignal.Addr != 0 && gsignal.Addr == g.variable.Addr
 }
 
 // GetG returns information on the G (goroutine) that is executing on this thread.
@@ -478,11 +665,15 @@ func newGVariable(thread Thread, gaddr uintptr, deref bool) (*Variable, error) {
 // In order to get around all this craziness, we read the address of the G structure for
 // the current thread from the thread local storage area.
 func GetG(thread Thread) (*G, error) {
+	return getG(thread, thread.BinInfo())
+}
+
+func getG(thread Thread, bi *BinaryInfo) (*G, error) {
 	if loc, _ := thread.Location(); loc != nil && loc.Fn != nil && loc.Fn.Name == "runtime.clone" {
 		// When threads are executing runtime.clone the value of TLS is unreliable.
 		return nil, nil
 	}
-	gaddr, err := getGVariable(thread)
+	gaddr, err := getGVariable(thread, bi)
 	if err != nil {
 		return nil, err
 	}
@@ -491,6 +682,71 @@ func GetG(thread Thread) (*G, error) {
 	if err != nil {
 		return nil, err
 	}
+	g, err = userGoroutine(g)
+	if err != nil {
+		return nil, err
+	}
+	g.Thread = thread
+	if loc, err := thread.Location(); err == nil {
+		g.CurrentLoc = *loc
+	}
+	return g, nil
+}
+
+// RunningGoroutines returns the goroutine currently running on each of
+// threads, keyed by thread ID. Threads that are not running a goroutine,
+// or that are blocked, are omitted. If the goroutine of a thread can not be
+// read the first such error is returned along with the goroutines of all
+// other threads.
+func RunningGoroutines(threads []Thread, bi *BinaryInfo) (map[int]*G, error) {
+	r := make(map[int]*G)
+	var err error
+	for _, th := range threads {
+		if th.Blocked() {
+			continue
+		}
+		g, gerr := getG(th, bi)
+		if gerr != nil {
+			if _, nogoroutine := gerr.(ErrNoGoroutine); !nogoroutine && err == nil {
+				err = fmt.Errorf("thread %d: %v", th.ThreadID(), gerr)
+			}
+			continue
+		}
+		if g != nil {
+			r[th.ThreadID()] = g
+		}
+	}
+	return r, err
+}
+
+// CurrentGoroutineFromRegisters returns the goroutine that was running on
+// the thread whose registers are regs, reading memory from mem. Unlike
+// GetG it doesn't need a Thread, for example it can be used with the
+// registers saved in the notes of a core file. Like for the threads of
+// core files the goroutine is searched by stack pointer if the thread local
+// storage can not be read.
+// The Thread field of the returned goroutine is not set.
+func CurrentGoroutineFromRegisters(regs Registers, bi *BinaryInfo, mem MemoryReadWriter) (*G, error) {
+	gvar, err := getGVariableFromRegs(regs, bi, mem, true)
+	if err != nil {
+		return nil, err
+	}
+	g, err := gvar.parseG()
+	if err != nil {
+		return nil, err
+	}
+	g, err = userGoroutine(g)
+	if err != nil {
+		return nil, err
+	}
+	f, l, fn := bi.PCToLine(regs.PC())
+	g.CurrentLoc = Location{PC: regs.PC(), File: f, Line: l, Fn: fn}
+	return g, nil
+}
+
+// userGoroutine returns g, or the user goroutine of its thread if g is a
+// goroutine used by the runtime to run code on the system stack.
+func userGoroutine(g *G) (*G, error) {
 	if g.ID == 0 {
 		// The runtime uses a special goroutine with ID == 0 to mark that the
 		// current goroutine is executing on the system stack (sometimes also
@@ -499,7 +755,12 @@ func GetG(thread Thread) (*G, error) {
 		// For our purposes it's better if we always return the real goroutine
 		// since the rest of the code assumes the goroutine ID is univocal.
 		// The real 'current goroutine' is stored in g0.m.curg
-		curgvar, err := g.variable.fieldVariable("m").structMember("curg")
+		// The same is true for the goroutine used to run signal handlers
+		// (m.gsignal), in which case the thread was stopped inside a signal
+		// handler.
+		mvar := g.variable.fieldVariable("m")
+		signalStack := isGsignal(g, mvar)
+		curgvar, err := mvar.structMember("curg")
 		if err != nil {
 			return nil, err
 		}
@@ -508,10 +769,7 @@ func GetG(thread Thread) (*G, error) {
 			return nil, err
 		}
 		g.SystemStack = true
-	}
-	g.Thread = thread
-	if loc, err := thread.Location(); err == nil {
-		g.CurrentLoc = *loc
+		g.SignalStack = signalStack
 	}
 	return g, nil
 }
@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/go-delve/delve/pkg/dwarf/godwarf"
@@ -181,26 +182,148 @@ const (
 	Gdead                         // 6
 	Genqueue                      // 7 Only the Gscanenqueue is used.
 	Gcopystack                    // 8 in this state when newstack is moving the stack
+	Gpreempted                    // 9 stopped itself for a suspendG preemption (go >= 1.14)
 )
 
+// P status, from: src/runtime/runtime2.go
+const (
+	Pidle    int64 = iota // 0
+	Prunning              // 1 owned by an M and running user code or the scheduler
+	Psyscall              // 2
+	Pgcstop               // 3 halted for stop-the-world
+	Pdead                 // 4
+)
+
+// WaitReasonCode identifies the reason a goroutine is parked, see
+// G.WaitReasonCode. Unlike G.WaitReason its values do not depend on the
+// version of the runtime.
+type WaitReasonCode uint8
+
+const (
+	WaitReasonUnknown WaitReasonCode = iota // wait reason not known to Delve or not read from a numeric field
+	WaitReasonNone                          // the goroutine isn't parked
+	WaitReasonGCAssistMarking
+	WaitReasonIOWait
+	WaitReasonChanReceiveNilChan
+	WaitReasonChanSendNilChan
+	WaitReasonDumpingHeap
+	WaitReasonGarbageCollection
+	WaitReasonGarbageCollectionScan
+	WaitReasonPanicWait
+	WaitReasonSelect
+	WaitReasonSelectNoCases
+	WaitReasonGCAssistWait
+	WaitReasonGCSweepWait
+	WaitReasonGCScavengeWait
+	WaitReasonChanReceive
+	WaitReasonChanSend
+	WaitReasonFinalizerWait
+	WaitReasonForceGCIdle
+	WaitReasonSemacquire
+	WaitReasonSleep
+	WaitReasonSyncCondWait
+	WaitReasonTimerGoroutineIdle
+	WaitReasonTraceReaderBlocked
+	WaitReasonWaitForGCCycle
+	WaitReasonGCWorkerIdle
+	WaitReasonPreempted
+	WaitReasonDebugCall
+)
+
+// waitReasonCodes maps the names of the runtime.waitReason constants to
+// the corresponding WaitReasonCode. The runtime renumbers its constants
+// between versions, their names are more stable.
+var waitReasonCodes = map[string]WaitReasonCode{
+	"waitReasonZero":                  WaitReasonNone,
+	"waitReasonGCAssistMarking":       WaitReasonGCAssistMarking,
+	"waitReasonIOWait":                WaitReasonIOWait,
+	"waitReasonChanReceiveNilChan":    WaitReasonChanReceiveNilChan,
+	"waitReasonChanSendNilChan":       WaitReasonChanSendNilChan,
+	"waitReasonDumpingHeap":           WaitReasonDumpingHeap,
+	"waitReasonGarbageCollection":     WaitReasonGarbageCollection,
+	"waitReasonGarbageCollectionScan": WaitReasonGarbageCollectionScan,
+	"waitReasonPanicWait":             WaitReasonPanicWait,
+	"waitReasonSelect":                WaitReasonSelect,
+	"waitReasonSelectNoCases":         WaitReasonSelectNoCases,
+	"waitReasonGCAssistWait":          WaitReasonGCAssistWait,
+	"waitReasonGCSweepWait":           WaitReasonGCSweepWait,
+	"waitReasonGCScavengeWait":        WaitReasonGCScavengeWait,
+	"waitReasonChanReceive":           WaitReasonChanReceive,
+	"waitReasonChanSend":              WaitReasonChanSend,
+	"waitReasonFinalizerWait":         WaitReasonFinalizerWait,
+	"waitReasonForceGGIdle":           WaitReasonForceGCIdle, // sic, renamed to waitReasonForceGCIdle in go1.16
+	"waitReasonForceGCIdle":           WaitReasonForceGCIdle,
+	"waitReasonSemacquire":            WaitReasonSemacquire,
+	"waitReasonSleep":                 WaitReasonSleep,
+	"waitReasonSyncCondWait":          WaitReasonSyncCondWait,
+	"waitReasonTimerGoroutineIdle":    WaitReasonTimerGoroutineIdle,
+	"waitReasonTraceReaderBlocked":    WaitReasonTraceReaderBlocked,
+	"waitReasonWaitForGCCycle":        WaitReasonWaitForGCCycle,
+	"waitReasonGCWorkerIdle":          WaitReasonGCWorkerIdle,
+	"waitReasonPreempted":             WaitReasonPreempted,
+	"waitReasonDebugCall":             WaitReasonDebugCall,
+}
+
+// GFlags is a set of the guard flags of a goroutine, see G.Flags.
+type GFlags uint8
+
+const (
+	GFlagPreemptStop GFlags = 1 << iota // g.preemptStop: the goroutine will stop at the next preemption point (go >= 1.14)
+	GFlagThrowSplit                     // g.throwsplit: the goroutine must not grow its stack
+	GFlagRaceIgnore                     // g.raceignore: race detector events are ignored
+)
+
+// gFlagNames contains the names of the fields of runtime.g corresponding
+// to each GFlags bit.
+var gFlagNames = []string{"preemptStop", "throwsplit", "raceignore"}
+
+func (flags GFlags) String() string {
+	var names []string
+	for i, name := range gFlagNames {
+		if flags&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
 // G represents a runtime G (goroutine) structure (at least the
 // fields that Delve is interested in).
 type G struct {
-	ID         int    // Goroutine ID
+	ID         int    // Goroutine ID, truncated to the size of int, see ID64
+	goid       int64  // Goroutine ID
 	PC         uint64 // PC of goroutine when it was parked.
 	SP         uint64 // SP of goroutine when it was parked.
 	BP         uint64 // BP of goroutine when it was parked (go >= 1.7).
 	LR         uint64 // LR of goroutine when it was parked.
+	SyscallPC  uint64 // PC of the last syscall entered by the goroutine.
+	SyscallSP  uint64 // SP of the last syscall entered by the goroutine.
 	GoPC       uint64 // PC of 'go' statement that created this goroutine.
 	StartPC    uint64 // PC of the first function run on this goroutine.
 	WaitReason string // Reason for goroutine being parked.
 	Status     uint64
+	Flags      GFlags    // Guard flags of the goroutine, flags not supported by the runtime are never set.
 	stkbarVar  *Variable // stkbar field of g struct
 	stkbarPos  int       // stkbarPos field of g struct
 	stackhi    uint64    // value of stack.hi
 	stacklo    uint64    // value of stack.lo
 
+	inStackGrowth bool   // sched.pc is inside one of morestackFunctions
+	closureCtxt   uint64 // value of sched.ctxt
+
 	SystemStack bool // SystemStack is true if this goroutine is currently executing on a system stack.
+	SignalStack bool // SignalStack is true if this goroutine is currently executing a signal handler on the signal stack (gsignal) of its thread.
+
+	// WaitReasonCode is the reason for the goroutine being parked, it is
+	// WaitReasonUnknown if the runtime stores the wait reason as a string
+	// (go < 1.11) or if the reason is not known to Delve.
+	WaitReasonCode WaitReasonCode
+
+	// WaitSince is the value of runtime.nanotime when the goroutine became
+	// blocked (field waitsince of the g struct). The runtime only sets it
+	// approximately, during the first garbage collection that finds the
+	// goroutine blocked, until then it is 0.
+	WaitSince int64
 
 	// Information on goroutine location
 	CurrentLoc Location
@@ -212,7 +335,24 @@ type G struct {
 
 	Unreadable error // could not read the G struct
 
+	// UnknownFields lists the fields of the g struct that are in memory
+	// that could not be read (for example because of a gap in a core file).
+	// The fields of G derived from them are left at their zero value.
+	UnknownFields []string
+
 	labels *map[string]string // G's pprof labels, computed on demand in Labels() method
+
+	cachedStack         []Stackframe // frames returned by CachedStack, cleared when the target resumes
+	cachedStackComplete bool         // cachedStack contains all the frames of the goroutine
+}
+
+// ID64 returns the goroutine ID. Unlike the ID field it is never
+// truncated, the runtime uses 64bit goroutine IDs on all architectures.
+func (g *G) ID64() int64 {
+	if g.goid != 0 {
+		return g.goid
+	}
+	return int64(g.ID)
 }
 
 // Defer returns the top-most defer of the goroutine.
@@ -229,46 +369,409 @@ func (g *G) Defer() *Defer {
 	return d
 }
 
+// errPanicLoop is returned by (*G).Panics when the _panic linked list
+// refers back to one of its own nodes.
+var errPanicLoop = errors.New("corrupted panic list: loop detected")
+
+// Defers returns at most max deferred calls of the goroutine, starting
+// from the top-most one, by following the _defer linked list.
+// If a defer can not be read the defers read so far are returned along
+// with the error.
+func (g *G) Defers(max int) ([]*Defer, error) {
+	if g.variable == nil {
+		return nil, g.Unreadable
+	}
+	var r []*Defer
+	for d := g.Defer(); d != nil && len(r) < max; d = d.Next() {
+		if d.Unreadable != nil {
+			return r, d.Unreadable
+		}
+		r = append(r, d)
+	}
+	return r, nil
+}
+
+// Panics returns the list of panics the goroutine is currently
+// unwinding through, starting with the most recent one.
+func (g *G) Panics() ([]*Panic, error) {
+	if g.variable == nil {
+		return nil, g.Unreadable
+	}
+	if g.variable.Unreadable != nil {
+		return nil, g.variable.Unreadable
+	}
+	pvar := g.variable.fieldVariable("_panic")
+	if pvar == nil {
+		return nil, errors.New("could not find _panic field in g struct")
+	}
+	pvar = pvar.maybeDereference()
+	if pvar.Addr == 0 {
+		return nil, nil
+	}
+	r := []*Panic{}
+	seen := map[uintptr]bool{}
+	for p := (&Panic{variable: pvar}); p != nil; p = p.link {
+		if seen[p.variable.Addr] {
+			return r, errPanicLoop
+		}
+		seen[p.variable.Addr] = true
+		p.load()
+		r = append(r, p)
+		if p.Unreadable != nil {
+			break
+		}
+	}
+	return r, nil
+}
+
+// isMainMaxFrames is the maximum number of frames IsMain will unwind
+// while searching for the call to main.main.
+var isMainMaxFrames = 100
+
+// IsMain returns true if g is the main goroutine, i.e. the goroutine
+// started by the runtime to execute runtime.main, regardless of its ID.
+// If the start function of the goroutine is not known its stack is
+// searched for a call to main.main from runtime.main.
+func (g *G) IsMain(bi *BinaryInfo) bool {
+	if fn := bi.PCToFunc(g.StartPC); fn != nil {
+		return fn.Name == "runtime.main"
+	}
+	if g.variable == nil || g.Unreadable != nil {
+		return false
+	}
+	frames, err := g.CachedStack(isMainMaxFrames)
+	if err != nil {
+		return false
+	}
+	for i := 0; i+1 < len(frames); i++ {
+		fn, callerFn := frames[i].Call.Fn, frames[i+1].Call.Fn
+		if fn != nil && callerFn != nil && fn.Name == "main.main" && callerFn.Name == "runtime.main" {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverableMaxFrames is the maximum number of frames Recoverable will
+// unwind while searching for a call to runtime.gorecover.
+var recoverableMaxFrames = 50
+
+// Recoverable returns true if the panic the goroutine is currently
+// unwinding through will be recovered. This happens if the top-most panic
+// has already been recovered or if the deferred call started by it is
+// currently executing a call to recover.
+// If the goroutine is not panicking false is returned.
+func (g *G) Recoverable() (bool, error) {
+	panics, err := g.Panics()
+	if err != nil {
+		return false, err
+	}
+	if len(panics) == 0 {
+		return false, nil
+	}
+	p := panics[0]
+	if p.Unreadable != nil {
+		return false, p.Unreadable
+	}
+	if recoveredvar := p.variable.fieldVariable("recovered"); recoveredvar == nil || recoveredvar.Value == nil {
+		return false, errors.New("could not find recovered field of runtime._panic")
+	}
+	if p.Recovered {
+		return true, nil
+	}
+	if goexitvar := p.variable.fieldVariable("goexit"); goexitvar != nil && goexitvar.Value != nil && constant.BoolVal(goexitvar.Value) {
+		// deferred calls run by runtime.Goexit can not recover
+		return false, nil
+	}
+
+	if p.variable.fieldVariable("startPC") != nil {
+		// Go 1.22 and later: the deferred call being run is removed from the
+		// defer list and called directly by runtime.gopanic.
+		return g.callingRecover(true)
+	}
+
+	d := g.Defer()
+	if d == nil {
+		return false, nil
+	}
+	if d.Unreadable != nil {
+		return false, d.Unreadable
+	}
+	startedvar := d.variable.fieldVariable("started")
+	dpanic := d.variable.fieldVariable("_panic")
+	if startedvar == nil || dpanic == nil {
+		return false, errors.New("could not find started and _panic fields of runtime._defer")
+	}
+	if !d.Started || dpanic.maybeDereference().Addr != p.variable.Addr {
+		// the top-most defer was not started by the top-most panic
+		return false, nil
+	}
+	return g.callingRecover(false)
+}
+
+// callingRecover returns true if the stack of the goroutine contains a call
+// to runtime.gorecover made by a deferred call. If direct is true the
+// deferred call must have been called by runtime.gopanic.
+func (g *G) callingRecover(direct bool) (bool, error) {
+	frames, err := g.CachedStack(recoverableMaxFrames)
+	if err != nil {
+		return false, err
+	}
+	for i := range frames {
+		if fn := frames[i].Current.Fn; fn == nil || fn.Name != "runtime.gorecover" {
+			continue
+		}
+		if !direct {
+			return true, nil
+		}
+		// frames[i+1] is the deferred call
+		if i+2 < len(frames) {
+			fn := frames[i+2].Current.Fn
+			return fn != nil && fn.Name == "runtime.gopanic", nil
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+// userCurrentMaxFrames is the maximum number of frames UserCurrent will
+// unwind while searching for a user frame.
+var userCurrentMaxFrames = 100
+
 // UserCurrent returns the location the users code is at,
 // or was at before entering a runtime function.
+// If no user frame is found within userCurrentMaxFrames frames the current
+// location is returned.
 func (g *G) UserCurrent() Location {
+	for i := range g.cachedStack {
+		if isUserFrame(&g.cachedStack[i]) {
+			return g.cachedStack[i].Call
+		}
+	}
+	if g.cachedStackComplete {
+		return g.CurrentLoc
+	}
 	it, err := g.stackIterator(0)
 	if err != nil {
 		return g.CurrentLoc
 	}
-	for it.Next() {
-		frame := it.Frame()
-		if frame.Call.Fn != nil {
-			name := frame.Call.Fn.Name
-			if strings.Contains(name, ".") && (!strings.HasPrefix(name, "runtime.") || isExportedRuntime(name)) {
-				return frame.Call
+	var frames []Stackframe
+	for depth := 0; depth < userCurrentMaxFrames && it.Next(); depth++ {
+		// Expand inlined calls so that a user function inlined into its
+		// caller is reported with its own name.
+		n := len(frames)
+		frames = it.appendInlineCalls(frames, it.Frame())
+		for i := n; i < len(frames); i++ {
+			if isUserFrame(&frames[i]) {
+				return frames[i].Call
 			}
 		}
 	}
 	return g.CurrentLoc
 }
 
+// isUserFrame returns true if frame is executing a function that isn't an
+// unexported runtime function.
+func isUserFrame(frame *Stackframe) bool {
+	if frame.Call.Fn == nil {
+		return false
+	}
+	name := frame.Call.Fn.Name
+	return strings.Contains(name, ".") && (!strings.HasPrefix(name, "runtime.") || isExportedRuntime(name))
+}
+
+// CachedStack returns the same frames as Stacktrace(depth, 0) but reuses
+// the frames computed by previous calls to CachedStack, unless a larger
+// depth is requested. The cache is cleared when the target resumes.
+func (g *G) CachedStack(depth int) ([]Stackframe, error) {
+	if g.cachedStack != nil && (len(g.cachedStack) >= depth+1 || g.cachedStackComplete) {
+		if len(g.cachedStack) > depth+1 {
+			return g.cachedStack[: depth+1 : depth+1], nil
+		}
+		return g.cachedStack[:len(g.cachedStack):len(g.cachedStack)], nil
+	}
+	frames, err := g.Stacktrace(depth, 0)
+	if err != nil {
+		return nil, err
+	}
+	g.cachedStack = frames
+	g.cachedStackComplete = len(frames) < depth+1
+	return frames[:len(frames):len(frames)], nil
+}
+
+// clearCachedStack clears the frames cached by CachedStack.
+func (g *G) clearCachedStack() {
+	g.cachedStack = nil
+	g.cachedStackComplete = false
+}
+
+// releaseMemory releases the copy of the g struct read by parseG so that
+// its buffer can be reused, later reads go to the target's memory.
+func (g *G) releaseMemory() {
+	if g.variable != nil {
+		releaseMemory(g.variable.mem)
+	}
+}
+
 // Go returns the location of the 'go' statement
 // that spawned this goroutine.
 func (g *G) Go() Location {
-	pc := g.GoPC
-	if fn := g.variable.bi.PCToFunc(pc); fn != nil {
-		// Backup to CALL instruction.
-		// Mimics runtime/traceback.go:677.
-		if g.GoPC > fn.Entry {
-			pc--
+	return goStatementLocation(g.variable.bi, g.GoPC)
+}
+
+// goStatementLocation returns the location of the 'go' statement at gopc.
+func goStatementLocation(bi *BinaryInfo, gopc uint64) Location {
+	f, l, fn := bi.PCToLine(CallSitePC(bi, gopc))
+	return Location{PC: gopc, File: f, Line: l, Fn: fn}
+}
+
+// CreationStack returns the locations of the 'go' statements that created
+// this goroutine, its parent, its grandparent and so on. The first
+// location is always the same as Go(), the others are only available if
+// the runtime recorded the ancestors of this goroutine (i.e. if the target
+// was started with GODEBUG=tracebackancestors=N).
+// At most depth locations are returned.
+func (g *G) CreationStack(bi *BinaryInfo, depth int) ([]Location, error) {
+	if g.variable == nil {
+		return nil, g.Unreadable
+	}
+	if depth <= 0 {
+		return nil, nil
+	}
+	r := []Location{goStatementLocation(bi, g.GoPC)}
+	if depth == 1 {
+		return r, nil
+	}
+
+	av, err := g.variable.structMember("ancestors")
+	if err != nil {
+		// versions of Go before 1.11 do not record ancestors
+		return r, nil
+	}
+	av = av.maybeDereference()
+	av.loadValue(LoadConfig{MaxArrayValues: depth - 1, MaxVariableRecurse: 1, MaxStructFields: -1})
+	if av.Unreadable != nil {
+		return r, av.Unreadable
+	}
+	if av.Addr == 0 {
+		// no ancestors
+		return r, nil
+	}
+	for i := range av.Children {
+		gopcv := av.Children[i].fieldVariable("gopc")
+		if gopcv == nil {
+			return r, errors.New("could not find field gopc of runtime.ancestorInfo")
 		}
+		if gopcv.Unreadable != nil {
+			return r, gopcv.Unreadable
+		}
+		gopc, _ := constant.Uint64Val(gopcv.Value)
+		r = append(r, goStatementLocation(bi, gopc))
 	}
-	f, l, fn := g.variable.bi.PCToLine(pc)
-	return Location{PC: g.GoPC, File: f, Line: l, Fn: fn}
+	return r, nil
 }
 
 // StartLoc returns the starting location of the goroutine.
+// Unlike GoPC, StartPC is the entry point of a function and not a return
+// address, so it doesn't need to be moved back to the CALL instruction.
 func (g *G) StartLoc() Location {
 	f, l, fn := g.variable.bi.PCToLine(g.StartPC)
 	return Location{PC: g.StartPC, File: f, Line: l, Fn: fn}
 }
 
+// Origin returns both the location of the 'go' statement that created the
+// goroutine (see Go) and the entry point of the first function the
+// goroutine executed (see StartLoc).
+// The two are in different functions: for 'go f()' created is inside the
+// function containing the go statement while entry is the first line of f,
+// or of the wrapper that the compiler generated to call f.
+func (g *G) Origin() (created Location, entry Location) {
+	return g.Go(), g.StartLoc()
+}
+
+// IsSystemGoroutine returns true if g was started by the runtime for its
+// own purposes (GC workers, scavenger, finalizer goroutine, etc), i.e. if
+// its start function is a runtime function other than runtime.main.
+// Unlike SystemStack this does not depend on what the goroutine is
+// currently executing.
+func (g *G) IsSystemGoroutine() bool {
+	if g.variable == nil {
+		return false
+	}
+	fn := g.variable.bi.PCToFunc(g.StartPC)
+	if fn == nil {
+		// The start function is unknown, fall back to the location of the go
+		// statement.
+		fn = g.variable.bi.PCToFunc(g.GoPC)
+	}
+	if fn == nil {
+		return false
+	}
+	return fn.Name != "runtime.main" && strings.HasPrefix(fn.Name, "runtime.")
+}
+
+// gcWaitReasons is the set of wait reasons used by the runtime for
+// goroutines parked by, or on behalf of, the garbage collector.
+var gcWaitReasons = map[string]bool{
+	"GC assist marking":       true,
+	"GC assist wait":          true,
+	"GC sweep wait":           true,
+	"GC scavenge wait":        true,
+	"GC worker (idle)":        true,
+	"force gc (idle)":         true,
+	"garbage collection":      true,
+	"garbage collection scan": true,
+}
+
+// gcStartFunctions is the set of functions used by the runtime as entry
+// point for the goroutines of the garbage collector.
+var gcStartFunctions = map[string]bool{
+	"runtime.gcBgMarkWorker": true,
+	"runtime.bgsweep":        true,
+	"runtime.bgscavenge":     true,
+	"runtime.forcegchelper":  true,
+}
+
+// GCRelated returns true if the goroutine is parked by the garbage
+// collector (for example while performing a mark assist) or if it is one
+// of the goroutines of the garbage collector itself.
+func (g *G) GCRelated() bool {
+	if gcWaitReasons[g.WaitReason] {
+		return true
+	}
+	if g.variable == nil {
+		return false
+	}
+	fn := g.variable.bi.PCToFunc(g.StartPC)
+	return fn != nil && gcStartFunctions[fn.Name]
+}
+
+// RawField returns the field called name of the runtime.g struct of the
+// goroutine. It can be used to read fields that are not parsed into G,
+// which fields exist depends on the version of the runtime.
+func (g *G) RawField(name string) (*Variable, error) {
+	if g.variable == nil {
+		return nil, errors.New("runtime.g struct of goroutine not available")
+	}
+	if g.variable.Unreadable != nil {
+		return nil, g.variable.Unreadable
+	}
+	if styp, ok := resolveTypedef(g.variable.RealType).(*godwarf.StructType); ok {
+		for _, field := range styp.Field {
+			if field.Name != name {
+				continue
+			}
+			v, err := g.variable.toField(field)
+			if err != nil {
+				return nil, err
+			}
+			v.loadValue(loadFullValue)
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("runtime.g has no field %s", name)
+}
+
 func (g *G) Labels() map[string]string {
 	if g.labels != nil {
 		return *g.labels
@@ -295,6 +798,191 @@ func (g *G) Labels() map[string]string {
 	return *g.labels
 }
 
+// LockedThread returns the ID of the OS thread that the goroutine is
+// locked to (by runtime.LockOSThread) and true, or false if the goroutine
+// isn't locked to a thread.
+func (g *G) LockedThread() (int, bool) {
+	mvar := g.loadM("lockedm")
+	if mvar == nil {
+		return 0, false
+	}
+	procidvar := mvar.fieldVariable("procid")
+	if procidvar == nil || procidvar.Value == nil {
+		return 0, false
+	}
+	procid, _ := constant.Int64Val(procidvar.Value)
+	return int(procid), true
+}
+
+// SchedInfo returns the ID of the M (OS thread) and P (processor)
+// currently associated with the goroutine. If the goroutine isn't
+// associated with an M both IDs are -1, if the M doesn't own a P the
+// returned pID is -1.
+func (g *G) SchedInfo() (mID, pID int64, err error) {
+	if g.variable == nil || g.variable.Unreadable != nil {
+		return -1, -1, g.Unreadable
+	}
+	mvar := g.loadM("m")
+	if mvar == nil {
+		return -1, -1, nil
+	}
+	idvar := mvar.fieldVariable("id")
+	if idvar == nil || idvar.Value == nil {
+		return -1, -1, errors.New("could not read m.id")
+	}
+	mID, _ = constant.Int64Val(idvar.Value)
+	pvar := loadRuntimeStructField(mvar, "p", "runtime.p")
+	if pvar == nil {
+		return mID, -1, nil
+	}
+	idvar = pvar.fieldVariable("id")
+	if idvar == nil || idvar.Value == nil {
+		return mID, -1, errors.New("could not read p.id")
+	}
+	pID, _ = constant.Int64Val(idvar.Value)
+	return mID, pID, nil
+}
+
+// ProcessorStatus returns the status (one of Pidle, Prunning, Psyscall,
+// Pgcstop or Pdead) of the P associated with the M running the goroutine,
+// i.e. the value of g.m.p.status. If the goroutine has no associated M or
+// the M has no P ok is false.
+func (g *G) ProcessorStatus() (status int64, ok bool, err error) {
+	if g.variable == nil || g.variable.Unreadable != nil {
+		return 0, false, g.Unreadable
+	}
+	mvar := g.loadM("m")
+	if mvar == nil {
+		return 0, false, nil
+	}
+	pvar := loadRuntimeStructField(mvar, "p", "runtime.p")
+	if pvar == nil {
+		return 0, false, nil
+	}
+	statusvar := gField(pvar, "status")
+	if statusvar == nil || statusvar.Value == nil {
+		return 0, false, errors.New("could not read p.status")
+	}
+	status, _ = constant.Int64Val(statusvar.Value)
+	return status, true, nil
+}
+
+// InCgo returns true if the goroutine is executing C code called through
+// cgo. The frames above the point where the goroutine left Go code are C
+// frames, see CgoBoundary.
+func (g *G) InCgo() bool {
+	if g.variable == nil || g.variable.Unreadable != nil {
+		return false
+	}
+	if mvar := g.loadM("m"); mvar != nil {
+		if incgo := mvar.fieldVariable("incgo"); incgo != nil && incgo.Value != nil && incgo.Kind == reflect.Bool {
+			return constant.BoolVal(incgo.Value)
+		}
+	}
+	// m.incgo is not available, runtime.cgocall calls entersyscall before
+	// switching to C code, which saves its own PC in g.syscallpc.
+	sp, pc, ok := g.CgoBoundary()
+	if !ok || sp == 0 || g.Status != Gsyscall {
+		return false
+	}
+	fn := g.variable.bi.PCToFunc(pc)
+	return fn != nil && fn.Name == "runtime.cgocall"
+}
+
+// CgoBoundary returns the values of g.syscallsp and g.syscallpc, the SP
+// and PC saved by the runtime when the goroutine entered a system call or
+// C code, which are the point where the Go stack resumes above the C frames
+// of a goroutine executing cgo code.
+func (g *G) CgoBoundary() (sp, pc uint64, ok bool) {
+	if g.variable == nil || g.variable.Unreadable != nil {
+		return 0, 0, false
+	}
+	spvar := g.variable.fieldVariable("syscallsp")
+	pcvar := g.variable.fieldVariable("syscallpc")
+	if spvar == nil || pcvar == nil || spvar.Value == nil || pcvar.Value == nil {
+		return 0, 0, false
+	}
+	sp, _ = constant.Uint64Val(spvar.Value)
+	pc, _ = constant.Uint64Val(pcvar.Value)
+	return sp, pc, true
+}
+
+// asyncPreemptFramesToCheck is the number of frames, from the top of the
+// stack, checked by (*G).AsyncPreempted for runtime.asyncPreempt.
+const asyncPreemptFramesToCheck = 5
+
+// AsyncPreempted returns true if the goroutine was stopped by an
+// asynchronous preemption (go >= 1.14). The topmost frames of
+// asynchronously preempted goroutines are signal frames injected by the
+// runtime, rather than normal call frames.
+func (g *G) AsyncPreempted() bool {
+	if g.variable == nil {
+		return false
+	}
+	if g.variable.Unreadable == nil {
+		if v := g.variable.fieldVariable("asyncSafePoint"); v != nil && v.Value != nil && constant.BoolVal(v.Value) {
+			return true
+		}
+		if v := g.variable.fieldVariable("preemptStop"); v == nil {
+			// fields added in go1.14, asynchronous preemption doesn't exist
+			// before then.
+			return false
+		}
+	}
+	frames, err := g.Stacktrace(asyncPreemptFramesToCheck, 0)
+	if err != nil {
+		return false
+	}
+	for _, frame := range frames {
+		if frame.Current.Fn != nil && frame.Current.Fn.Name == "runtime.asyncPreempt" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadM loads the runtime.m struct referenced by the field fieldName of
+// the g struct.
+// Returns nil if the field is nil or can not be read.
+func (g *G) loadM(fieldName string) *Variable {
+	if g.variable == nil || g.variable.Unreadable != nil {
+		return nil
+	}
+	return loadRuntimeStructField(g.variable, fieldName, "runtime.m")
+}
+
+// loadRuntimeStructField loads the struct of type typename referenced by
+// the field fieldName of v, the field can be either a pointer or one of
+// the uintptr types used by the runtime to hide pointers from the garbage
+// collector (muintptr, puintptr, guintptr).
+// Returns nil if the field is nil or can not be read.
+func loadRuntimeStructField(v *Variable, fieldName, typename string) *Variable {
+	fvar := v.fieldVariable(fieldName)
+	if fvar == nil || fvar.Unreadable != nil {
+		return nil
+	}
+	var addr uint64
+	switch fvar.Kind {
+	case reflect.Ptr:
+		addr = uint64(fvar.maybeDereference().Addr)
+	case reflect.Uint, reflect.Uintptr:
+		addr, _ = constant.Uint64Val(fvar.Value)
+	}
+	if addr == 0 {
+		return nil
+	}
+	typ, err := v.bi.findType(typename)
+	if err != nil {
+		return nil
+	}
+	r := v.newVariable("", uintptr(addr), typ, v.mem)
+	r.loadValue(LoadConfig{false, 0, 0, 0, -1, 0})
+	if r.Unreadable != nil {
+		return nil
+	}
+	return r
+}
+
 type Ancestor struct {
 	ID         int64 // Goroutine ID
 	Unreadable error
@@ -339,7 +1027,10 @@ func newVariable(name string, addr uintptr, dwarfType godwarf.Type, bi *BinaryIn
 		// b. anonymous struct types (they contain the '{' character)
 		// c. Go internal struct types used to describe maps (they contain the '<'
 		// character).
-		cu := bi.findCompileUnitForOffset(dwarfType.Common().Offset)
+		var cu *compileUnit
+		if idx := dwarfType.Common().Index; idx >= 0 && idx < len(bi.Images) {
+			cu = bi.findCompileUnitForOffset(bi.Images[idx], dwarfType.Common().Offset)
+		}
 		if cu != nil && cu.isgo {
 			dwarfType = &godwarf.TypedefType{
 				CommonType: *(dwarfType.Common()),
@@ -508,6 +1199,14 @@ func (v *Variable) toField(field *godwarf.StructField) (*Variable, error) {
 	return v.newVariable(name, uintptr(int64(v.Addr)+field.ByteOffset), field.Type, v.mem), nil
 }
 
+// GoroutineError is implemented by the errors returned when a goroutine
+// could not be read: ErrNoGoroutine, ErrGStructUnreadable and
+// ErrGoroutineDead.
+type GoroutineError interface {
+	error
+	goroutineError()
+}
+
 // ErrNoGoroutine returned when a G could not be found
 // for a specific thread.
 type ErrNoGoroutine struct {
@@ -518,18 +1217,43 @@ func (ng ErrNoGoroutine) Error() string {
 	return fmt.Sprintf("no G executing on thread %d", ng.tid)
 }
 
+func (ErrNoGoroutine) goroutineError() {}
+
+// ErrGStructUnreadable is returned when the g struct of a goroutine could
+// not be read from the target's memory.
+type ErrGStructUnreadable struct {
+	Addr uint64 // address of the g struct, or of the pointer to it
+	Err  error
+}
+
+func (err ErrGStructUnreadable) Error() string {
+	return fmt.Sprintf("could not read G struct at %#x: %v", err.Addr, err.Err)
+}
+
+func (ErrGStructUnreadable) goroutineError() {}
+
+// ErrGoroutineDead is returned when the requested goroutine has exited.
+type ErrGoroutineDead struct {
+	ID int
+}
+
+func (err ErrGoroutineDead) Error() string {
+	return fmt.Sprintf("goroutine %d has exited", err.ID)
+}
+
+func (ErrGoroutineDead) goroutineError() {}
+
 func (v *Variable) parseG() (*G, error) {
 	mem := v.mem
 	gaddr := uint64(v.Addr)
 	_, deref := v.RealType.(*godwarf.PtrType)
 
 	if deref {
-		gaddrbytes := make([]byte, v.bi.Arch.PtrSize())
-		_, err := mem.ReadMemory(gaddrbytes, uintptr(gaddr))
+		var err error
+		gaddr, err = readUintRaw(mem, uintptr(gaddr), int64(v.bi.Arch.PtrSize()))
 		if err != nil {
-			return nil, fmt.Errorf("error derefing *G %s", err)
+			return nil, ErrGStructUnreadable{Addr: uint64(v.Addr), Err: err}
 		}
-		gaddr = binary.LittleEndian.Uint64(gaddrbytes)
 	}
 	if gaddr == 0 {
 		id := 0
@@ -544,73 +1268,608 @@ func (v *Variable) parseG() (*G, error) {
 		}
 		v = v.maybeDereference()
 	}
+	// Read the whole g struct at once and decode its fields from the local
+	// copy, on a live process every ReadMemory call can be a separate
+	// syscall. If this fails each field is read individually.
+	v.mem = preloadMemory(v.mem, v.Addr, int(v.RealType.Size()))
 	v.loadValue(LoadConfig{false, 2, 64, 0, -1, 0})
 	if v.Unreadable != nil {
-		return nil, v.Unreadable
+		return nil, ErrGStructUnreadable{Addr: uint64(v.Addr), Err: v.Unreadable}
 	}
-	schedVar := v.fieldVariable("sched")
-	pc, _ := constant.Int64Val(schedVar.fieldVariable("pc").Value)
-	sp, _ := constant.Int64Val(schedVar.fieldVariable("sp").Value)
-	var bp, lr int64
-	if bpvar := schedVar.fieldVariable("bp"); bpvar != nil && bpvar.Value != nil {
-		bp, _ = constant.Int64Val(bpvar.Value)
-	}
-	if bpvar := schedVar.fieldVariable("lr"); bpvar != nil && bpvar.Value != nil {
-		lr, _ = constant.Int64Val(bpvar.Value)
+	var unknownFields []string
+	if pmem, ok := v.mem.(*partialMemory); ok {
+		if typ, ok := resolveTypedef(v.RealType).(*godwarf.StructType); ok {
+			unknownFields = pmem.unreadableFields(typ)
+		}
 	}
-	id, _ := constant.Int64Val(v.fieldVariable("goid").Value)
-	gopc, _ := constant.Int64Val(v.fieldVariable("gopc").Value)
-	startpc, _ := constant.Int64Val(v.fieldVariable("startpc").Value)
+
+	// Fields are read defensively, if one of them can not be read the error
+	// is recorded in G.Unreadable but the rest of the G struct is still
+	// returned.
+	var unreadable error
+	intField := func(parent *Variable, name string, optional bool) int64 {
+		var fld *Variable
+		if parent != nil {
+			fld = gField(parent, name)
+		}
+		switch {
+		case fld == nil:
+			if !optional && unreadable == nil {
+				unreadable = fmt.Errorf("could not find field %s of g struct", name)
+			}
+			return 0
+		case fld.Unreadable != nil:
+			// optional fields in unreadable memory are left unknown, see
+			// G.UnknownFields.
+			if !optional && unreadable == nil {
+				unreadable = fmt.Errorf("could not read field %s of g struct: %v", name, fld.Unreadable)
+			}
+			return 0
+		case fld.Value == nil || fld.Value.Kind() != constant.Int:
+			return 0
+		}
+		n, _ := constant.Int64Val(fld.Value)
+		return n
+	}
+
+	schedVar := gField(v, "sched")
+	pc := intField(schedVar, "pc", false)
+	sp := intField(schedVar, "sp", false)
+	bp := intField(schedVar, "bp", true)
+	lr := intField(schedVar, "lr", true)
+	id := intField(v, "goid", false)
+	gopc := intField(v, "gopc", false)
+	startpc := intField(v, "startpc", false)
+	var closureCtxt uint64
+	if schedVar != nil {
+		if ctxtvar := gField(schedVar, "ctxt"); ctxtvar != nil && ctxtvar.Unreadable == nil && len(ctxtvar.Children) == 1 {
+			closureCtxt = uint64(ctxtvar.Children[0].Addr)
+		}
+	}
+	syscallpc := intField(v, "syscallpc", true)
+	syscallsp := intField(v, "syscallsp", true)
+	waitsince := intField(v, "waitsince", true)
 	waitReason := ""
-	if wrvar := v.fieldVariable("waitreason"); wrvar.Value != nil {
+	waitReasonCode := WaitReasonUnknown
+	if wrvar := gField(v, "waitreason"); wrvar != nil && wrvar.Unreadable != nil {
+		if unreadable == nil {
+			unreadable = fmt.Errorf("could not read field waitreason of g struct: %v", wrvar.Unreadable)
+		}
+	} else if wrvar != nil && wrvar.Value != nil {
 		switch wrvar.Kind {
 		case reflect.String:
 			waitReason = constant.StringVal(wrvar.Value)
 		case reflect.Uint:
 			waitReason = wrvar.ConstDescr()
+			if n, _ := constant.Uint64Val(wrvar.Value); n == 0 {
+				waitReasonCode = WaitReasonNone
+			} else {
+				waitReasonCode = waitReasonCodes[waitReason]
+			}
 		}
-
 	}
 	var stackhi, stacklo uint64
-	if stackVar := v.fieldVariable("stack"); stackVar != nil {
-		if stackhiVar := stackVar.fieldVariable("hi"); stackhiVar != nil {
-			stackhi, _ = constant.Uint64Val(stackhiVar.Value)
-		}
-		if stackloVar := stackVar.fieldVariable("lo"); stackloVar != nil {
-			stacklo, _ = constant.Uint64Val(stackloVar.Value)
-		}
+	if stackVar := gField(v, "stack"); stackVar != nil {
+		stackhi = uint64(intField(stackVar, "hi", false))
+		stacklo = uint64(intField(stackVar, "lo", false))
 	}
 
 	stkbarVar, _ := v.structMember("stkbar")
-	stkbarVarPosFld := v.fieldVariable("stkbarPos")
-	var stkbarPos int64
-	if stkbarVarPosFld != nil { // stack barriers were removed in Go 1.9
-		stkbarPos, _ = constant.Int64Val(stkbarVarPosFld.Value)
+	stkbarPos := intField(v, "stkbarPos", true) // stack barriers were removed in Go 1.9
+
+	status := intField(v, "atomicstatus", false)
+
+	var flags GFlags
+	for i, name := range gFlagNames {
+		flag := GFlags(1 << uint(i))
+		fld := gField(v, name)
+		if fld == nil || fld.Unreadable != nil || fld.Value == nil {
+			continue
+		}
+		switch fld.Value.Kind() {
+		case constant.Bool:
+			if constant.BoolVal(fld.Value) {
+				flags |= flag
+			}
+		case constant.Int:
+			// raceignore is an int8 counter
+			if n, _ := constant.Int64Val(fld.Value); n != 0 {
+				flags |= flag
+			}
+		}
 	}
 
-	status, _ := constant.Int64Val(v.fieldVariable("atomicstatus").Value)
 	f, l, fn := v.bi.PCToLine(uint64(pc))
+	inStackGrowth := fn != nil && morestackFunctions[fn.Name]
 
 	g := &G{
-		ID:         int(id),
-		GoPC:       uint64(gopc),
-		StartPC:    uint64(startpc),
-		PC:         uint64(pc),
-		SP:         uint64(sp),
-		BP:         uint64(bp),
-		LR:         uint64(lr),
-		WaitReason: waitReason,
-		Status:     uint64(status),
-		CurrentLoc: Location{PC: uint64(pc), File: f, Line: l, Fn: fn},
-		variable:   v,
-		stkbarVar:  stkbarVar,
-		stkbarPos:  int(stkbarPos),
-		stackhi:    stackhi,
-		stacklo:    stacklo,
+		ID:             int(id),
+		goid:           id,
+		GoPC:           uint64(gopc),
+		StartPC:        uint64(startpc),
+		PC:             uint64(pc),
+		SP:             uint64(sp),
+		BP:             uint64(bp),
+		LR:             uint64(lr),
+		SyscallPC:      uint64(syscallpc),
+		SyscallSP:      uint64(syscallsp),
+		WaitReason:     waitReason,
+		WaitReasonCode: waitReasonCode,
+		WaitSince:      waitsince,
+		Status:         uint64(status),
+		Flags:          flags,
+		CurrentLoc:     Location{PC: uint64(pc), File: f, Line: l, Fn: fn},
+		variable:       v,
+		stkbarVar:      stkbarVar,
+		stkbarPos:      int(stkbarPos),
+		stackhi:        stackhi,
+		stacklo:        stacklo,
+		inStackGrowth:  inStackGrowth,
+		closureCtxt:    closureCtxt,
+		UnknownFields:  unknownFields,
+		Unreadable:     unreadable,
+	}
+	if err := g.checkStackBounds(); err != nil && g.Unreadable == nil {
+		g.Unreadable = err
 	}
 	return g, nil
 }
 
+// gFieldAlternates maps the names of the fields of runtime.g read by
+// parseG to the names that the same fields had in other versions of the
+// runtime. Supporting a runtime that renames one of them should only
+// require adding an entry here.
+var gFieldAlternates = map[string][]string{
+	"atomicstatus": {"status"}, // go1.3 and earlier
+}
+
+// gField returns the field called name of v, which must be a runtime.g (or
+// one of its nested structs), trying the names listed in gFieldAlternates
+// if v doesn't have it.
+// Fields using one of the integer wrapper types of runtime/internal/atomic
+// (for example atomicstatus since go1.20) are unwrapped.
+func gField(v *Variable, name string) *Variable {
+	fld := v.fieldVariable(name)
+	for _, alt := range gFieldAlternates[name] {
+		if fld != nil {
+			break
+		}
+		fld = v.fieldVariable(alt)
+	}
+	if fld == nil || fld.Kind != reflect.Struct || fld.RealType == nil {
+		return fld
+	}
+	typename := fld.RealType.String()
+	if i := strings.LastIndex(typename, "/"); i >= 0 {
+		typename = typename[i+1:]
+	}
+	if strings.HasPrefix(typename, "atomic.") {
+		if value := fld.fieldVariable("value"); value != nil {
+			return value
+		}
+	}
+	return fld
+}
+
+// morestackFunctions is the set of functions used by the runtime to grow
+// the stack of a goroutine.
+var morestackFunctions = map[string]bool{
+	"runtime.morestack":        true,
+	"runtime.morestack_noctxt": true,
+	"runtime.morestackc":       true,
+	"runtime.newstack":         true,
+}
+
+// InStackGrowth returns true if the goroutine is growing its stack, either
+// its saved PC or, if it is running, the PC of its thread is inside
+// runtime.morestack or runtime.newstack. Like for goroutines whose status
+// is Gcopystack (see StackBeingCopied) the stack pointer of these
+// goroutines can not be trusted.
+func (g *G) InStackGrowth() bool {
+	if g.inStackGrowth {
+		return true
+	}
+	return g.Thread != nil && g.CurrentLoc.Fn != nil && morestackFunctions[g.CurrentLoc.Fn.Name]
+}
+
+// ClosureContext returns the value of the saved closure context register
+// of the goroutine (field sched.ctxt of the g struct), i.e. the address of
+// the funcval of the closure the goroutine will resume executing. The
+// runtime sets it when a goroutine is created and clears it when the
+// goroutine is scheduled, so it is mostly useful for goroutines that
+// haven't started running yet: goroutines started by the same go statement
+// with different closures have different contexts.
+// If the context is nil false is returned.
+func (g *G) ClosureContext() (uint64, bool) {
+	return g.closureCtxt, g.closureCtxt != 0
+}
+
+// gcBlockedWaitReasons are the wait reasons of goroutines parked by the
+// garbage collector rather than by their own logic.
+var gcBlockedWaitReasons = map[WaitReasonCode]bool{
+	WaitReasonGCAssistMarking:       true,
+	WaitReasonGCAssistWait:          true,
+	WaitReasonGarbageCollection:     true,
+	WaitReasonGarbageCollectionScan: true,
+}
+
+// gcphase values, from: src/runtime/mgc.go
+const (
+	gcphaseOff             = 0
+	gcphaseMark            = 1
+	gcphaseMarkTermination = 2
+)
+
+// BlockedByGC returns true if the goroutine is not running because of the
+// garbage collector rather than because of its own logic: either it was
+// parked to assist the garbage collector or it is runnable (or preempted)
+// while the runtime is stopping the world (runtime.sched.gcwaiting is set
+// or runtime.gcphase is _GCmarktermination).
+func (g *G) BlockedByGC(mem MemoryReadWriter, bi *BinaryInfo) (bool, error) {
+	switch g.Status {
+	case Gwaiting:
+		if gcBlockedWaitReasons[g.WaitReasonCode] {
+			return true, nil
+		}
+		if g.WaitReasonCode != WaitReasonPreempted {
+			return false, nil
+		}
+	case Grunnable, Gpreempted:
+	default:
+		return false, nil
+	}
+
+	if len(bi.Images) == 0 {
+		return false, errors.New("no executable loaded")
+	}
+	scope := globalScope(bi, bi.Images[0], mem)
+	if gcphase, err := readRuntimeGlobalUint(scope, "gcphase"); err == nil && gcphase == gcphaseMarkTermination {
+		return true, nil
+	}
+	sched, err := scope.findGlobal("runtime", "sched")
+	if err != nil {
+		return false, err
+	}
+	gcwaiting, err := sched.structMember("gcwaiting")
+	if err != nil {
+		return false, err
+	}
+	n, err := atomicUintValue(gcwaiting)
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// readRuntimeGlobalUint reads the unsigned integer global variable
+// runtime.name.
+func readRuntimeGlobalUint(scope *EvalScope, name string) (uint64, error) {
+	v, err := scope.findGlobal("runtime", name)
+	if err != nil {
+		return 0, err
+	}
+	return atomicUintValue(v)
+}
+
+// atomicUintValue returns the value of v, an integer or boolean variable
+// or one of the wrapper types of runtime/internal/atomic (for example
+// atomic.Uint32 or atomic.Bool, which wraps an atomic.Uint8).
+func atomicUintValue(v *Variable) (uint64, error) {
+	for {
+		v.loadValue(LoadConfig{false, 0, 0, 0, 0, 0})
+		if v.Unreadable != nil {
+			return 0, v.Unreadable
+		}
+		if v.Kind != reflect.Struct {
+			break
+		}
+		inner, err := v.structMember("value")
+		if err != nil {
+			inner, err = v.structMember("u")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("unsupported type %s for %s", v.TypeString(), v.Name)
+		}
+		v = inner
+	}
+	switch {
+	case v.Value == nil:
+		return 0, fmt.Errorf("could not read %s", v.Name)
+	case v.Value.Kind() == constant.Bool:
+		if constant.BoolVal(v.Value) {
+			return 1, nil
+		}
+		return 0, nil
+	case v.Value.Kind() == constant.Int:
+		n, _ := constant.Uint64Val(v.Value)
+		return n, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s for %s", v.TypeString(), v.Name)
+}
+
+// SyscallLoc returns the location where the goroutine entered the
+// syscall it is executing. For goroutines that are not in a syscall
+// CurrentLoc is returned.
+func (g *G) SyscallLoc() Location {
+	if g.Status != Gsyscall || g.SyscallPC == 0 || g.variable == nil {
+		return g.CurrentLoc
+	}
+	f, l, fn := g.variable.bi.PCToLine(g.SyscallPC)
+	return Location{PC: g.SyscallPC, File: f, Line: l, Fn: fn}
+}
+
+// TimerWait returns the value of runtime.nanotime at which the timer the
+// goroutine is waiting on will fire. Goroutines wait on a timer while
+// sleeping in time.Sleep and, starting with Go 1.23, while receiving from
+// the channel of a time.Timer or time.Ticker. Subtracting the current value
+// of runtime.nanotime in the target from when gives the remaining time,
+// see TimerRemaining.
+// If the goroutine isn't waiting on a timer ok is false.
+func (g *G) TimerWait() (when int64, ok bool, err error) {
+	if g.variable == nil {
+		return 0, false, g.Unreadable
+	}
+	if g.variable.Unreadable != nil {
+		return 0, false, g.variable.Unreadable
+	}
+	if g.Status != Gwaiting {
+		return 0, false, nil
+	}
+	switch g.WaitReasonCode {
+	case WaitReasonSleep:
+		return timerWhen(g.variable.fieldVariable("timer"))
+	case WaitReasonChanReceive, WaitReasonSelect:
+		waiting := g.variable.fieldVariable("waiting")
+		if waiting == nil {
+			return 0, false, nil
+		}
+		if waiting = waiting.maybeDereference(); waiting.Addr == 0 || waiting.Unreadable != nil {
+			return 0, false, waiting.Unreadable
+		}
+		c, err := waiting.structMember("c")
+		if err != nil {
+			return 0, false, nil
+		}
+		c = c.maybeDereference()
+		if c.Addr == 0 || c.Unreadable != nil {
+			return 0, false, c.Unreadable
+		}
+		t, err := c.structMember("timer")
+		if err != nil {
+			// before Go 1.23 channels do not reference their timer
+			return 0, false, nil
+		}
+		return timerWhen(t)
+	}
+	return 0, false, nil
+}
+
+// timerWhen reads the when field of the runtime.timer pointed to by tvar.
+func timerWhen(tvar *Variable) (int64, bool, error) {
+	if tvar == nil {
+		return 0, false, nil
+	}
+	tvar = tvar.maybeDereference()
+	if tvar.Unreadable != nil {
+		return 0, false, tvar.Unreadable
+	}
+	if tvar.Addr == 0 {
+		return 0, false, nil
+	}
+	whenvar, err := tvar.structMember("when")
+	if err != nil {
+		return 0, false, err
+	}
+	whenvar.loadValue(loadFullValue)
+	if whenvar.Unreadable != nil {
+		return 0, false, whenvar.Unreadable
+	}
+	when, _ := constant.Int64Val(whenvar.Value)
+	if when == 0 {
+		return 0, false, nil
+	}
+	return when, true, nil
+}
+
+// WaitDuration returns how long the goroutine has been blocked. Now must
+// be the current value of runtime.nanotime in the target. If the goroutine
+// is not blocked, or the runtime has not recorded when it became blocked
+// yet (see WaitSince), ok is false.
+func (g *G) WaitDuration(now int64) (d time.Duration, ok bool) {
+	if g.Status != Gwaiting || g.WaitSince == 0 || now < g.WaitSince {
+		return 0, false
+	}
+	return time.Duration(now - g.WaitSince), true
+}
+
+// TimerRemaining returns the time left before the timer the goroutine is
+// waiting on fires. Now must be the current value of runtime.nanotime in
+// the target. If the goroutine isn't waiting on a timer ok is false.
+func (g *G) TimerRemaining(now int64) (remaining time.Duration, ok bool, err error) {
+	when, ok, err := g.TimerWait()
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	return time.Duration(when - now), true, nil
+}
+
+// ErrStackBeingCopied is returned when trying to unwind the stack of a
+// goroutine whose stack is being moved by the runtime.
+var ErrStackBeingCopied = errors.New("stack is being copied, try again")
+
+// StackBeingCopied returns true if the runtime is moving the stack of the
+// goroutine (its status is Gcopystack). While this happens the saved SP
+// and PC of the goroutine are in flux and its stack can not be unwound.
+func (g *G) StackBeingCopied() bool {
+	return g.Status == Gcopystack
+}
+
+// StackBoundsValid returns false if the stack bounds of the goroutine are
+// inconsistent, which means that the g struct is corrupted and the stack
+// of the goroutine should not be unwound.
+func (g *G) StackBoundsValid() bool {
+	return g.checkStackBounds() == nil
+}
+
+// SPInStack returns true if sp is inside the stack of the goroutine, i.e.
+// stack.lo <= sp < stack.hi. It always returns false if the stack bounds
+// of the goroutine are not known.
+func (g *G) SPInStack(sp uint64) bool {
+	return g.stackhi != 0 && g.stacklo <= sp && sp < g.stackhi
+}
+
+// checkStackBounds checks that stack.lo <= stack.hi and, for parked
+// goroutines, that the saved SP is inside the stack.
+func (g *G) checkStackBounds() error {
+	if g.stacklo > g.stackhi {
+		return fmt.Errorf("invalid stack bounds [%#x, %#x]", g.stacklo, g.stackhi)
+	}
+	switch g.Status {
+	case Grunnable, Gwaiting:
+		if g.stackhi != 0 && (g.SP < g.stacklo || g.SP > g.stackhi) {
+			return fmt.Errorf("SP %#x outside of stack bounds [%#x, %#x]", g.SP, g.stacklo, g.stackhi)
+		}
+	}
+	return nil
+}
+
+// liveSP returns the current stack pointer of the goroutine, read from
+// the registers of its thread for running goroutines.
+func (g *G) liveSP() (uint64, error) {
+	if g.Thread == nil {
+		return g.SP, nil
+	}
+	regs, err := g.Thread.Registers(false)
+	if err != nil {
+		return 0, err
+	}
+	return regs.SP(), nil
+}
+
+// stackScanChunkSize is the size of the reads done by StackContains and
+// StackHighWater.
+const stackScanChunkSize = 64 * 1024
+
+// StackContains scans the active part of the goroutine's stack, from SP to
+// stack.hi, for pointer sized, aligned, words equal to addr and returns
+// the addresses of the stack slots that contain it.
+// For goroutines running on a thread the current value of the SP register
+// is used.
+func (g *G) StackContains(addr uint64, mem MemoryReadWriter) (bool, []uint64, error) {
+	if g.variable == nil {
+		return false, nil, g.Unreadable
+	}
+	if g.StackBeingCopied() {
+		return false, nil, ErrStackBeingCopied
+	}
+	if err := g.checkStackBounds(); err != nil {
+		return false, nil, err
+	}
+	sp, err := g.liveSP()
+	if err != nil {
+		return false, nil, err
+	}
+	if sp < g.stacklo || sp > g.stackhi {
+		return false, nil, fmt.Errorf("SP %#x outside of stack bounds [%#x, %#x]", sp, g.stacklo, g.stackhi)
+	}
+
+	ptrSize := uint64(g.variable.bi.Arch.PtrSize())
+	sp = (sp + ptrSize - 1) &^ (ptrSize - 1)
+
+	var slots []uint64
+	buf := make([]byte, stackScanChunkSize)
+	for cur := sp; cur+ptrSize <= g.stackhi; {
+		n := g.stackhi - cur
+		if n > stackScanChunkSize {
+			n = stackScanChunkSize
+		}
+		n -= n % ptrSize
+		if _, err := mem.ReadMemory(buf[:n], uintptr(cur)); err != nil {
+			return len(slots) > 0, slots, err
+		}
+		for off := uint64(0); off < n; off += ptrSize {
+			var v uint64
+			if ptrSize == 4 {
+				v = uint64(binary.LittleEndian.Uint32(buf[off:]))
+			} else {
+				v = binary.LittleEndian.Uint64(buf[off:])
+			}
+			if v == addr {
+				slots = append(slots, cur+off)
+			}
+		}
+		cur += n
+	}
+	return len(slots) > 0, slots, nil
+}
+
+// stackPoisonWords are the values found in stack memory that has never
+// been used by the goroutine: memory freshly allocated from the operating
+// system is zeroed, runtimes built with stackPoisonCopy fill stacks with
+// 0xfd bytes.
+var stackPoisonWords = map[uint64]bool{
+	0:                  true,
+	0xfdfdfdfdfdfdfdfd: true,
+}
+
+// StackHighWater estimates the maximum number of bytes of stack the
+// goroutine has used, by scanning its stack upwards from stack.lo for the
+// first word that doesn't contain a poison value (see stackPoisonWords).
+// This is a heuristic: the runtime does not record the maximum stack usage
+// and does not clear stacks when it reuses them, so the result can
+// overestimate the usage of goroutines running on a reused stack or
+// underestimate it if the goroutine wrote zeroes at the bottom of its
+// stack. The result is never lower than the current stack usage.
+func (g *G) StackHighWater(mem MemoryReadWriter) (uint64, error) {
+	if g.variable == nil {
+		return 0, g.Unreadable
+	}
+	if g.StackBeingCopied() {
+		return 0, ErrStackBeingCopied
+	}
+	if err := g.checkStackBounds(); err != nil {
+		return 0, err
+	}
+	sp, err := g.liveSP()
+	if err != nil {
+		return 0, err
+	}
+	var used uint64
+	if sp >= g.stacklo && sp <= g.stackhi {
+		used = g.stackhi - sp
+	}
+
+	ptrSize := uint64(g.variable.bi.Arch.PtrSize())
+	buf := make([]byte, stackScanChunkSize)
+	for cur := g.stacklo; cur+ptrSize <= g.stackhi; {
+		n := g.stackhi - cur
+		if n > stackScanChunkSize {
+			n = stackScanChunkSize
+		}
+		n -= n % ptrSize
+		if _, err := mem.ReadMemory(buf[:n], uintptr(cur)); err != nil {
+			return used, err
+		}
+		for off := uint64(0); off < n; off += ptrSize {
+			var v uint64
+			if ptrSize == 4 {
+				v = uint64(binary.LittleEndian.Uint32(buf[off:]))
+				v |= v << 32
+			} else {
+				v = binary.LittleEndian.Uint64(buf[off:])
+			}
+			if !stackPoisonWords[v] {
+				if hw := g.stackhi - (cur + off); hw > used {
+					used = hw
+				}
+				return used, nil
+			}
+		}
+		cur += n
+	}
+	return used, nil
+}
+
 func (v *Variable) loadFieldNamed(name string) *Variable {
 	v, err := v.structMember(name)
 	if err != nil {
@@ -652,19 +1911,29 @@ func Ancestors(p Process, g *G, n int) ([]Ancestor, error) {
 			return nil, errTracebackAncestorsDisabled
 		}
 	}
+	return g.Ancestors(n)
+}
 
+// Ancestors returns up to max ancestors of the goroutine, as recorded by
+// the runtime in the ancestors field of runtime.g. The runtime only
+// records ancestors when GODEBUG=tracebackancestors=N is set, otherwise an
+// empty slice is returned.
+func (g *G) Ancestors(max int) ([]Ancestor, error) {
+	if g.variable == nil {
+		return nil, errors.New("runtime.g struct of goroutine not available")
+	}
 	av, err := g.variable.structMember("ancestors")
 	if err != nil {
 		return nil, err
 	}
 	av = av.maybeDereference()
-	av.loadValue(LoadConfig{MaxArrayValues: n, MaxVariableRecurse: 1, MaxStructFields: -1})
+	av.loadValue(LoadConfig{MaxArrayValues: max, MaxVariableRecurse: 1, MaxStructFields: -1})
 	if av.Unreadable != nil {
-		return nil, err
+		return nil, av.Unreadable
 	}
 	if av.Addr == 0 {
 		// no ancestors
-		return nil, nil
+		return []Ancestor{}, nil
 	}
 
 	r := make([]Ancestor, len(av.Children))
@@ -675,12 +1944,16 @@ func Ancestors(p Process, g *G, n int) ([]Ancestor, error) {
 			continue
 		}
 		goidv := av.Children[i].fieldVariable("goid")
+		pcsVar := av.Children[i].fieldVariable("pcs")
+		if goidv == nil || pcsVar == nil {
+			r[i].Unreadable = errors.New("unknown layout of runtime.ancestorInfo")
+			continue
+		}
 		if goidv.Unreadable != nil {
 			r[i].Unreadable = goidv.Unreadable
 			continue
 		}
 		r[i].ID, _ = constant.Int64Val(goidv.Value)
-		pcsVar := av.Children[i].fieldVariable("pcs")
 		if pcsVar.Unreadable != nil {
 			r[i].Unreadable = pcsVar.Unreadable
 		}
@@ -693,15 +1966,23 @@ func Ancestors(p Process, g *G, n int) ([]Ancestor, error) {
 }
 
 // Stack returns the stack trace of ancestor 'a' as saved by the runtime.
-func (a *Ancestor) Stack(n int) ([]Stackframe, error) {
+// At most n frames are returned, n is capped to the number of PCs
+// recorded by the runtime.
+func (a *Ancestor) Stack(bi *BinaryInfo, n int) ([]Stackframe, error) {
 	if a.Unreadable != nil {
 		return nil, a.Unreadable
 	}
 	pcsVar := a.pcsVar.clone()
+	if n < 0 || int64(n) > pcsVar.Len {
+		n = int(pcsVar.Len)
+	}
 	pcsVar.loadValue(LoadConfig{MaxArrayValues: n})
 	if pcsVar.Unreadable != nil {
 		return nil, pcsVar.Unreadable
 	}
+	if len(pcsVar.Children) == 0 {
+		return nil, nil
+	}
 	r := make([]Stackframe, len(pcsVar.Children))
 	for i := range pcsVar.Children {
 		if pcsVar.Children[i].Unreadable != nil {
@@ -711,19 +1992,16 @@ func (a *Ancestor) Stack(n int) ([]Stackframe, error) {
 		if pcsVar.Children[i].Kind != reflect.Uint {
 			return nil, fmt.Errorf("wrong type for pcs item %d: %v", i, pcsVar.Children[i].Kind)
 		}
-		pc, _ := constant.Int64Val(pcsVar.Children[i].Value)
-		fn := a.pcsVar.bi.PCToFunc(uint64(pc))
+		pc, _ := constant.Uint64Val(pcsVar.Children[i].Value)
+		fn := bi.PCToFunc(pc)
 		if fn == nil {
-			loc := Location{PC: uint64(pc)}
+			loc := Location{PC: pc}
 			r[i] = Stackframe{Current: loc, Call: loc}
 			continue
 		}
-		pc2 := uint64(pc)
-		if pc2-1 >= fn.Entry {
-			pc2--
-		}
-		f, ln := fn.cu.lineInfo.PCToLine(fn.Entry, pc2)
-		loc := Location{PC: uint64(pc), File: f, Line: ln, Fn: fn}
+		// The saved PCs are return addresses.
+		f, ln, _ := bi.PCToLine(CallSitePC(bi, pc))
+		loc := Location{PC: pc, File: f, Line: ln, Fn: fn}
 		r[i] = Stackframe{Current: loc, Call: loc}
 	}
 	r[len(r)-1].Bottom = pcsVar.Len == int64(len(pcsVar.Children))
@@ -1061,22 +2339,20 @@ func readStringInfo(mem MemoryReadWriter, arch Arch, addr uintptr) (uintptr, int
 	mem = cacheMemory(mem, addr, arch.PtrSize()*2)
 
 	// read len
-	val := make([]byte, arch.PtrSize())
-	_, err := mem.ReadMemory(val, addr+uintptr(arch.PtrSize()))
+	strlen, err := readIntRaw(mem, addr+uintptr(arch.PtrSize()), int64(arch.PtrSize()))
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not read string len %s", err)
 	}
-	strlen := int64(binary.LittleEndian.Uint64(val))
 	if strlen < 0 {
 		return 0, 0, fmt.Errorf("invalid length: %d", strlen)
 	}
 
 	// read addr
-	_, err = mem.ReadMemory(val, addr)
+	straddr, err := readUintRaw(mem, addr, int64(arch.PtrSize()))
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not read string pointer %s", err)
 	}
-	addr = uintptr(binary.LittleEndian.Uint64(val))
+	addr = uintptr(straddr)
 	if addr == 0 {
 		return 0, 0, nil
 	}
@@ -1324,7 +2600,9 @@ func (v *Variable) writeUint(value uint64, size int64) error {
 func readUintRaw(mem MemoryReadWriter, addr uintptr, size int64) (uint64, error) {
 	var n uint64
 
-	val := make([]byte, int(size))
+	bufp := getReadBuf(int(size))
+	defer putReadBuf(bufp)
+	val := *bufp
 	_, err := mem.ReadMemory(val, addr)
 	if err != nil {
 		return 0, err
@@ -1457,14 +2735,13 @@ func (v *Variable) readFunctionPtr() {
 		return
 	}
 
-	val := make([]byte, v.bi.Arch.PtrSize())
-	_, err := v.mem.ReadMemory(val, uintptr(v.closureAddr))
+	base, err := readUintRaw(v.mem, uintptr(v.closureAddr), int64(v.bi.Arch.PtrSize()))
 	if err != nil {
 		v.Unreadable = err
 		return
 	}
 
-	v.Base = uintptr(binary.LittleEndian.Uint64(val))
+	v.Base = uintptr(base)
 	fn := v.bi.PCToFunc(uint64(v.Base))
 	if fn == nil {
 		v.Unreadable = fmt.Errorf("could not find function for %#v", v.Base)
@@ -1476,13 +2753,12 @@ func (v *Variable) readFunctionPtr() {
 
 // funcvalAddr reads the address of the funcval contained in a function variable.
 func (v *Variable) funcvalAddr() uint64 {
-	val := make([]byte, v.bi.Arch.PtrSize())
-	_, err := v.mem.ReadMemory(val, v.Addr)
+	addr, err := readUintRaw(v.mem, v.Addr, int64(v.bi.Arch.PtrSize()))
 	if err != nil {
 		v.Unreadable = err
 		return 0
 	}
-	return binary.LittleEndian.Uint64(val)
+	return addr
 }
 
 func (v *Variable) loadMap(recurseLevel int, cfg LoadConfig) {
@@ -836,7 +836,7 @@ func formatGoroutine(g *api.Goroutine, fgl formatGoroutineLoc) string {
 	if g == nil {
 		return "<nil>"
 	}
-	if g.Unreadable != "" {
+	if g.Unreadable != "" && g.ID == 0 {
 		return fmt.Sprintf("(unreadable %s)", g.Unreadable)
 	}
 	var locname string
@@ -859,6 +859,10 @@ func formatGoroutine(g *api.Goroutine, fgl formatGoroutineLoc) string {
 	if g.ThreadID != 0 {
 		thread = fmt.Sprintf(" (thread %d)", g.ThreadID)
 	}
+	if g.Unreadable != "" {
+		// the goroutine was only partially read
+		thread += fmt.Sprintf(" (unreadable %s)", g.Unreadable)
+	}
 	return fmt.Sprintf("%d - %s: %s%s", g.ID, locname, formatLocation(loc), thread)
 }
 
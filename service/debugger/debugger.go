@@ -1112,7 +1112,7 @@ func (d *Debugger) Ancestors(goroutineID, numAncestors, depth int) ([]api.Ancest
 			r[i].Unreadable = ancestors[i].Unreadable.Error()
 			continue
 		}
-		frames, err := ancestors[i].Stack(depth)
+		frames, err := ancestors[i].Stack(d.target.BinInfo(), depth)
 		if err != nil {
 			r[i].Unreadable = fmt.Sprintf("could not read ancestor stacktrace: %v", err)
 			continue